@@ -1,16 +1,27 @@
 package agent
 
 import (
+	"bytes"
 	"crypto/sha1"
 	"encoding/base64"
 	"errors"
+	"fmt"
+	"net"
+	"reflect"
+	"sort"
+	"time"
 
 	"golang.org/x/crypto/pbkdf2"
 
 	"github.com/hashicorp/consul/api"
+	"github.com/miekg/dns"
 	"github.com/pivotal-golang/lager"
 )
 
+// consul registers this check while the node is in maintenance mode and
+// keeps it in the data dir across restarts
+const nodeMaintenanceCheckID = "_node_maintenance"
+
 type logger interface {
 	Info(action string, data ...lager.Data)
 	Error(action string, err error, data ...lager.Data)
@@ -18,6 +29,34 @@ type logger interface {
 
 type consulAPIAgent interface {
 	Members(wan bool) ([]*api.AgentMember, error)
+	Services() (map[string]*api.AgentService, error)
+	Checks() (map[string]*api.AgentCheck, error)
+	EnableNodeMaintenance(reason string) error
+	DisableNodeMaintenance() error
+	EnableServiceMaintenance(serviceID, reason string) error
+	DisableServiceMaintenance(serviceID string) error
+	ForceLeave(node string) error
+}
+
+type consulAPIStatus interface {
+	Leader() (string, error)
+	Peers() ([]string, error)
+}
+
+type consulAPIPreparedQuery interface {
+	List(q *api.QueryOptions) ([]*api.PreparedQueryDefinition, *api.QueryMeta, error)
+	Create(query *api.PreparedQueryDefinition, q *api.WriteOptions) (string, *api.WriteMeta, error)
+	Update(query *api.PreparedQueryDefinition, q *api.WriteOptions) (*api.WriteMeta, error)
+	Delete(queryID string, q *api.QueryOptions) (*api.QueryMeta, error)
+}
+
+type consulAPIKV interface {
+	Get(key string, q *api.QueryOptions) (*api.KVPair, *api.QueryMeta, error)
+	CAS(p *api.KVPair, q *api.WriteOptions) (bool, *api.WriteMeta, error)
+}
+
+type dnsClient interface {
+	Exchange(m *dns.Msg, a string) (*dns.Msg, time.Duration, error)
 }
 
 type consulRPCClient interface {
@@ -30,46 +69,34 @@ type consulRPCClient interface {
 }
 
 type Client struct {
-	ExpectedMembers []string
-	ConsulAPIAgent  consulAPIAgent
-	ConsulRPCClient consulRPCClient
-	Logger          logger
+	ExpectedMembers        []string
+	ConsulAPIAgent         consulAPIAgent
+	ConsulAPIStatus        consulAPIStatus
+	ConsulAPIPreparedQuery consulAPIPreparedQuery
+	ConsulAPIKV            consulAPIKV
+	ConsulRPCClient        consulRPCClient
+	DNSClient              dnsClient
+	DNSAddress             string
+	Logger                 logger
 }
 
 func (c Client) VerifyJoined() error {
-	c.Logger.Info("agent-client.verify-joined.members.request", lager.Data{
-		"wan": false,
-	})
-
-	members, err := c.ConsulAPIAgent.Members(false)
+	members, err := c.members("verify-joined")
 	if err != nil {
-		c.Logger.Error("agent-client.verify-joined.members.request.failed", err, lager.Data{
-			"wan": false,
-		})
 		return err
 	}
 
-	var addresses []string
-	for _, member := range members {
-		addresses = append(addresses, member.Addr)
-	}
-
-	c.Logger.Info("agent-client.verify-joined.members.response", lager.Data{
-		"wan":     false,
-		"members": addresses,
-	})
-
-	for _, member := range members {
-		if member.Tags["role"] == "consul" {
-			c.Logger.Info("agent-client.verify-joined.members.joined")
-			return nil
-		}
+	if servers := AliveServers(members); len(servers) > 0 {
+		c.Logger.Info("agent-client.verify-joined.members.joined", lager.Data{
+			"servers": servers,
+		})
+		return nil
 	}
 
 	err = errors.New("no expected members")
 	c.Logger.Error("agent-client.verify-joined.members.not-joined", err, lager.Data{
 		"wan":     false,
-		"members": addresses,
+		"members": members,
 	})
 
 	return err
@@ -108,45 +135,172 @@ func (c Client) VerifySynced() error {
 	return nil
 }
 
-func (c Client) IsLastNode() (bool, error) {
-	c.Logger.Info("agent-client.is-last-node.members.request", lager.Data{
-		"wan": false,
+func (c Client) VerifyLeader() error {
+	c.Logger.Info("agent-client.verify-leader.leader.request")
+
+	leader, err := c.ConsulAPIStatus.Leader()
+	if err != nil {
+		c.Logger.Error("agent-client.verify-leader.leader.request.failed", err)
+		return err
+	}
+
+	c.Logger.Info("agent-client.verify-leader.leader.response", lager.Data{
+		"leader": leader,
 	})
 
-	members, err := c.ConsulAPIAgent.Members(false)
+	if leader == "" {
+		err = errors.New("no known leader")
+		c.Logger.Error("agent-client.verify-leader.no-leader", err)
+		return err
+	}
+
+	c.Logger.Info("agent-client.verify-leader.success")
+	return nil
+}
+
+func (c Client) VerifyServices(serviceIDs []string) error {
+	c.Logger.Info("agent-client.verify-services.services.request")
+
+	services, err := c.ConsulAPIAgent.Services()
 	if err != nil {
-		c.Logger.Error("agent-client.is-last-node.members.request.failed", err, lager.Data{
-			"wan": false,
-		})
-		return false, err
+		c.Logger.Error("agent-client.verify-services.services.request.failed", err)
+		return err
 	}
 
-	var addresses []string
-	for _, member := range members {
-		addresses = append(addresses, member.Addr)
+	for _, id := range serviceIDs {
+		if _, ok := services[id]; !ok {
+			err = fmt.Errorf("service %q is not registered", id)
+			c.Logger.Error("agent-client.verify-services.not-registered", err, lager.Data{
+				"service": id,
+			})
+			return err
+		}
 	}
 
-	c.Logger.Info("agent-client.is-last-node.members.response", lager.Data{
-		"wan":     false,
-		"members": addresses,
+	c.Logger.Info("agent-client.verify-services.checks.request")
+
+	checks, err := c.ConsulAPIAgent.Checks()
+	if err != nil {
+		c.Logger.Error("agent-client.verify-services.checks.request.failed", err)
+		return err
+	}
+
+	for _, check := range checks {
+		if !containsString(serviceIDs, check.ServiceID) {
+			continue
+		}
+
+		if check.Status != "passing" {
+			err = fmt.Errorf("check %q for service %q is %s", check.CheckID, check.ServiceID, check.Status)
+			c.Logger.Error("agent-client.verify-services.not-passing", err, lager.Data{
+				"service": check.ServiceID,
+				"check":   check.CheckID,
+				"status":  check.Status,
+				"output":  check.Output,
+			})
+			return err
+		}
+	}
+
+	c.Logger.Info("agent-client.verify-services.success", lager.Data{
+		"services": serviceIDs,
 	})
+	return nil
+}
 
-	var serversCount int
-	for _, member := range members {
-		if member.Tags["role"] == "consul" {
-			serversCount++
+func (c Client) VerifyDNS(name string) error {
+	c.Logger.Info("agent-client.verify-dns.query.request", lager.Data{
+		"name":    name,
+		"address": c.DNSAddress,
+	})
+
+	query := new(dns.Msg)
+	query.SetQuestion(dns.Fqdn(name), dns.TypeA)
+
+	response, _, err := c.DNSClient.Exchange(query, c.DNSAddress)
+	if err != nil {
+		c.Logger.Error("agent-client.verify-dns.query.request.failed", err, lager.Data{
+			"name":    name,
+			"address": c.DNSAddress,
+		})
+		return err
+	}
+
+	c.Logger.Info("agent-client.verify-dns.query.response", lager.Data{
+		"name":    name,
+		"rcode":   dns.RcodeToString[response.Rcode],
+		"answers": len(response.Answer),
+	})
+
+	if response.Rcode != dns.RcodeSuccess {
+		err = fmt.Errorf("dns query for %q failed: %s", name, dns.RcodeToString[response.Rcode])
+		c.Logger.Error("agent-client.verify-dns.query.failed", err)
+		return err
+	}
+
+	if len(response.Answer) == 0 {
+		err = fmt.Errorf("dns query for %q returned no answers", name)
+		c.Logger.Error("agent-client.verify-dns.no-answers", err)
+		return err
+	}
+
+	c.Logger.Info("agent-client.verify-dns.success")
+	return nil
+}
+
+type LastNodeResult struct {
+	IsLastNode bool
+	Missing    []string
+	Unexpected []string
+}
+
+func (c Client) IsLastNode() (LastNodeResult, error) {
+	members, err := c.members("is-last-node")
+	if err != nil {
+		return LastNodeResult{}, err
+	}
+
+	// ExpectedMembers may hold addresses or node names depending on how the
+	// lan servers are configured, so a server matches on either.
+	expected := map[string]bool{}
+	for _, member := range c.ExpectedMembers {
+		expected[member] = true
+	}
+
+	servers := AliveServers(members)
+	found := map[string]bool{}
+	result := LastNodeResult{
+		Missing:    []string{},
+		Unexpected: []string{},
+	}
+	for _, member := range servers {
+		switch {
+		case expected[member.Addr]:
+			found[member.Addr] = true
+		case expected[member.Name]:
+			found[member.Name] = true
+		default:
+			result.Unexpected = append(result.Unexpected, member.Addr)
+		}
+	}
+
+	for _, member := range c.ExpectedMembers {
+		if !found[member] {
+			result.Missing = append(result.Missing, member)
 		}
 	}
 
-	hasAllExpectedMembers := serversCount == len(c.ExpectedMembers)
+	result.IsLastNode = len(result.Missing) == 0 && len(result.Unexpected) == 0
 
 	c.Logger.Info("agent-client.is-last-node.result", lager.Data{
-		"actual_members_count":   serversCount,
+		"actual_members_count":   len(servers),
 		"expected_members_count": len(c.ExpectedMembers),
-		"is_last_node":           hasAllExpectedMembers,
+		"missing":                result.Missing,
+		"unexpected":             result.Unexpected,
+		"is_last_node":           result.IsLastNode,
 	})
 
-	return hasAllExpectedMembers, nil
+	return result, nil
 }
 
 func (c Client) SetKeys(keys []string) error {
@@ -260,6 +414,371 @@ func (c Client) Leave() error {
 	return nil
 }
 
+func (c Client) RaftPeers() ([]string, error) {
+	c.Logger.Info("agent-client.raft-peers.request")
+
+	peers, err := c.ConsulAPIStatus.Peers()
+	if err != nil {
+		c.Logger.Error("agent-client.raft-peers.request.failed", err)
+		return nil, err
+	}
+
+	c.Logger.Info("agent-client.raft-peers.response", lager.Data{
+		"peers": peers,
+	})
+	return peers, nil
+}
+
+// RemovePeer force-leaves the server behind a raft peer address so that the
+// leader drops it from the peer set. Servers that are still alive are logged
+// and left in place, since force-leaving them would do nothing but flap.
+func (c Client) RemovePeer(peer string) error {
+	host := peer
+	if h, _, err := net.SplitHostPort(peer); err == nil {
+		host = h
+	}
+
+	members, err := c.members("remove-peer")
+	if err != nil {
+		return err
+	}
+
+	for _, member := range members {
+		if member.Addr != host || !member.IsServer() {
+			continue
+		}
+
+		if member.IsAlive() {
+			c.Logger.Info("agent-client.remove-peer.alive", lager.Data{
+				"peer": peer,
+				"node": member.Name,
+			})
+			return nil
+		}
+
+		c.Logger.Info("agent-client.remove-peer.force-leave.request", lager.Data{
+			"peer": peer,
+			"node": member.Name,
+		})
+
+		if err := c.ConsulAPIAgent.ForceLeave(member.Name); err != nil {
+			c.Logger.Error("agent-client.remove-peer.force-leave.request.failed", err, lager.Data{
+				"peer": peer,
+				"node": member.Name,
+			})
+			return err
+		}
+
+		c.Logger.Info("agent-client.remove-peer.success", lager.Data{
+			"peer": peer,
+			"node": member.Name,
+		})
+		return nil
+	}
+
+	// without a serf member there is nothing to force-leave; the peer has to
+	// be removed by hand with peers.json
+	c.Logger.Info("agent-client.remove-peer.not-found", lager.Data{
+		"peer": peer,
+	})
+	return nil
+}
+
+func (c Client) Members() ([]Member, error) {
+	return c.members("members")
+}
+
+func (c Client) AliveServers() ([]Member, error) {
+	members, err := c.members("alive-servers")
+	if err != nil {
+		return nil, err
+	}
+
+	return AliveServers(members), nil
+}
+
+func (c Client) FailedMembers() ([]Member, error) {
+	members, err := c.members("failed-members")
+	if err != nil {
+		return nil, err
+	}
+
+	return FailedMembers(members), nil
+}
+
+func (c Client) MembersByDatacenter() (map[string][]Member, error) {
+	members, err := c.members("members-by-datacenter")
+	if err != nil {
+		return nil, err
+	}
+
+	return MembersByDatacenter(members), nil
+}
+
+func (c Client) members(action string) ([]Member, error) {
+	c.Logger.Info(fmt.Sprintf("agent-client.%s.members.request", action), lager.Data{
+		"wan": false,
+	})
+
+	agentMembers, err := c.ConsulAPIAgent.Members(false)
+	if err != nil {
+		c.Logger.Error(fmt.Sprintf("agent-client.%s.members.request.failed", action), err, lager.Data{
+			"wan": false,
+		})
+		return nil, err
+	}
+
+	members := []Member{}
+	for _, member := range agentMembers {
+		members = append(members, NewMember(member))
+	}
+
+	c.Logger.Info(fmt.Sprintf("agent-client.%s.members.response", action), lager.Data{
+		"wan":     false,
+		"members": members,
+	})
+
+	return members, nil
+}
+
+func (c Client) EnableMaintenance(serviceID, reason string) error {
+	c.Logger.Info("agent-client.enable-maintenance.request", lager.Data{
+		"service": serviceID,
+		"reason":  reason,
+	})
+
+	var err error
+	if serviceID == "" {
+		err = c.ConsulAPIAgent.EnableNodeMaintenance(reason)
+	} else {
+		err = c.ConsulAPIAgent.EnableServiceMaintenance(serviceID, reason)
+	}
+	if err != nil {
+		c.Logger.Error("agent-client.enable-maintenance.request.failed", err, lager.Data{
+			"service": serviceID,
+		})
+		return err
+	}
+
+	c.Logger.Info("agent-client.enable-maintenance.response", lager.Data{
+		"service": serviceID,
+	})
+	return nil
+}
+
+func (c Client) DisableMaintenance(serviceID string) error {
+	c.Logger.Info("agent-client.disable-maintenance.request", lager.Data{
+		"service": serviceID,
+	})
+
+	var err error
+	if serviceID == "" {
+		err = c.ConsulAPIAgent.DisableNodeMaintenance()
+	} else {
+		err = c.ConsulAPIAgent.DisableServiceMaintenance(serviceID)
+	}
+	if err != nil {
+		c.Logger.Error("agent-client.disable-maintenance.request.failed", err, lager.Data{
+			"service": serviceID,
+		})
+		return err
+	}
+
+	c.Logger.Info("agent-client.disable-maintenance.response", lager.Data{
+		"service": serviceID,
+	})
+	return nil
+}
+
+// NodeMaintenanceReason returns the reason the node was put into maintenance
+// mode, or an empty string when it is not in maintenance.
+func (c Client) NodeMaintenanceReason() (string, error) {
+	c.Logger.Info("agent-client.node-maintenance-reason.checks.request")
+
+	checks, err := c.ConsulAPIAgent.Checks()
+	if err != nil {
+		c.Logger.Error("agent-client.node-maintenance-reason.checks.request.failed", err)
+		return "", err
+	}
+
+	var reason string
+	if check, ok := checks[nodeMaintenanceCheckID]; ok {
+		reason = check.Notes
+	}
+
+	c.Logger.Info("agent-client.node-maintenance-reason.checks.response", lager.Data{
+		"reason": reason,
+	})
+	return reason, nil
+}
+
+// ReconcilePreparedQueries expects the queries to have been validated; names
+// must be set and unique.
+func (c Client) ReconcilePreparedQueries(queries []api.PreparedQueryDefinition) error {
+	declared := map[string]bool{}
+	for _, query := range queries {
+		declared[query.Name] = true
+	}
+
+	c.Logger.Info("agent-client.reconcile-prepared-queries.list.request")
+	existingQueries, _, err := c.ConsulAPIPreparedQuery.List(nil)
+	if err != nil {
+		c.Logger.Error("agent-client.reconcile-prepared-queries.list.request.failed", err)
+		return err
+	}
+	c.Logger.Info("agent-client.reconcile-prepared-queries.list.response")
+
+	existing := map[string]*api.PreparedQueryDefinition{}
+	for _, query := range existingQueries {
+		if query.Name != "" {
+			existing[query.Name] = query
+		}
+	}
+
+	for _, query := range queries {
+		query := query
+		current, ok := existing[query.Name]
+
+		switch {
+		case !ok:
+			c.Logger.Info("agent-client.reconcile-prepared-queries.create.request", lager.Data{
+				"name": query.Name,
+			})
+			if _, _, err := c.ConsulAPIPreparedQuery.Create(&query, nil); err != nil {
+				c.Logger.Error("agent-client.reconcile-prepared-queries.create.request.failed", err, lager.Data{
+					"name": query.Name,
+				})
+				return err
+			}
+		case !reflect.DeepEqual(normalizeServiceQuery(current.Service), normalizeServiceQuery(query.Service)) || current.DNS != query.DNS:
+			query.ID = current.ID
+			c.Logger.Info("agent-client.reconcile-prepared-queries.update.request", lager.Data{
+				"name": query.Name,
+				"id":   query.ID,
+			})
+			if _, err := c.ConsulAPIPreparedQuery.Update(&query, nil); err != nil {
+				c.Logger.Error("agent-client.reconcile-prepared-queries.update.request.failed", err, lager.Data{
+					"name": query.Name,
+					"id":   query.ID,
+				})
+				return err
+			}
+		}
+	}
+
+	var stale []string
+	for name := range existing {
+		if !declared[name] {
+			stale = append(stale, name)
+		}
+	}
+	sort.Strings(stale)
+
+	for _, name := range stale {
+		id := existing[name].ID
+		c.Logger.Info("agent-client.reconcile-prepared-queries.delete.request", lager.Data{
+			"name": name,
+			"id":   id,
+		})
+		if _, err := c.ConsulAPIPreparedQuery.Delete(id, nil); err != nil {
+			c.Logger.Error("agent-client.reconcile-prepared-queries.delete.request.failed", err, lager.Data{
+				"name": name,
+				"id":   id,
+			})
+			return err
+		}
+	}
+
+	c.Logger.Info("agent-client.reconcile-prepared-queries.success")
+	return nil
+}
+
+// normalizeServiceQuery treats missing and empty lists alike, since consul
+// returns empty lists for queries that were created with none.
+func normalizeServiceQuery(query api.ServiceQuery) api.ServiceQuery {
+	if query.Tags == nil {
+		query.Tags = []string{}
+	}
+
+	if query.Failover.Datacenters == nil {
+		query.Failover.Datacenters = []string{}
+	}
+
+	return query
+}
+
+func (c Client) SeedKV(pairs []api.KVPair, enforce bool) error {
+	var created, updated, unchanged, skipped []string
+
+	for _, pair := range pairs {
+		pair := pair
+
+		c.Logger.Info("agent-client.seed-kv.get.request", lager.Data{
+			"key": pair.Key,
+		})
+		current, _, err := c.ConsulAPIKV.Get(pair.Key, nil)
+		if err != nil {
+			c.Logger.Error("agent-client.seed-kv.get.request.failed", err, lager.Data{
+				"key": pair.Key,
+			})
+			return err
+		}
+
+		if current != nil {
+			if bytes.Equal(current.Value, pair.Value) && current.Flags == pair.Flags {
+				unchanged = append(unchanged, pair.Key)
+				continue
+			}
+
+			if !enforce {
+				skipped = append(skipped, pair.Key)
+				continue
+			}
+
+			pair.ModifyIndex = current.ModifyIndex
+		}
+
+		c.Logger.Info("agent-client.seed-kv.cas.request", lager.Data{
+			"key":          pair.Key,
+			"modify_index": pair.ModifyIndex,
+		})
+		ok, _, err := c.ConsulAPIKV.CAS(&pair, nil)
+		if err != nil {
+			c.Logger.Error("agent-client.seed-kv.cas.request.failed", err, lager.Data{
+				"key": pair.Key,
+			})
+			return err
+		}
+
+		if !ok {
+			if !enforce && current == nil {
+				skipped = append(skipped, pair.Key)
+				continue
+			}
+
+			err = fmt.Errorf("key %q was modified concurrently", pair.Key)
+			c.Logger.Error("agent-client.seed-kv.cas.conflict", err, lager.Data{
+				"key": pair.Key,
+			})
+			return err
+		}
+
+		if current == nil {
+			created = append(created, pair.Key)
+		} else {
+			updated = append(updated, pair.Key)
+		}
+	}
+
+	c.Logger.Info("agent-client.seed-kv.success", lager.Data{
+		"created":   created,
+		"updated":   updated,
+		"unchanged": unchanged,
+		"skipped":   skipped,
+	})
+	return nil
+}
+
 func containsString(elems []string, elem string) bool {
 	for _, e := range elems {
 		if elem == e {
@@ -6,6 +6,7 @@ import (
 	"errors"
 
 	"github.com/hashicorp/consul/api"
+	"github.com/miekg/dns"
 	"github.com/pivotal-golang/lager"
 
 	. "github.com/pivotal-cf-experimental/gomegamatchers"
@@ -17,19 +18,32 @@ import (
 var _ = Describe("Client", func() {
 	var (
 		consulAPIAgent  *fakes.FakeconsulAPIAgent
+		consulAPIStatus *fakes.FakeconsulAPIStatus
+		preparedQuery   *fakes.FakeconsulAPIPreparedQuery
+		consulAPIKV     *fakes.FakeconsulAPIKV
 		consulRPCClient *fakes.FakeconsulRPCClient
+		dnsClient       *fakes.FakednsClient
 		logger          *fakes.Logger
 		client          agent.Client
 	)
 
 	BeforeEach(func() {
 		consulAPIAgent = &fakes.FakeconsulAPIAgent{}
+		consulAPIStatus = &fakes.FakeconsulAPIStatus{}
+		preparedQuery = &fakes.FakeconsulAPIPreparedQuery{}
+		consulAPIKV = &fakes.FakeconsulAPIKV{}
 		consulRPCClient = &fakes.FakeconsulRPCClient{}
+		dnsClient = &fakes.FakednsClient{}
 		logger = &fakes.Logger{}
 		client = agent.Client{
-			ConsulAPIAgent:  consulAPIAgent,
-			ConsulRPCClient: consulRPCClient,
-			Logger:          logger,
+			ConsulAPIAgent:         consulAPIAgent,
+			ConsulAPIStatus:        consulAPIStatus,
+			ConsulAPIPreparedQuery: preparedQuery,
+			ConsulAPIKV:            consulAPIKV,
+			ConsulRPCClient:        consulRPCClient,
+			DNSClient:              dnsClient,
+			DNSAddress:             "127.0.0.1:53",
+			Logger:                 logger,
 		}
 	})
 
@@ -39,19 +53,22 @@ var _ = Describe("Client", func() {
 				client.ExpectedMembers = []string{"member1", "member2", "member3"}
 				consulAPIAgent.MembersReturns([]*api.AgentMember{
 					&api.AgentMember{
-						Addr: "member1",
+						Addr:   "member1",
+						Status: 1,
 						Tags: map[string]string{
 							"role": "consul",
 						},
 					},
 					&api.AgentMember{
-						Addr: "member2",
+						Addr:   "member2",
+						Status: 1,
 						Tags: map[string]string{
 							"role": "consul",
 						},
 					},
 					&api.AgentMember{
-						Addr: "member3",
+						Addr:   "member3",
+						Status: 1,
 						Tags: map[string]string{
 							"role": "consul",
 						},
@@ -61,6 +78,12 @@ var _ = Describe("Client", func() {
 				Expect(client.VerifyJoined()).To(Succeed())
 				Expect(consulAPIAgent.MembersArgsForCall(0)).To(BeFalse())
 
+				servers := []agent.Member{
+					{Addr: "member1", Status: agent.MemberStatusAlive, Role: "consul"},
+					{Addr: "member2", Status: agent.MemberStatusAlive, Role: "consul"},
+					{Addr: "member3", Status: agent.MemberStatusAlive, Role: "consul"},
+				}
+
 				Expect(logger.Messages).To(ContainSequence([]fakes.LoggerMessage{
 					{
 						Action: "agent-client.verify-joined.members.request",
@@ -72,11 +95,14 @@ var _ = Describe("Client", func() {
 						Action: "agent-client.verify-joined.members.response",
 						Data: []lager.Data{{
 							"wan":     false,
-							"members": []string{"member1", "member2", "member3"},
+							"members": servers,
 						}},
 					},
 					{
 						Action: "agent-client.verify-joined.members.joined",
+						Data: []lager.Data{{
+							"servers": servers,
+						}},
 					},
 				}))
 			})
@@ -93,6 +119,11 @@ var _ = Describe("Client", func() {
 				Expect(client.VerifyJoined()).To(MatchError("no expected members"))
 				Expect(consulAPIAgent.MembersArgsForCall(0)).To(BeFalse())
 
+				members := []agent.Member{
+					{Addr: "member4"},
+					{Addr: "member5"},
+				}
+
 				Expect(logger.Messages).To(ContainSequence([]fakes.LoggerMessage{
 					{
 						Action: "agent-client.verify-joined.members.request",
@@ -104,7 +135,7 @@ var _ = Describe("Client", func() {
 						Action: "agent-client.verify-joined.members.response",
 						Data: []lager.Data{{
 							"wan":     false,
-							"members": []string{"member4", "member5"},
+							"members": members,
 						}},
 					},
 					{
@@ -112,13 +143,25 @@ var _ = Describe("Client", func() {
 						Error:  errors.New("no expected members"),
 						Data: []lager.Data{{
 							"wan":     false,
-							"members": []string{"member4", "member5"},
+							"members": members,
 						}},
 					},
 				}))
 			})
 		})
 
+		Context("when the only servers have left or failed", func() {
+			It("returns an error", func() {
+				consulAPIAgent.MembersReturns([]*api.AgentMember{
+					&api.AgentMember{Addr: "member1", Status: 3, Tags: map[string]string{"role": "consul"}},
+					&api.AgentMember{Addr: "member2", Status: 4, Tags: map[string]string{"role": "consul"}},
+					&api.AgentMember{Addr: "member3", Status: 1, Tags: map[string]string{"role": "node"}},
+				}, nil)
+
+				Expect(client.VerifyJoined()).To(MatchError("no expected members"))
+			})
+		})
+
 		Context("when the members call fails", func() {
 			It("returns an error", func() {
 				consulAPIAgent.MembersReturns([]*api.AgentMember{}, errors.New("members call error"))
@@ -261,19 +304,348 @@ var _ = Describe("Client", func() {
 		})
 	})
 
+	Describe("VerifyLeader", func() {
+		It("succeeds when the cluster has a known leader", func() {
+			consulAPIStatus.LeaderReturns("10.0.0.1:8300", nil)
+
+			Expect(client.VerifyLeader()).To(Succeed())
+			Expect(consulAPIStatus.LeaderCallCount()).To(Equal(1))
+			Expect(logger.Messages).To(ContainSequence([]fakes.LoggerMessage{
+				{
+					Action: "agent-client.verify-leader.leader.request",
+				},
+				{
+					Action: "agent-client.verify-leader.leader.response",
+					Data: []lager.Data{{
+						"leader": "10.0.0.1:8300",
+					}},
+				},
+				{
+					Action: "agent-client.verify-leader.success",
+				},
+			}))
+		})
+
+		Context("when there is no known leader", func() {
+			It("returns an error", func() {
+				consulAPIStatus.LeaderReturns("", nil)
+
+				Expect(client.VerifyLeader()).To(MatchError("no known leader"))
+				Expect(logger.Messages).To(ContainSequence([]fakes.LoggerMessage{
+					{
+						Action: "agent-client.verify-leader.no-leader",
+						Error:  errors.New("no known leader"),
+					},
+				}))
+			})
+		})
+
+		Context("when the leader call fails", func() {
+			It("returns an error", func() {
+				consulAPIStatus.LeaderReturns("", errors.New("leader error"))
+
+				Expect(client.VerifyLeader()).To(MatchError("leader error"))
+				Expect(logger.Messages).To(ContainSequence([]fakes.LoggerMessage{
+					{
+						Action: "agent-client.verify-leader.leader.request.failed",
+						Error:  errors.New("leader error"),
+					},
+				}))
+			})
+		})
+	})
+
+	Describe("member queries", func() {
+		BeforeEach(func() {
+			consulAPIAgent.MembersReturns([]*api.AgentMember{
+				{Name: "consul-z1-0", Addr: "10.0.0.1", Status: 1, Tags: map[string]string{"role": "consul", "dc": "dc1"}},
+				{Name: "consul-z1-1", Addr: "10.0.0.2", Status: 4, Tags: map[string]string{"role": "consul", "dc": "dc1"}},
+				{Name: "router-z1-0", Addr: "10.0.0.3", Status: 1, Tags: map[string]string{"role": "node", "dc": "dc2"}},
+			}, nil)
+		})
+
+		It("returns the alive servers", func() {
+			servers, err := client.AliveServers()
+			Expect(err).NotTo(HaveOccurred())
+			Expect(servers).To(Equal([]agent.Member{
+				{Name: "consul-z1-0", Addr: "10.0.0.1", Status: agent.MemberStatusAlive, Role: "consul", Datacenter: "dc1"},
+			}))
+			Expect(logger.Messages).To(ContainElement(fakes.LoggerMessage{
+				Action: "agent-client.alive-servers.members.request",
+				Data: []lager.Data{{
+					"wan": false,
+				}},
+			}))
+		})
+
+		It("returns the failed members", func() {
+			failed, err := client.FailedMembers()
+			Expect(err).NotTo(HaveOccurred())
+			Expect(failed).To(Equal([]agent.Member{
+				{Name: "consul-z1-1", Addr: "10.0.0.2", Status: agent.MemberStatusFailed, Role: "consul", Datacenter: "dc1"},
+			}))
+		})
+
+		It("returns the members by datacenter", func() {
+			datacenters, err := client.MembersByDatacenter()
+			Expect(err).NotTo(HaveOccurred())
+			Expect(datacenters).To(HaveKey("dc1"))
+			Expect(datacenters["dc1"]).To(HaveLen(2))
+			Expect(datacenters["dc2"]).To(HaveLen(1))
+		})
+
+		It("returns an error when the members call fails", func() {
+			consulAPIAgent.MembersReturns(nil, errors.New("members error"))
+
+			_, err := client.Members()
+			Expect(err).To(MatchError("members error"))
+			Expect(logger.Messages).To(ContainElement(fakes.LoggerMessage{
+				Action: "agent-client.members.members.request.failed",
+				Error:  errors.New("members error"),
+				Data: []lager.Data{{
+					"wan": false,
+				}},
+			}))
+		})
+	})
+
+	Describe("RaftPeers", func() {
+		It("returns the raft peers", func() {
+			consulAPIStatus.PeersReturns([]string{"10.0.0.1:8300", "10.0.0.2:8300"}, nil)
+
+			Expect(client.RaftPeers()).To(Equal([]string{"10.0.0.1:8300", "10.0.0.2:8300"}))
+			Expect(logger.Messages).To(ContainSequence([]fakes.LoggerMessage{
+				{
+					Action: "agent-client.raft-peers.request",
+				},
+				{
+					Action: "agent-client.raft-peers.response",
+					Data: []lager.Data{{
+						"peers": []string{"10.0.0.1:8300", "10.0.0.2:8300"},
+					}},
+				},
+			}))
+		})
+
+		Context("when the peers call fails", func() {
+			It("returns an error", func() {
+				consulAPIStatus.PeersReturns(nil, errors.New("peers error"))
+
+				_, err := client.RaftPeers()
+				Expect(err).To(MatchError("peers error"))
+				Expect(logger.Messages).To(ContainElement(fakes.LoggerMessage{
+					Action: "agent-client.raft-peers.request.failed",
+					Error:  errors.New("peers error"),
+				}))
+			})
+		})
+	})
+
+	Describe("RemovePeer", func() {
+		BeforeEach(func() {
+			consulAPIAgent.MembersReturns([]*api.AgentMember{
+				{Name: "consul-z1-0", Addr: "10.0.0.1", Tags: map[string]string{"role": "consul"}, Status: 1},
+				{Name: "router-z1-0", Addr: "10.0.0.4", Tags: map[string]string{"role": "node"}, Status: 4},
+				{Name: "consul-z1-1", Addr: "10.0.0.4", Tags: map[string]string{"role": "consul"}, Status: 4},
+			}, nil)
+		})
+
+		It("force-leaves the failed server behind the peer", func() {
+			Expect(client.RemovePeer("10.0.0.4:8300")).To(Succeed())
+			Expect(consulAPIAgent.ForceLeaveCallCount()).To(Equal(1))
+			Expect(consulAPIAgent.ForceLeaveArgsForCall(0)).To(Equal("consul-z1-1"))
+			Expect(logger.Messages).To(ContainSequence([]fakes.LoggerMessage{
+				{
+					Action: "agent-client.remove-peer.force-leave.request",
+					Data: []lager.Data{{
+						"peer": "10.0.0.4:8300",
+						"node": "consul-z1-1",
+					}},
+				},
+				{
+					Action: "agent-client.remove-peer.success",
+					Data: []lager.Data{{
+						"peer": "10.0.0.4:8300",
+						"node": "consul-z1-1",
+					}},
+				},
+			}))
+		})
+
+		It("skips a server that is alive", func() {
+			Expect(client.RemovePeer("10.0.0.1:8300")).To(Succeed())
+			Expect(consulAPIAgent.ForceLeaveCallCount()).To(Equal(0))
+			Expect(logger.Messages).To(ContainElement(fakes.LoggerMessage{
+				Action: "agent-client.remove-peer.alive",
+				Data: []lager.Data{{
+					"peer": "10.0.0.1:8300",
+					"node": "consul-z1-0",
+				}},
+			}))
+		})
+
+		It("does nothing when no server matches the peer", func() {
+			Expect(client.RemovePeer("10.0.0.9:8300")).To(Succeed())
+			Expect(consulAPIAgent.ForceLeaveCallCount()).To(Equal(0))
+			Expect(logger.Messages).To(ContainElement(fakes.LoggerMessage{
+				Action: "agent-client.remove-peer.not-found",
+				Data: []lager.Data{{
+					"peer": "10.0.0.9:8300",
+				}},
+			}))
+		})
+
+		Context("failure cases", func() {
+			It("returns an error when the members call fails", func() {
+				consulAPIAgent.MembersReturns(nil, errors.New("members error"))
+				Expect(client.RemovePeer("10.0.0.4:8300")).To(MatchError("members error"))
+			})
+
+			It("returns an error when the force-leave fails", func() {
+				consulAPIAgent.ForceLeaveReturns(errors.New("force-leave error"))
+				Expect(client.RemovePeer("10.0.0.4:8300")).To(MatchError("force-leave error"))
+			})
+		})
+	})
+
+	Describe("VerifyServices", func() {
+		BeforeEach(func() {
+			consulAPIAgent.ServicesReturns(map[string]*api.AgentService{
+				"router": {ID: "router", Service: "router"},
+				"uaa":    {ID: "uaa", Service: "uaa"},
+			}, nil)
+			consulAPIAgent.ChecksReturns(map[string]*api.AgentCheck{
+				"service:router":    {CheckID: "service:router", ServiceID: "router", Status: "passing"},
+				"service:uaa":       {CheckID: "service:uaa", ServiceID: "uaa", Status: "passing"},
+				"service:unrelated": {CheckID: "service:unrelated", ServiceID: "unrelated", Status: "critical"},
+			}, nil)
+		})
+
+		It("succeeds when every service is registered and passing", func() {
+			Expect(client.VerifyServices([]string{"router", "uaa"})).To(Succeed())
+			Expect(consulAPIAgent.ServicesCallCount()).To(Equal(1))
+			Expect(consulAPIAgent.ChecksCallCount()).To(Equal(1))
+			Expect(logger.Messages).To(ContainSequence([]fakes.LoggerMessage{
+				{
+					Action: "agent-client.verify-services.services.request",
+				},
+				{
+					Action: "agent-client.verify-services.checks.request",
+				},
+				{
+					Action: "agent-client.verify-services.success",
+					Data: []lager.Data{{
+						"services": []string{"router", "uaa"},
+					}},
+				},
+			}))
+		})
+
+		Context("when a service is not registered", func() {
+			It("returns an error", func() {
+				Expect(client.VerifyServices([]string{"router", "doppler"})).To(MatchError(`service "doppler" is not registered`))
+				Expect(consulAPIAgent.ChecksCallCount()).To(Equal(0))
+			})
+		})
+
+		Context("when a service check is not passing", func() {
+			It("returns an error", func() {
+				consulAPIAgent.ChecksReturns(map[string]*api.AgentCheck{
+					"service:router": {CheckID: "service:router", ServiceID: "router", Status: "critical", Output: "connection refused"},
+				}, nil)
+
+				Expect(client.VerifyServices([]string{"router"})).To(MatchError(`check "service:router" for service "router" is critical`))
+				Expect(logger.Messages).To(ContainSequence([]fakes.LoggerMessage{
+					{
+						Action: "agent-client.verify-services.not-passing",
+						Error:  errors.New(`check "service:router" for service "router" is critical`),
+						Data: []lager.Data{{
+							"service": "router",
+							"check":   "service:router",
+							"status":  "critical",
+							"output":  "connection refused",
+						}},
+					},
+				}))
+			})
+		})
+
+		Context("when the services call fails", func() {
+			It("returns an error", func() {
+				consulAPIAgent.ServicesReturns(nil, errors.New("services error"))
+
+				Expect(client.VerifyServices([]string{"router"})).To(MatchError("services error"))
+			})
+		})
+
+		Context("when the checks call fails", func() {
+			It("returns an error", func() {
+				consulAPIAgent.ChecksReturns(nil, errors.New("checks error"))
+
+				Expect(client.VerifyServices([]string{"router"})).To(MatchError("checks error"))
+			})
+		})
+	})
+
+	Describe("VerifyDNS", func() {
+		It("queries the agent's dns server for the given name", func() {
+			response := new(dns.Msg)
+			response.Answer = []dns.RR{&dns.A{}}
+			dnsClient.ExchangeReturns(response, 0, nil)
+
+			Expect(client.VerifyDNS("consul.service.cf.internal")).To(Succeed())
+			Expect(dnsClient.ExchangeCallCount()).To(Equal(1))
+
+			query, address := dnsClient.ExchangeArgsForCall(0)
+			Expect(address).To(Equal("127.0.0.1:53"))
+			Expect(query.Question[0].Name).To(Equal("consul.service.cf.internal."))
+			Expect(query.Question[0].Qtype).To(Equal(dns.TypeA))
+		})
+
+		Context("when the query returns a non-success rcode", func() {
+			It("returns an error", func() {
+				response := new(dns.Msg)
+				response.Rcode = dns.RcodeNameError
+				dnsClient.ExchangeReturns(response, 0, nil)
+
+				Expect(client.VerifyDNS("consul.service.cf.internal")).To(MatchError(`dns query for "consul.service.cf.internal" failed: NXDOMAIN`))
+			})
+		})
+
+		Context("when the query returns no answers", func() {
+			It("returns an error", func() {
+				dnsClient.ExchangeReturns(new(dns.Msg), 0, nil)
+
+				Expect(client.VerifyDNS("consul.service.cf.internal")).To(MatchError(`dns query for "consul.service.cf.internal" returned no answers`))
+			})
+		})
+
+		Context("when the query fails", func() {
+			It("returns an error", func() {
+				dnsClient.ExchangeReturns(nil, 0, errors.New("connection refused"))
+
+				Expect(client.VerifyDNS("consul.service.cf.internal")).To(MatchError("connection refused"))
+			})
+		})
+	})
+
 	Describe("IsLastNode", func() {
 		BeforeEach(func() {
 			consulAPIAgent.MembersReturns([]*api.AgentMember{
-				&api.AgentMember{Addr: "member1", Tags: map[string]string{"role": "consul"}},
-				&api.AgentMember{Addr: "member2", Tags: map[string]string{"role": "consul"}},
-				&api.AgentMember{Addr: "member3", Tags: map[string]string{"role": "consul"}},
+				&api.AgentMember{Addr: "member1", Status: 1, Tags: map[string]string{"role": "consul"}},
+				&api.AgentMember{Addr: "member2", Status: 1, Tags: map[string]string{"role": "consul"}},
+				&api.AgentMember{Addr: "member3", Status: 1, Tags: map[string]string{"role": "consul"}},
 			}, nil)
 
 			client.ExpectedMembers = []string{"member1", "member2", "member3"}
 		})
 
 		It("returns true", func() {
-			Expect(client.IsLastNode()).To(BeTrue())
+			Expect(client.IsLastNode()).To(Equal(agent.LastNodeResult{
+				IsLastNode: true,
+				Missing:    []string{},
+				Unexpected: []string{},
+			}))
 			Expect(consulAPIAgent.MembersCallCount()).To(Equal(1))
 			Expect(logger.Messages).To(ContainSequence([]fakes.LoggerMessage{
 				{
@@ -285,8 +657,12 @@ var _ = Describe("Client", func() {
 				{
 					Action: "agent-client.is-last-node.members.response",
 					Data: []lager.Data{{
-						"wan":     false,
-						"members": []string{"member1", "member2", "member3"},
+						"wan": false,
+						"members": []agent.Member{
+							{Addr: "member1", Status: agent.MemberStatusAlive, Role: "consul"},
+							{Addr: "member2", Status: agent.MemberStatusAlive, Role: "consul"},
+							{Addr: "member3", Status: agent.MemberStatusAlive, Role: "consul"},
+						},
 					}},
 				},
 				{
@@ -294,22 +670,45 @@ var _ = Describe("Client", func() {
 					Data: []lager.Data{{
 						"actual_members_count":   3,
 						"expected_members_count": 3,
+						"missing":                []string{},
+						"unexpected":             []string{},
 						"is_last_node":           true,
 					}},
 				},
 			}))
 		})
 
+		Context("when the expected members are node names", func() {
+			BeforeEach(func() {
+				consulAPIAgent.MembersReturns([]*api.AgentMember{
+					&api.AgentMember{Name: "consul-0", Addr: "10.0.0.1", Status: 1, Tags: map[string]string{"role": "consul"}},
+					&api.AgentMember{Name: "consul-1", Addr: "10.0.0.2", Status: 1, Tags: map[string]string{"role": "consul"}},
+				}, nil)
+
+				client.ExpectedMembers = []string{"consul-0", "consul-1"}
+			})
+
+			It("matches members by name", func() {
+				result, err := client.IsLastNode()
+				Expect(err).NotTo(HaveOccurred())
+				Expect(result.IsLastNode).To(BeTrue())
+			})
+		})
+
 		Context("When you are not the last node", func() {
 			BeforeEach(func() {
 				consulAPIAgent.MembersReturns([]*api.AgentMember{
-					&api.AgentMember{Addr: "member1", Tags: map[string]string{"role": "consul"}},
-					&api.AgentMember{Addr: "member2", Tags: map[string]string{"role": "consul"}},
+					&api.AgentMember{Addr: "member1", Status: 1, Tags: map[string]string{"role": "consul"}},
+					&api.AgentMember{Addr: "member2", Status: 1, Tags: map[string]string{"role": "consul"}},
 				}, nil)
 			})
 
 			It("returns false", func() {
-				Expect(client.IsLastNode()).To(BeFalse())
+				Expect(client.IsLastNode()).To(Equal(agent.LastNodeResult{
+					IsLastNode: false,
+					Missing:    []string{"member3"},
+					Unexpected: []string{},
+				}))
 				Expect(consulAPIAgent.MembersCallCount()).To(Equal(1))
 				Expect(logger.Messages).To(ContainSequence([]fakes.LoggerMessage{
 					{
@@ -321,8 +720,11 @@ var _ = Describe("Client", func() {
 					{
 						Action: "agent-client.is-last-node.members.response",
 						Data: []lager.Data{{
-							"wan":     false,
-							"members": []string{"member1", "member2"},
+							"wan": false,
+							"members": []agent.Member{
+								{Addr: "member1", Status: agent.MemberStatusAlive, Role: "consul"},
+								{Addr: "member2", Status: agent.MemberStatusAlive, Role: "consul"},
+							},
 						}},
 					},
 					{
@@ -330,6 +732,8 @@ var _ = Describe("Client", func() {
 						Data: []lager.Data{{
 							"actual_members_count":   2,
 							"expected_members_count": 3,
+							"missing":                []string{"member3"},
+							"unexpected":             []string{},
 							"is_last_node":           false,
 						}},
 					},
@@ -340,14 +744,18 @@ var _ = Describe("Client", func() {
 			Context("when there are non-server members", func() {
 				BeforeEach(func() {
 					consulAPIAgent.MembersReturns([]*api.AgentMember{
-						&api.AgentMember{Addr: "member1", Tags: map[string]string{"role": "consul"}},
-						&api.AgentMember{Addr: "member2", Tags: map[string]string{"role": "node"}},
-						&api.AgentMember{Addr: "member3", Tags: map[string]string{"role": "consul"}},
+						&api.AgentMember{Addr: "member1", Status: 1, Tags: map[string]string{"role": "consul"}},
+						&api.AgentMember{Addr: "member2", Status: 1, Tags: map[string]string{"role": "node"}},
+						&api.AgentMember{Addr: "member3", Status: 1, Tags: map[string]string{"role": "consul"}},
 					}, nil)
 				})
 
 				It("returns false", func() {
-					Expect(client.IsLastNode()).To(BeFalse())
+					Expect(client.IsLastNode()).To(Equal(agent.LastNodeResult{
+						IsLastNode: false,
+						Missing:    []string{"member2"},
+						Unexpected: []string{},
+					}))
 					Expect(consulAPIAgent.MembersCallCount()).To(Equal(1))
 					Expect(logger.Messages).To(ContainSequence([]fakes.LoggerMessage{
 						{
@@ -359,8 +767,12 @@ var _ = Describe("Client", func() {
 						{
 							Action: "agent-client.is-last-node.members.response",
 							Data: []lager.Data{{
-								"wan":     false,
-								"members": []string{"member1", "member2", "member3"},
+								"wan": false,
+								"members": []agent.Member{
+									{Addr: "member1", Status: agent.MemberStatusAlive, Role: "consul"},
+									{Addr: "member2", Status: agent.MemberStatusAlive, Role: "node"},
+									{Addr: "member3", Status: agent.MemberStatusAlive, Role: "consul"},
+								},
 							}},
 						},
 						{
@@ -368,12 +780,50 @@ var _ = Describe("Client", func() {
 							Data: []lager.Data{{
 								"actual_members_count":   2,
 								"expected_members_count": 3,
+								"missing":                []string{"member2"},
+								"unexpected":             []string{},
 								"is_last_node":           false,
 							}},
 						},
 					}))
 				})
 			})
+
+			Context("when a server that is not expected has replaced an expected one", func() {
+				BeforeEach(func() {
+					consulAPIAgent.MembersReturns([]*api.AgentMember{
+						&api.AgentMember{Addr: "member1", Status: 1, Tags: map[string]string{"role": "consul"}},
+						&api.AgentMember{Addr: "member2", Status: 1, Tags: map[string]string{"role": "consul"}},
+						&api.AgentMember{Addr: "member4", Status: 1, Tags: map[string]string{"role": "consul"}},
+					}, nil)
+				})
+
+				It("reports the missing and unexpected members", func() {
+					Expect(client.IsLastNode()).To(Equal(agent.LastNodeResult{
+						IsLastNode: false,
+						Missing:    []string{"member3"},
+						Unexpected: []string{"member4"},
+					}))
+				})
+			})
+
+			Context("when an expected server is not alive", func() {
+				BeforeEach(func() {
+					consulAPIAgent.MembersReturns([]*api.AgentMember{
+						&api.AgentMember{Addr: "member1", Status: 1, Tags: map[string]string{"role": "consul"}},
+						&api.AgentMember{Addr: "member2", Status: 1, Tags: map[string]string{"role": "consul"}},
+						&api.AgentMember{Addr: "member3", Status: 4, Tags: map[string]string{"role": "consul"}},
+					}, nil)
+				})
+
+				It("reports the server as missing", func() {
+					Expect(client.IsLastNode()).To(Equal(agent.LastNodeResult{
+						IsLastNode: false,
+						Missing:    []string{"member3"},
+						Unexpected: []string{},
+					}))
+				})
+			})
 		})
 
 		Context("When members returns an error", func() {
@@ -791,4 +1241,412 @@ var _ = Describe("Client", func() {
 			})
 		})
 	})
+
+	Describe("EnableMaintenance", func() {
+		It("puts the node into maintenance mode", func() {
+			Expect(client.EnableMaintenance("", "draining")).To(Succeed())
+			Expect(consulAPIAgent.EnableNodeMaintenanceCallCount()).To(Equal(1))
+			Expect(consulAPIAgent.EnableNodeMaintenanceArgsForCall(0)).To(Equal("draining"))
+			Expect(consulAPIAgent.EnableServiceMaintenanceCallCount()).To(Equal(0))
+			Expect(logger.Messages).To(ContainSequence([]fakes.LoggerMessage{
+				{
+					Action: "agent-client.enable-maintenance.request",
+					Data: []lager.Data{{
+						"service": "",
+						"reason":  "draining",
+					}},
+				},
+				{
+					Action: "agent-client.enable-maintenance.response",
+					Data: []lager.Data{{
+						"service": "",
+					}},
+				},
+			}))
+		})
+
+		Context("when a service is given", func() {
+			It("puts only that service into maintenance mode", func() {
+				Expect(client.EnableMaintenance("router", "upgrade")).To(Succeed())
+				Expect(consulAPIAgent.EnableNodeMaintenanceCallCount()).To(Equal(0))
+				Expect(consulAPIAgent.EnableServiceMaintenanceCallCount()).To(Equal(1))
+
+				serviceID, reason := consulAPIAgent.EnableServiceMaintenanceArgsForCall(0)
+				Expect(serviceID).To(Equal("router"))
+				Expect(reason).To(Equal("upgrade"))
+			})
+		})
+
+		Context("when the maintenance request fails", func() {
+			It("returns an error", func() {
+				consulAPIAgent.EnableNodeMaintenanceReturns(errors.New("maintenance error"))
+
+				Expect(client.EnableMaintenance("", "")).To(MatchError("maintenance error"))
+				Expect(logger.Messages).To(ContainSequence([]fakes.LoggerMessage{
+					{
+						Action: "agent-client.enable-maintenance.request.failed",
+						Error:  errors.New("maintenance error"),
+						Data: []lager.Data{{
+							"service": "",
+						}},
+					},
+				}))
+			})
+		})
+	})
+
+	Describe("DisableMaintenance", func() {
+		It("takes the node out of maintenance mode", func() {
+			Expect(client.DisableMaintenance("")).To(Succeed())
+			Expect(consulAPIAgent.DisableNodeMaintenanceCallCount()).To(Equal(1))
+			Expect(consulAPIAgent.DisableServiceMaintenanceCallCount()).To(Equal(0))
+			Expect(logger.Messages).To(ContainSequence([]fakes.LoggerMessage{
+				{
+					Action: "agent-client.disable-maintenance.request",
+					Data: []lager.Data{{
+						"service": "",
+					}},
+				},
+				{
+					Action: "agent-client.disable-maintenance.response",
+					Data: []lager.Data{{
+						"service": "",
+					}},
+				},
+			}))
+		})
+
+		Context("when a service is given", func() {
+			It("takes only that service out of maintenance mode", func() {
+				Expect(client.DisableMaintenance("router")).To(Succeed())
+				Expect(consulAPIAgent.DisableNodeMaintenanceCallCount()).To(Equal(0))
+				Expect(consulAPIAgent.DisableServiceMaintenanceCallCount()).To(Equal(1))
+				Expect(consulAPIAgent.DisableServiceMaintenanceArgsForCall(0)).To(Equal("router"))
+			})
+		})
+
+		Context("when the maintenance request fails", func() {
+			It("returns an error", func() {
+				consulAPIAgent.DisableServiceMaintenanceReturns(errors.New("maintenance error"))
+
+				Expect(client.DisableMaintenance("router")).To(MatchError("maintenance error"))
+				Expect(logger.Messages).To(ContainSequence([]fakes.LoggerMessage{
+					{
+						Action: "agent-client.disable-maintenance.request.failed",
+						Error:  errors.New("maintenance error"),
+						Data: []lager.Data{{
+							"service": "router",
+						}},
+					},
+				}))
+			})
+		})
+	})
+
+	Describe("NodeMaintenanceReason", func() {
+		It("returns the reason of the node maintenance check", func() {
+			consulAPIAgent.ChecksReturns(map[string]*api.AgentCheck{
+				"_node_maintenance": {
+					CheckID: "_node_maintenance",
+					Status:  "critical",
+					Notes:   "draining",
+				},
+				"service:router": {
+					CheckID: "service:router",
+					Status:  "passing",
+				},
+			}, nil)
+
+			Expect(client.NodeMaintenanceReason()).To(Equal("draining"))
+			Expect(logger.Messages).To(ContainSequence([]fakes.LoggerMessage{
+				{
+					Action: "agent-client.node-maintenance-reason.checks.request",
+				},
+				{
+					Action: "agent-client.node-maintenance-reason.checks.response",
+					Data: []lager.Data{{
+						"reason": "draining",
+					}},
+				},
+			}))
+		})
+
+		It("returns an empty reason when the node is not in maintenance", func() {
+			consulAPIAgent.ChecksReturns(map[string]*api.AgentCheck{}, nil)
+
+			Expect(client.NodeMaintenanceReason()).To(Equal(""))
+		})
+
+		Context("when the checks request fails", func() {
+			It("returns an error", func() {
+				consulAPIAgent.ChecksReturns(nil, errors.New("checks error"))
+
+				_, err := client.NodeMaintenanceReason()
+				Expect(err).To(MatchError("checks error"))
+				Expect(logger.Messages).To(ContainElement(fakes.LoggerMessage{
+					Action: "agent-client.node-maintenance-reason.checks.request.failed",
+					Error:  errors.New("checks error"),
+				}))
+			})
+		})
+	})
+
+	Describe("ReconcilePreparedQueries", func() {
+		var routerQuery api.PreparedQueryDefinition
+
+		BeforeEach(func() {
+			routerQuery = api.PreparedQueryDefinition{
+				Name: "router",
+				Service: api.ServiceQuery{
+					Service:     "gorouter",
+					OnlyPassing: true,
+					Failover: api.QueryDatacenterOptions{
+						NearestN: 2,
+					},
+				},
+			}
+		})
+
+		It("creates queries that do not exist yet", func() {
+			Expect(client.ReconcilePreparedQueries([]api.PreparedQueryDefinition{routerQuery})).To(Succeed())
+			Expect(preparedQuery.CreateCallCount()).To(Equal(1))
+
+			query, _ := preparedQuery.CreateArgsForCall(0)
+			Expect(*query).To(Equal(routerQuery))
+			Expect(preparedQuery.UpdateCallCount()).To(Equal(0))
+			Expect(preparedQuery.DeleteCallCount()).To(Equal(0))
+			Expect(logger.Messages).To(ContainSequence([]fakes.LoggerMessage{
+				{
+					Action: "agent-client.reconcile-prepared-queries.list.request",
+				},
+				{
+					Action: "agent-client.reconcile-prepared-queries.list.response",
+				},
+				{
+					Action: "agent-client.reconcile-prepared-queries.create.request",
+					Data: []lager.Data{{
+						"name": "router",
+					}},
+				},
+				{
+					Action: "agent-client.reconcile-prepared-queries.success",
+				},
+			}))
+		})
+
+		It("updates queries whose definition has changed, keeping their id", func() {
+			existing := routerQuery
+			existing.ID = "some-query-id"
+			existing.Service.OnlyPassing = false
+			preparedQuery.ListReturns([]*api.PreparedQueryDefinition{&existing}, nil, nil)
+
+			Expect(client.ReconcilePreparedQueries([]api.PreparedQueryDefinition{routerQuery})).To(Succeed())
+			Expect(preparedQuery.CreateCallCount()).To(Equal(0))
+			Expect(preparedQuery.UpdateCallCount()).To(Equal(1))
+
+			query, _ := preparedQuery.UpdateArgsForCall(0)
+			Expect(query.ID).To(Equal("some-query-id"))
+			Expect(query.Service.OnlyPassing).To(BeTrue())
+		})
+
+		It("leaves queries that are up to date alone", func() {
+			existing := routerQuery
+			existing.ID = "some-query-id"
+			preparedQuery.ListReturns([]*api.PreparedQueryDefinition{&existing}, nil, nil)
+
+			Expect(client.ReconcilePreparedQueries([]api.PreparedQueryDefinition{routerQuery})).To(Succeed())
+			Expect(preparedQuery.CreateCallCount()).To(Equal(0))
+			Expect(preparedQuery.UpdateCallCount()).To(Equal(0))
+			Expect(preparedQuery.DeleteCallCount()).To(Equal(0))
+		})
+
+		It("treats missing and empty lists as the same definition", func() {
+			existing := routerQuery
+			existing.ID = "some-query-id"
+			existing.Service.Tags = []string{}
+			existing.Service.Failover.Datacenters = []string{}
+			preparedQuery.ListReturns([]*api.PreparedQueryDefinition{&existing}, nil, nil)
+
+			Expect(routerQuery.Service.Tags).To(BeNil())
+			Expect(client.ReconcilePreparedQueries([]api.PreparedQueryDefinition{routerQuery})).To(Succeed())
+			Expect(preparedQuery.UpdateCallCount()).To(Equal(0))
+		})
+
+		It("deletes named queries that are no longer declared, leaving unnamed ones", func() {
+			preparedQuery.ListReturns([]*api.PreparedQueryDefinition{
+				{ID: "stale-id", Name: "stale"},
+				{ID: "unnamed-id"},
+			}, nil, nil)
+
+			Expect(client.ReconcilePreparedQueries([]api.PreparedQueryDefinition{routerQuery})).To(Succeed())
+			Expect(preparedQuery.DeleteCallCount()).To(Equal(1))
+
+			id, _ := preparedQuery.DeleteArgsForCall(0)
+			Expect(id).To(Equal("stale-id"))
+			Expect(logger.Messages).To(ContainSequence([]fakes.LoggerMessage{
+				{
+					Action: "agent-client.reconcile-prepared-queries.delete.request",
+					Data: []lager.Data{{
+						"name": "stale",
+						"id":   "stale-id",
+					}},
+				},
+			}))
+		})
+
+		Context("failure cases", func() {
+			It("returns an error when the queries cannot be listed", func() {
+				preparedQuery.ListReturns(nil, nil, errors.New("list error"))
+
+				Expect(client.ReconcilePreparedQueries([]api.PreparedQueryDefinition{routerQuery})).To(MatchError("list error"))
+				Expect(logger.Messages).To(ContainSequence([]fakes.LoggerMessage{
+					{
+						Action: "agent-client.reconcile-prepared-queries.list.request.failed",
+						Error:  errors.New("list error"),
+					},
+				}))
+			})
+
+			It("returns an error when a query cannot be created", func() {
+				preparedQuery.CreateReturns("", nil, errors.New("create error"))
+
+				Expect(client.ReconcilePreparedQueries([]api.PreparedQueryDefinition{routerQuery})).To(MatchError("create error"))
+				Expect(logger.Messages).To(ContainSequence([]fakes.LoggerMessage{
+					{
+						Action: "agent-client.reconcile-prepared-queries.create.request.failed",
+						Error:  errors.New("create error"),
+						Data: []lager.Data{{
+							"name": "router",
+						}},
+					},
+				}))
+			})
+
+			It("returns an error when a query cannot be deleted", func() {
+				preparedQuery.ListReturns([]*api.PreparedQueryDefinition{{ID: "stale-id", Name: "stale"}}, nil, nil)
+				preparedQuery.DeleteReturns(nil, errors.New("delete error"))
+
+				Expect(client.ReconcilePreparedQueries(nil)).To(MatchError("delete error"))
+			})
+		})
+	})
+
+	Describe("SeedKV", func() {
+		var pairs []api.KVPair
+
+		BeforeEach(func() {
+			pairs = []api.KVPair{
+				{Key: "features/new-ui", Value: []byte("true"), Flags: 1},
+			}
+			consulAPIKV.CASReturns(true, nil, nil)
+		})
+
+		It("creates keys that do not exist", func() {
+			Expect(client.SeedKV(pairs, false)).To(Succeed())
+			Expect(consulAPIKV.CASCallCount()).To(Equal(1))
+
+			pair, _ := consulAPIKV.CASArgsForCall(0)
+			Expect(*pair).To(Equal(api.KVPair{Key: "features/new-ui", Value: []byte("true"), Flags: 1}))
+			Expect(logger.Messages).To(ContainSequence([]fakes.LoggerMessage{
+				{
+					Action: "agent-client.seed-kv.get.request",
+					Data: []lager.Data{{
+						"key": "features/new-ui",
+					}},
+				},
+				{
+					Action: "agent-client.seed-kv.cas.request",
+					Data: []lager.Data{{
+						"key":          "features/new-ui",
+						"modify_index": uint64(0),
+					}},
+				},
+				{
+					Action: "agent-client.seed-kv.success",
+					Data: []lager.Data{{
+						"created":   []string{"features/new-ui"},
+						"updated":   []string(nil),
+						"unchanged": []string(nil),
+						"skipped":   []string(nil),
+					}},
+				},
+			}))
+		})
+
+		It("does not write keys that already have the declared value", func() {
+			consulAPIKV.GetReturns(&api.KVPair{Key: "features/new-ui", Value: []byte("true"), Flags: 1, ModifyIndex: 7}, nil, nil)
+
+			Expect(client.SeedKV(pairs, true)).To(Succeed())
+			Expect(consulAPIKV.CASCallCount()).To(Equal(0))
+		})
+
+		Context("when only creating missing keys", func() {
+			It("skips keys that exist with a different value", func() {
+				consulAPIKV.GetReturns(&api.KVPair{Key: "features/new-ui", Value: []byte("false"), ModifyIndex: 7}, nil, nil)
+
+				Expect(client.SeedKV(pairs, false)).To(Succeed())
+				Expect(consulAPIKV.CASCallCount()).To(Equal(0))
+				Expect(logger.Messages).To(ContainSequence([]fakes.LoggerMessage{
+					{
+						Action: "agent-client.seed-kv.success",
+						Data: []lager.Data{{
+							"created":   []string(nil),
+							"updated":   []string(nil),
+							"unchanged": []string(nil),
+							"skipped":   []string{"features/new-ui"},
+						}},
+					},
+				}))
+			})
+
+			It("skips keys that were created concurrently", func() {
+				consulAPIKV.CASReturns(false, nil, nil)
+
+				Expect(client.SeedKV(pairs, false)).To(Succeed())
+			})
+		})
+
+		Context("when enforcing the declared values", func() {
+			It("overwrites keys that differ using the current modify index", func() {
+				consulAPIKV.GetReturns(&api.KVPair{Key: "features/new-ui", Value: []byte("false"), ModifyIndex: 7}, nil, nil)
+
+				Expect(client.SeedKV(pairs, true)).To(Succeed())
+				Expect(consulAPIKV.CASCallCount()).To(Equal(1))
+
+				pair, _ := consulAPIKV.CASArgsForCall(0)
+				Expect(pair.ModifyIndex).To(Equal(uint64(7)))
+				Expect(pair.Value).To(Equal([]byte("true")))
+			})
+
+			It("returns an error when the key was modified concurrently", func() {
+				consulAPIKV.GetReturns(&api.KVPair{Key: "features/new-ui", Value: []byte("false"), ModifyIndex: 7}, nil, nil)
+				consulAPIKV.CASReturns(false, nil, nil)
+
+				Expect(client.SeedKV(pairs, true)).To(MatchError(`key "features/new-ui" was modified concurrently`))
+			})
+		})
+
+		Context("failure cases", func() {
+			It("returns an error when a key cannot be read", func() {
+				consulAPIKV.GetReturns(nil, nil, errors.New("get error"))
+
+				Expect(client.SeedKV(pairs, false)).To(MatchError("get error"))
+				Expect(logger.Messages).To(ContainSequence([]fakes.LoggerMessage{
+					{
+						Action: "agent-client.seed-kv.get.request.failed",
+						Error:  errors.New("get error"),
+						Data: []lager.Data{{
+							"key": "features/new-ui",
+						}},
+					},
+				}))
+			})
+
+			It("returns an error when a key cannot be written", func() {
+				consulAPIKV.CASReturns(false, nil, errors.New("cas error"))
+
+				Expect(client.SeedKV(pairs, false)).To(MatchError("cas error"))
+			})
+		})
+	})
 })
@@ -0,0 +1,155 @@
+package agent
+
+import (
+	"fmt"
+	"strconv"
+
+	"github.com/pivotal-golang/lager"
+)
+
+type ProtocolRange struct {
+	Min int `json:"min"`
+	Max int `json:"max"`
+}
+
+func (r ProtocolRange) Contains(version int) bool {
+	return version >= r.Min && version <= r.Max
+}
+
+// VersionSpread keys protocols by their decimal string, since encoding/json
+// only learned to marshal integer keyed maps in go 1.7.
+type VersionSpread struct {
+	Protocols     map[string][]string `json:"protocols"`
+	SerfProtocols map[string][]string `json:"serf_protocols"`
+	Builds        map[string][]string `json:"builds"`
+}
+
+type Compatibility struct {
+	Compatible        bool          `json:"compatible"`
+	Local             Member        `json:"local"`
+	ProtocolRange     ProtocolRange `json:"protocol_range"`
+	SerfProtocolRange ProtocolRange `json:"serf_protocol_range"`
+	Spread            VersionSpread `json:"spread"`
+}
+
+// Spread groups the names of the alive members by the protocols they speak
+// and the consul build they run.
+func Spread(members []Member) VersionSpread {
+	spread := VersionSpread{
+		Protocols:     map[string][]string{},
+		SerfProtocols: map[string][]string{},
+		Builds:        map[string][]string{},
+	}
+
+	for _, member := range members {
+		if !member.IsAlive() {
+			continue
+		}
+
+		protocol := strconv.Itoa(member.Protocol.Current)
+		serfProtocol := strconv.Itoa(member.SerfProtocol.Current)
+
+		spread.Protocols[protocol] = append(spread.Protocols[protocol], member.Name)
+		spread.SerfProtocols[serfProtocol] = append(spread.SerfProtocols[serfProtocol], member.Name)
+		spread.Builds[member.Build] = append(spread.Builds[member.Build], member.Name)
+	}
+
+	return spread
+}
+
+// CheckCompatibility works out the protocol range every other alive member
+// can speak and whether the local member's protocols fall inside it. Members
+// that do not advertise a protocol are left out of the range.
+func CheckCompatibility(local Member, members []Member) Compatibility {
+	compatibility := Compatibility{
+		Local:  local,
+		Spread: Spread(members),
+	}
+
+	var others []Member
+	for _, member := range members {
+		if member.IsAlive() && member.Name != local.Name {
+			others = append(others, member)
+		}
+	}
+
+	protocols, protocolsKnown := protocolRange(others, func(m Member) MemberProtocol { return m.Protocol })
+	serfProtocols, serfProtocolsKnown := protocolRange(others, func(m Member) MemberProtocol { return m.SerfProtocol })
+
+	compatibility.ProtocolRange = protocols
+	compatibility.SerfProtocolRange = serfProtocols
+	compatibility.Compatible = speaks(local.Protocol, protocols, protocolsKnown) &&
+		speaks(local.SerfProtocol, serfProtocols, serfProtocolsKnown)
+
+	return compatibility
+}
+
+func speaks(protocol MemberProtocol, r ProtocolRange, known bool) bool {
+	if !known || protocol.Max == 0 {
+		return true
+	}
+
+	return r.Contains(protocol.Current)
+}
+
+func protocolRange(members []Member, protocol func(Member) MemberProtocol) (ProtocolRange, bool) {
+	var (
+		r     ProtocolRange
+		found bool
+	)
+
+	for _, member := range members {
+		p := protocol(member)
+		if p.Max == 0 {
+			continue
+		}
+
+		if !found {
+			r = ProtocolRange{Min: p.Min, Max: p.Max}
+			found = true
+			continue
+		}
+
+		if p.Min > r.Min {
+			r.Min = p.Min
+		}
+
+		if p.Max < r.Max {
+			r.Max = p.Max
+		}
+	}
+
+	return r, found
+}
+
+func (c Client) ProtocolCompatibility(node string) (Compatibility, error) {
+	members, err := c.members("protocol-compatibility")
+	if err != nil {
+		return Compatibility{}, err
+	}
+
+	for _, member := range members {
+		if member.Name != node {
+			continue
+		}
+
+		compatibility := CheckCompatibility(member, members)
+		c.Logger.Info("agent-client.protocol-compatibility.result", lager.Data{
+			"node":                node,
+			"compatible":          compatibility.Compatible,
+			"protocol":            member.Protocol,
+			"protocol_range":      compatibility.ProtocolRange,
+			"serf_protocol":       member.SerfProtocol,
+			"serf_protocol_range": compatibility.SerfProtocolRange,
+			"spread":              compatibility.Spread,
+		})
+
+		return compatibility, nil
+	}
+
+	err = fmt.Errorf("node %q is not a member of the cluster", node)
+	c.Logger.Error("agent-client.protocol-compatibility.not-found", err, lager.Data{
+		"node": node,
+	})
+	return Compatibility{}, err
+}
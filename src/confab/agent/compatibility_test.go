@@ -0,0 +1,173 @@
+package agent_test
+
+import (
+	"confab/agent"
+	"confab/fakes"
+	"encoding/json"
+	"errors"
+
+	"github.com/hashicorp/consul/api"
+	"github.com/pivotal-golang/lager"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Compatibility", func() {
+	member := func(name string, protocol, serfProtocol agent.MemberProtocol, build string) agent.Member {
+		return agent.Member{
+			Name:         name,
+			Status:       agent.MemberStatusAlive,
+			Role:         "consul",
+			Protocol:     protocol,
+			SerfProtocol: serfProtocol,
+			Build:        build,
+		}
+	}
+
+	var (
+		v2   agent.MemberProtocol
+		v3   agent.MemberProtocol
+		serf agent.MemberProtocol
+	)
+
+	BeforeEach(func() {
+		v2 = agent.MemberProtocol{Min: 1, Max: 2, Current: 2}
+		v3 = agent.MemberProtocol{Min: 1, Max: 3, Current: 3}
+		serf = agent.MemberProtocol{Min: 1, Max: 4, Current: 4}
+	})
+
+	Describe("CheckCompatibility", func() {
+		It("compares the local protocol with the range the other members speak", func() {
+			local := member("consul-0", v3, serf, "0.6.4")
+			members := []agent.Member{
+				local,
+				member("consul-1", v2, serf, "0.6.3"),
+				member("consul-2", v3, serf, "0.6.4"),
+			}
+
+			compatibility := agent.CheckCompatibility(local, members)
+			Expect(compatibility.Compatible).To(BeFalse())
+			Expect(compatibility.ProtocolRange).To(Equal(agent.ProtocolRange{Min: 1, Max: 2}))
+
+			local = member("consul-0", agent.MemberProtocol{Min: 1, Max: 3, Current: 2}, serf, "0.6.4")
+			compatibility = agent.CheckCompatibility(local, members)
+			Expect(compatibility.Compatible).To(BeTrue())
+		})
+
+		It("is incompatible when the serf protocol is outside the cluster range", func() {
+			local := member("consul-0", v2, agent.MemberProtocol{Min: 5, Max: 5, Current: 5}, "0.7.0")
+			members := []agent.Member{local, member("consul-1", v2, serf, "0.6.4")}
+
+			compatibility := agent.CheckCompatibility(local, members)
+			Expect(compatibility.Compatible).To(BeFalse())
+			Expect(compatibility.SerfProtocolRange).To(Equal(agent.ProtocolRange{Min: 1, Max: 4}))
+		})
+
+		It("ignores members that are not alive or do not advertise a protocol", func() {
+			local := member("consul-0", v3, serf, "0.6.4")
+			failed := member("consul-1", v2, serf, "0.6.3")
+			failed.Status = agent.MemberStatusFailed
+
+			members := []agent.Member{
+				local,
+				failed,
+				member("consul-2", agent.MemberProtocol{}, agent.MemberProtocol{}, ""),
+			}
+
+			Expect(agent.CheckCompatibility(local, members).Compatible).To(BeTrue())
+		})
+
+		It("reports the version spread of the alive members", func() {
+			local := member("consul-0", v3, serf, "0.6.4")
+			members := []agent.Member{
+				local,
+				member("consul-1", v2, serf, "0.6.3"),
+				member("consul-2", v3, serf, "0.6.4"),
+			}
+
+			Expect(agent.CheckCompatibility(local, members).Spread).To(Equal(agent.VersionSpread{
+				Protocols: map[string][]string{
+					"2": {"consul-1"},
+					"3": {"consul-0", "consul-2"},
+				},
+				SerfProtocols: map[string][]string{
+					"4": {"consul-0", "consul-1", "consul-2"},
+				},
+				Builds: map[string][]string{
+					"0.6.3": {"consul-1"},
+					"0.6.4": {"consul-0", "consul-2"},
+				},
+			}))
+		})
+	})
+
+	It("marshals to json with the protocols as string keys", func() {
+		local := member("consul-0", v3, serf, "0.6.4")
+		compatibility := agent.CheckCompatibility(local, []agent.Member{
+			local,
+			member("consul-1", v2, serf, "0.6.3"),
+		})
+
+		contents, err := json.Marshal(compatibility)
+		Expect(err).NotTo(HaveOccurred())
+
+		var decoded struct {
+			Spread json.RawMessage `json:"spread"`
+		}
+		Expect(json.Unmarshal(contents, &decoded)).To(Succeed())
+		Expect(decoded.Spread).To(MatchJSON(`{
+			"protocols": {"2": ["consul-1"], "3": ["consul-0"]},
+			"serf_protocols": {"4": ["consul-0", "consul-1"]},
+			"builds": {"0.6.3": ["consul-1"], "0.6.4": ["consul-0"]}
+		}`))
+	})
+
+	Describe("Client.ProtocolCompatibility", func() {
+		var (
+			consulAPIAgent *fakes.FakeconsulAPIAgent
+			logger         *fakes.Logger
+			client         agent.Client
+		)
+
+		BeforeEach(func() {
+			consulAPIAgent = &fakes.FakeconsulAPIAgent{}
+			logger = &fakes.Logger{}
+			client = agent.Client{
+				ConsulAPIAgent: consulAPIAgent,
+				Logger:         logger,
+			}
+
+			consulAPIAgent.MembersReturns([]*api.AgentMember{
+				{Name: "consul-0", Status: 1, ProtocolMin: 1, ProtocolMax: 4, ProtocolCur: 4, Tags: map[string]string{"role": "consul", "vsn": "3", "vsn_min": "1", "vsn_max": "3"}},
+				{Name: "consul-1", Status: 1, ProtocolMin: 1, ProtocolMax: 4, ProtocolCur: 4, Tags: map[string]string{"role": "consul", "vsn": "2", "vsn_min": "1", "vsn_max": "2"}},
+			}, nil)
+		})
+
+		It("checks the local node against the rest of the cluster", func() {
+			compatibility, err := client.ProtocolCompatibility("consul-0")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(compatibility.Compatible).To(BeFalse())
+			Expect(compatibility.Local.Name).To(Equal("consul-0"))
+		})
+
+		It("returns an error when the node is not a member", func() {
+			_, err := client.ProtocolCompatibility("consul-9")
+			Expect(err).To(MatchError(`node "consul-9" is not a member of the cluster`))
+			Expect(logger.Messages).To(ContainElement(fakes.LoggerMessage{
+				Action: "agent-client.protocol-compatibility.not-found",
+				Error:  errors.New(`node "consul-9" is not a member of the cluster`),
+				Data: []lager.Data{{
+					"node": "consul-9",
+				}},
+			}))
+		})
+
+		It("returns an error when the members call fails", func() {
+			consulAPIAgent.MembersReturns(nil, errors.New("members error"))
+
+			_, err := client.ProtocolCompatibility("consul-0")
+			Expect(err).To(MatchError("members error"))
+		})
+	})
+})
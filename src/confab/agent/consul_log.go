@@ -0,0 +1,227 @@
+package agent
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+
+	"github.com/pivotal-golang/lager"
+)
+
+// consul logs lines like
+//
+//	2016/03/15 19:14:30 [INFO] serf: EventMemberJoin: consul-z1-0 10.0.0.1
+var consulLogLine = regexp.MustCompile(`^\s*(?:\d{4}/\d{2}/\d{2} \d{2}:\d{2}:\d{2} )?\[([A-Z]+)\] (?:([\w.\-]+): )?(.*)$`)
+
+// NotableEvents maps the name of an event worth counting to the start of the
+// consul log message that reports it.
+var NotableEvents = map[string]string{
+	"member-failed":    "serf: EventMemberFailed",
+	"election-timeout": "raft: Election timeout",
+	"join-failed":      "agent: Join failed",
+}
+
+type LogEvent struct {
+	Level   string `json:"level"`
+	Source  string `json:"source"`
+	Message string `json:"message"`
+	Stream  string `json:"stream"`
+}
+
+// ParseLogLine splits a consul log line into its level, the subsystem that
+// logged it and the message. Lines without a level, like the banner consul
+// prints on start, are reported at info from the "consul" source.
+func ParseLogLine(line, stream string) LogEvent {
+	matches := consulLogLine.FindStringSubmatch(line)
+	if matches == nil {
+		return LogEvent{
+			Level:   "info",
+			Source:  "consul",
+			Message: strings.TrimSpace(line),
+			Stream:  stream,
+		}
+	}
+
+	source := matches[2]
+	if source == "" {
+		source = "consul"
+	}
+
+	return LogEvent{
+		Level:   normalizeLevel(matches[1]),
+		Source:  source,
+		Message: matches[3],
+		Stream:  stream,
+	}
+}
+
+func normalizeLevel(level string) string {
+	switch level {
+	case "ERR", "ERROR":
+		return "error"
+	case "WARN":
+		return "warn"
+	default:
+		return strings.ToLower(level)
+	}
+}
+
+func (e LogEvent) notable() (string, bool) {
+	text := e.Source + ": " + e.Message
+	for name, prefix := range NotableEvents {
+		if strings.HasPrefix(text, prefix) {
+			return name, true
+		}
+	}
+
+	return "", false
+}
+
+// MaxLogLineLength is the longest line LogForwarder forwards in full.
+const MaxLogLineLength = 64 * 1024
+
+// LogForwarder reads consul's output line by line and logs each line as a
+// structured event. Notable events are counted and, when EventsFile is set,
+// the counts are written there so they outlive the forwarder.
+type LogForwarder struct {
+	Logger     logger
+	EventsFile string
+
+	mutex  sync.Mutex
+	counts map[string]int
+}
+
+// Copy forwards lines until the reader is exhausted. Lines longer than
+// MaxLogLineLength are truncated rather than ending the copy, since consul
+// blocks on its output once nothing reads it.
+func (f *LogForwarder) Copy(reader io.Reader, stream string) error {
+	buffered := bufio.NewReader(reader)
+
+	var line []byte
+	for {
+		fragment, isPrefix, err := buffered.ReadLine()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		if room := MaxLogLineLength - len(line); room > 0 {
+			if len(fragment) > room {
+				fragment = fragment[:room]
+			}
+			line = append(line, fragment...)
+		}
+
+		if isPrefix {
+			continue
+		}
+
+		if strings.TrimSpace(string(line)) != "" {
+			f.Forward(ParseLogLine(string(line), stream))
+		}
+		line = line[:0]
+	}
+}
+
+func (f *LogForwarder) Forward(event LogEvent) {
+	data := lager.Data{
+		"level":   event.Level,
+		"source":  event.Source,
+		"message": event.Message,
+		"stream":  event.Stream,
+	}
+
+	if event.Level == "error" {
+		f.Logger.Error("consul-log.forward", errors.New(event.Message), data)
+	} else {
+		f.Logger.Info("consul-log.forward", data)
+	}
+
+	name, ok := event.notable()
+	if !ok {
+		return
+	}
+
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	if f.counts == nil {
+		f.counts = map[string]int{}
+	}
+	f.counts[name]++
+
+	f.Logger.Info("consul-log.notable-event", lager.Data{
+		"event": name,
+		"count": f.counts[name],
+	})
+
+	if f.EventsFile == "" {
+		return
+	}
+
+	if err := writeEventCounts(f.EventsFile, f.counts); err != nil {
+		f.Logger.Error("consul-log.write-events.failed", err, lager.Data{
+			"path": f.EventsFile,
+		})
+	}
+}
+
+func (f *LogForwarder) Counts() map[string]int {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	counts := map[string]int{}
+	for name, count := range f.counts {
+		counts[name] = count
+	}
+
+	return counts
+}
+
+func writeEventCounts(path string, counts map[string]int) error {
+	contents, err := json.Marshal(counts)
+	if err != nil {
+		return err // not tested, a map of ints always marshals
+	}
+
+	tempFile, err := ioutil.TempFile(filepath.Dir(path), filepath.Base(path))
+	if err != nil {
+		return err
+	}
+
+	if _, err := tempFile.Write(contents); err != nil {
+		tempFile.Close()
+		os.Remove(tempFile.Name())
+		return err
+	}
+
+	if err := tempFile.Close(); err != nil {
+		os.Remove(tempFile.Name())
+		return err
+	}
+
+	return os.Rename(tempFile.Name(), path)
+}
+
+func ReadEventCounts(path string) (map[string]int, error) {
+	contents, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	counts := map[string]int{}
+	if err := json.Unmarshal(contents, &counts); err != nil {
+		return nil, err
+	}
+
+	return counts, nil
+}
@@ -0,0 +1,192 @@
+package agent_test
+
+import (
+	"confab/agent"
+	"confab/fakes"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/pivotal-golang/lager"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("ConsulLog", func() {
+	Describe("ParseLogLine", func() {
+		It("parses the level, source and message", func() {
+			event := agent.ParseLogLine("    2016/03/15 19:14:30 [WARN] raft: Heartbeat timeout reached, starting election", "stdout")
+			Expect(event).To(Equal(agent.LogEvent{
+				Level:   "warn",
+				Source:  "raft",
+				Message: "Heartbeat timeout reached, starting election",
+				Stream:  "stdout",
+			}))
+		})
+
+		It("reports ERR as error", func() {
+			event := agent.ParseLogLine("    2016/03/15 19:14:30 [ERR] agent: failed to sync remote state: No cluster leader", "stderr")
+			Expect(event.Level).To(Equal("error"))
+			Expect(event.Source).To(Equal("agent"))
+			Expect(event.Message).To(Equal("failed to sync remote state: No cluster leader"))
+		})
+
+		It("keeps dotted sources", func() {
+			event := agent.ParseLogLine("    2016/03/15 19:14:30 [DEBUG] agent.rpc: Accepted client: 127.0.0.1:52000", "stdout")
+			Expect(event.Level).To(Equal("debug"))
+			Expect(event.Source).To(Equal("agent.rpc"))
+		})
+
+		It("reports lines without a level at info from consul", func() {
+			event := agent.ParseLogLine("==> Starting Consul agent...", "stdout")
+			Expect(event).To(Equal(agent.LogEvent{
+				Level:   "info",
+				Source:  "consul",
+				Message: "==> Starting Consul agent...",
+				Stream:  "stdout",
+			}))
+		})
+	})
+
+	Describe("LogForwarder", func() {
+		var (
+			logger    *fakes.Logger
+			forwarder *agent.LogForwarder
+		)
+
+		BeforeEach(func() {
+			logger = &fakes.Logger{}
+			forwarder = &agent.LogForwarder{
+				Logger: logger,
+			}
+		})
+
+		It("forwards each line through the logger", func() {
+			output := strings.Join([]string{
+				"==> Starting Consul agent...",
+				"",
+				"    2016/03/15 19:14:30 [INFO] serf: EventMemberJoin: consul-z1-0 10.0.0.1",
+				"    2016/03/15 19:14:31 [ERR] agent: failed to sync remote state: No cluster leader",
+			}, "\n")
+
+			Expect(forwarder.Copy(strings.NewReader(output), "stdout")).To(Succeed())
+			Expect(logger.Messages).To(Equal([]fakes.LoggerMessage{
+				{
+					Action: "consul-log.forward",
+					Data: []lager.Data{{
+						"level":   "info",
+						"source":  "consul",
+						"message": "==> Starting Consul agent...",
+						"stream":  "stdout",
+					}},
+				},
+				{
+					Action: "consul-log.forward",
+					Data: []lager.Data{{
+						"level":   "info",
+						"source":  "serf",
+						"message": "EventMemberJoin: consul-z1-0 10.0.0.1",
+						"stream":  "stdout",
+					}},
+				},
+				{
+					Action: "consul-log.forward",
+					Error:  errors.New("failed to sync remote state: No cluster leader"),
+					Data: []lager.Data{{
+						"level":   "error",
+						"source":  "agent",
+						"message": "failed to sync remote state: No cluster leader",
+						"stream":  "stdout",
+					}},
+				},
+			}))
+		})
+
+		It("truncates lines that are too long and keeps reading", func() {
+			long := strings.Repeat("x", agent.MaxLogLineLength+100)
+			output := long + "\n    2016/03/15 19:14:30 [INFO] serf: EventMemberJoin: consul-z1-0 10.0.0.1\n"
+
+			Expect(forwarder.Copy(strings.NewReader(output), "stdout")).To(Succeed())
+			Expect(logger.Messages).To(Equal([]fakes.LoggerMessage{
+				{
+					Action: "consul-log.forward",
+					Data: []lager.Data{{
+						"level":   "info",
+						"source":  "consul",
+						"message": long[:agent.MaxLogLineLength],
+						"stream":  "stdout",
+					}},
+				},
+				{
+					Action: "consul-log.forward",
+					Data: []lager.Data{{
+						"level":   "info",
+						"source":  "serf",
+						"message": "EventMemberJoin: consul-z1-0 10.0.0.1",
+						"stream":  "stdout",
+					}},
+				},
+			}))
+		})
+
+		It("counts notable events", func() {
+			output := strings.Join([]string{
+				"    2016/03/15 19:14:30 [INFO] serf: EventMemberFailed: consul-z1-1 10.0.0.2",
+				"    2016/03/15 19:14:31 [WARN] raft: Election timeout reached, restarting election",
+				"    2016/03/15 19:14:32 [INFO] serf: EventMemberFailed: consul-z1-2 10.0.0.3",
+				"    2016/03/15 19:14:33 [WARN] agent: Join failed: dial tcp 10.0.0.4:8301: i/o timeout",
+			}, "\n")
+
+			Expect(forwarder.Copy(strings.NewReader(output), "stdout")).To(Succeed())
+			Expect(forwarder.Counts()).To(Equal(map[string]int{
+				"member-failed":    2,
+				"election-timeout": 1,
+				"join-failed":      1,
+			}))
+			Expect(logger.Messages).To(ContainElement(fakes.LoggerMessage{
+				Action: "consul-log.notable-event",
+				Data: []lager.Data{{
+					"event": "member-failed",
+					"count": 2,
+				}},
+			}))
+		})
+
+		Context("when an events file is set", func() {
+			var tempDir string
+
+			BeforeEach(func() {
+				var err error
+				tempDir, err = ioutil.TempDir("", "events")
+				Expect(err).NotTo(HaveOccurred())
+
+				forwarder.EventsFile = filepath.Join(tempDir, "events.json")
+			})
+
+			AfterEach(func() {
+				Expect(os.RemoveAll(tempDir)).To(Succeed())
+			})
+
+			It("writes the counts to the file", func() {
+				output := "    2016/03/15 19:14:30 [INFO] serf: EventMemberFailed: consul-z1-1 10.0.0.2\n"
+				Expect(forwarder.Copy(strings.NewReader(output), "stdout")).To(Succeed())
+
+				Expect(agent.ReadEventCounts(forwarder.EventsFile)).To(Equal(map[string]int{
+					"member-failed": 1,
+				}))
+			})
+
+			It("logs an error when the file cannot be written", func() {
+				forwarder.EventsFile = filepath.Join(tempDir, "missing", "events.json")
+
+				output := "    2016/03/15 19:14:30 [INFO] serf: EventMemberFailed: consul-z1-1 10.0.0.2\n"
+				Expect(forwarder.Copy(strings.NewReader(output), "stdout")).To(Succeed())
+
+				Expect(logger.Messages[len(logger.Messages)-1].Action).To(Equal("consul-log.write-events.failed"))
+			})
+		})
+	})
+})
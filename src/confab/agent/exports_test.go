@@ -0,0 +1,11 @@
+package agent
+
+import "syscall"
+
+func SysProcAttr(r *Runner) (*syscall.SysProcAttr, error) {
+	return r.sysProcAttr()
+}
+
+func LookupGroupID(path, name string) (int, error) {
+	return lookupGroupID(path, name)
+}
@@ -0,0 +1,132 @@
+package agent
+
+import (
+	"strconv"
+
+	"github.com/hashicorp/consul/api"
+)
+
+type MemberStatus int
+
+// The member statuses mirror serf.MemberStatus as reported by the members
+// endpoint.
+const (
+	MemberStatusNone MemberStatus = iota
+	MemberStatusAlive
+	MemberStatusLeaving
+	MemberStatusLeft
+	MemberStatusFailed
+)
+
+func (s MemberStatus) String() string {
+	switch s {
+	case MemberStatusNone:
+		return "none"
+	case MemberStatusAlive:
+		return "alive"
+	case MemberStatusLeaving:
+		return "leaving"
+	case MemberStatusLeft:
+		return "left"
+	case MemberStatusFailed:
+		return "failed"
+	default:
+		return "unknown"
+	}
+}
+
+func (s MemberStatus) MarshalText() ([]byte, error) {
+	return []byte(s.String()), nil
+}
+
+type MemberProtocol struct {
+	Min     int `json:"min"`
+	Max     int `json:"max"`
+	Current int `json:"current"`
+}
+
+// Protocol is the consul protocol advertised in the member's tags, the one
+// set by protocol_version. SerfProtocol is the gossip protocol serf speaks.
+type Member struct {
+	Name         string         `json:"name"`
+	Addr         string         `json:"addr"`
+	Port         uint16         `json:"port"`
+	Status       MemberStatus   `json:"status"`
+	Role         string         `json:"role"`
+	Datacenter   string         `json:"datacenter"`
+	Protocol     MemberProtocol `json:"protocol"`
+	SerfProtocol MemberProtocol `json:"serf_protocol"`
+	Build        string         `json:"build"`
+}
+
+// NewMember reads the consul specific details of a member out of its serf
+// tags.
+func NewMember(member *api.AgentMember) Member {
+	return Member{
+		Name:       member.Name,
+		Addr:       member.Addr,
+		Port:       member.Port,
+		Status:     MemberStatus(member.Status),
+		Role:       member.Tags["role"],
+		Datacenter: member.Tags["dc"],
+		Protocol: MemberProtocol{
+			Min:     tagInt(member.Tags, "vsn_min"),
+			Max:     tagInt(member.Tags, "vsn_max"),
+			Current: tagInt(member.Tags, "vsn"),
+		},
+		SerfProtocol: MemberProtocol{
+			Min:     int(member.ProtocolMin),
+			Max:     int(member.ProtocolMax),
+			Current: int(member.ProtocolCur),
+		},
+		Build: member.Tags["build"],
+	}
+}
+
+func (m Member) IsServer() bool {
+	return m.Role == "consul"
+}
+
+func (m Member) IsAlive() bool {
+	return m.Status == MemberStatusAlive
+}
+
+func AliveServers(members []Member) []Member {
+	servers := []Member{}
+	for _, member := range members {
+		if member.IsServer() && member.IsAlive() {
+			servers = append(servers, member)
+		}
+	}
+
+	return servers
+}
+
+func FailedMembers(members []Member) []Member {
+	failed := []Member{}
+	for _, member := range members {
+		if member.Status == MemberStatusFailed {
+			failed = append(failed, member)
+		}
+	}
+
+	return failed
+}
+
+func MembersByDatacenter(members []Member) map[string][]Member {
+	datacenters := map[string][]Member{}
+	for _, member := range members {
+		datacenters[member.Datacenter] = append(datacenters[member.Datacenter], member)
+	}
+
+	return datacenters
+}
+
+func tagInt(tags map[string]string, key string) int {
+	value, err := strconv.Atoi(tags[key])
+	if err != nil {
+		return 0
+	}
+
+	return value
+}
@@ -0,0 +1,104 @@
+package agent_test
+
+import (
+	"confab/agent"
+	"encoding/json"
+
+	"github.com/hashicorp/consul/api"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Member", func() {
+	var members []agent.Member
+
+	BeforeEach(func() {
+		members = []agent.Member{
+			{Name: "consul-z1-0", Status: agent.MemberStatusAlive, Role: "consul", Datacenter: "dc1"},
+			{Name: "consul-z1-1", Status: agent.MemberStatusFailed, Role: "consul", Datacenter: "dc1"},
+			{Name: "consul-z2-0", Status: agent.MemberStatusLeft, Role: "consul", Datacenter: "dc2"},
+			{Name: "router-z1-0", Status: agent.MemberStatusAlive, Role: "node", Datacenter: "dc1"},
+			{Name: "router-z1-1", Status: agent.MemberStatusFailed, Role: "node", Datacenter: "dc2"},
+		}
+	})
+
+	Describe("NewMember", func() {
+		It("reads the consul tags", func() {
+			member := agent.NewMember(&api.AgentMember{
+				Name:        "consul-z1-0",
+				Addr:        "10.0.0.1",
+				Port:        8301,
+				Status:      1,
+				ProtocolMin: 1,
+				ProtocolMax: 4,
+				ProtocolCur: 4,
+				Tags: map[string]string{
+					"role":    "consul",
+					"dc":      "dc1",
+					"vsn":     "2",
+					"vsn_min": "1",
+					"vsn_max": "3",
+					"build":   "0.6.4:26a0ef8c",
+				},
+			})
+
+			Expect(member).To(Equal(agent.Member{
+				Name:       "consul-z1-0",
+				Addr:       "10.0.0.1",
+				Port:       8301,
+				Status:     agent.MemberStatusAlive,
+				Role:       "consul",
+				Datacenter: "dc1",
+				Protocol: agent.MemberProtocol{
+					Min:     1,
+					Max:     3,
+					Current: 2,
+				},
+				SerfProtocol: agent.MemberProtocol{
+					Min:     1,
+					Max:     4,
+					Current: 4,
+				},
+				Build: "0.6.4:26a0ef8c",
+			}))
+			Expect(member.IsServer()).To(BeTrue())
+			Expect(member.IsAlive()).To(BeTrue())
+		})
+
+		It("ignores protocol tags that are not numbers", func() {
+			member := agent.NewMember(&api.AgentMember{
+				Tags: map[string]string{"vsn": "banana"},
+			})
+
+			Expect(member.Protocol.Current).To(Equal(0))
+		})
+	})
+
+	It("marshals the status by name", func() {
+		data, err := json.Marshal(agent.Member{Status: agent.MemberStatusFailed})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(string(data)).To(ContainSubstring(`"status":"failed"`))
+	})
+
+	Describe("AliveServers", func() {
+		It("returns the servers that are alive", func() {
+			Expect(agent.AliveServers(members)).To(Equal([]agent.Member{members[0]}))
+		})
+	})
+
+	Describe("FailedMembers", func() {
+		It("returns the failed servers and clients", func() {
+			Expect(agent.FailedMembers(members)).To(Equal([]agent.Member{members[1], members[4]}))
+		})
+	})
+
+	Describe("MembersByDatacenter", func() {
+		It("groups the members by datacenter", func() {
+			Expect(agent.MembersByDatacenter(members)).To(Equal(map[string][]agent.Member{
+				"dc1": {members[0], members[1], members[3]},
+				"dc2": {members[2], members[4]},
+			}))
+		})
+	})
+})
@@ -0,0 +1,226 @@
+package agent
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/user"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"syscall"
+
+	"github.com/pivotal-golang/lager"
+)
+
+const groupFile = "/etc/group"
+
+const rlimInfinity = ^uint64(0)
+
+var rlimitResources = map[string]int{
+	"as":     syscall.RLIMIT_AS,
+	"core":   syscall.RLIMIT_CORE,
+	"cpu":    syscall.RLIMIT_CPU,
+	"data":   syscall.RLIMIT_DATA,
+	"fsize":  syscall.RLIMIT_FSIZE,
+	"nofile": syscall.RLIMIT_NOFILE,
+	"stack":  syscall.RLIMIT_STACK,
+}
+
+// Rlimit is a soft limit. Hard limits are left to whoever starts confab,
+// since confab may not be allowed to raise them again.
+type Rlimit struct {
+	Name     string
+	Resource int
+	Cur      uint64
+}
+
+// ParseRlimits turns limits keyed by their ulimit name into rlimits. A
+// negative value means unlimited.
+func ParseRlimits(limits map[string]int64) ([]Rlimit, error) {
+	var names []string
+	for name := range limits {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var rlimits []Rlimit
+	for _, name := range names {
+		resource, ok := rlimitResources[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown rlimit %q", name)
+		}
+
+		value := uint64(limits[name])
+		if limits[name] < 0 {
+			value = rlimInfinity
+		}
+
+		rlimits = append(rlimits, Rlimit{
+			Name:     name,
+			Resource: resource,
+			Cur:      value,
+		})
+	}
+
+	return rlimits, nil
+}
+
+// DefaultGOMAXPROCS is one per CPU, but never less than two so that a single
+// CPU VM still has a spare thread while raft is busy.
+func DefaultGOMAXPROCS() int {
+	if runtime.NumCPU() < 2 {
+		return 2
+	}
+
+	return runtime.NumCPU()
+}
+
+func (r *Runner) environment() []string {
+	if len(r.Env) == 0 && r.GOMAXPROCS == 0 {
+		return nil
+	}
+
+	overrides := append([]string{}, r.Env...)
+	if r.GOMAXPROCS > 0 {
+		overrides = append(overrides, fmt.Sprintf("GOMAXPROCS=%d", r.GOMAXPROCS))
+	}
+
+	// the child would see the first of two duplicate keys, so inherited
+	// variables that are overridden have to go
+	overridden := map[string]bool{}
+	for _, variable := range overrides {
+		overridden[envKey(variable)] = true
+	}
+
+	var env []string
+	for _, variable := range os.Environ() {
+		if !overridden[envKey(variable)] {
+			env = append(env, variable)
+		}
+	}
+
+	return append(env, overrides...)
+}
+
+func envKey(variable string) string {
+	if i := strings.Index(variable, "="); i >= 0 {
+		return variable[:i]
+	}
+
+	return variable
+}
+
+// sysProcAttr drops a child to User and Group. Nothing is changed when they
+// are who confab already runs as, e.g. when the ctl script has started it as
+// vcap, since only root may set the credentials of a child.
+func (r *Runner) sysProcAttr() (*syscall.SysProcAttr, error) {
+	if r.User == "" && r.Group == "" {
+		return nil, nil
+	}
+
+	credential := &syscall.Credential{
+		Uid: uint32(os.Getuid()),
+		Gid: uint32(os.Getgid()),
+	}
+
+	if r.User != "" {
+		u, err := user.Lookup(r.User)
+		if err != nil {
+			return nil, err
+		}
+
+		uid, err := strconv.Atoi(u.Uid)
+		if err != nil {
+			return nil, err // not tested, uids are always numeric on linux
+		}
+
+		gid, err := strconv.Atoi(u.Gid)
+		if err != nil {
+			return nil, err // not tested, gids are always numeric on linux
+		}
+
+		credential.Uid = uint32(uid)
+		credential.Gid = uint32(gid)
+	}
+
+	if r.Group != "" {
+		gid, err := lookupGroupID(groupFile, r.Group)
+		if err != nil {
+			return nil, err
+		}
+
+		credential.Gid = uint32(gid)
+	}
+
+	if credential.Uid == uint32(os.Getuid()) && credential.Gid == uint32(os.Getgid()) {
+		return nil, nil
+	}
+
+	return &syscall.SysProcAttr{
+		Credential: credential,
+	}, nil
+}
+
+// lookupGroupID finds a group in an /etc/group style file; os/user cannot
+// look up groups in the go version confab is built with.
+func lookupGroupID(path, name string) (int, error) {
+	contents, err := ioutil.ReadFile(path)
+	if err != nil {
+		return 0, err
+	}
+
+	for _, line := range strings.Split(string(contents), "\n") {
+		// name:password:gid:members
+		fields := strings.Split(line, ":")
+		if len(fields) < 3 || fields[0] != name {
+			continue
+		}
+
+		return strconv.Atoi(fields[2])
+	}
+
+	return 0, fmt.Errorf("group: unknown group %s", name)
+}
+
+// applyRlimits sets the soft limits on confab so that the agent inherits them
+// when it is started, and returns a func that puts confab's own limits back.
+// A limit above the hard limit is lowered to it.
+func (r *Runner) applyRlimits() (func(), error) {
+	previous := map[int]syscall.Rlimit{}
+	restore := func() {
+		for resource, limit := range previous {
+			syscall.Setrlimit(resource, &limit)
+		}
+	}
+
+	for _, limit := range r.Rlimits {
+		var current syscall.Rlimit
+		if err := syscall.Getrlimit(limit.Resource, &current); err != nil {
+			restore()
+			return nil, err
+		}
+
+		cur := limit.Cur
+		if cur > current.Max {
+			r.Logger.Info("agent-runner.run.set-rlimits.above-hard-limit", lager.Data{
+				"name":       limit.Name,
+				"limit":      limit.Cur,
+				"hard_limit": current.Max,
+			})
+			cur = current.Max
+		}
+
+		if err := syscall.Setrlimit(limit.Resource, &syscall.Rlimit{Cur: cur, Max: current.Max}); err != nil {
+			restore()
+			return nil, fmt.Errorf("setting rlimit %s: %s", limit.Name, err)
+		}
+
+		if _, ok := previous[limit.Resource]; !ok {
+			previous[limit.Resource] = current
+		}
+	}
+
+	return restore, nil
+}
@@ -0,0 +1,143 @@
+package agent_test
+
+import (
+	"confab/agent"
+	"io/ioutil"
+	"os"
+	"os/user"
+	"strconv"
+	"syscall"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Process", func() {
+	Describe("ParseRlimits", func() {
+		It("parses the limits by their ulimit names", func() {
+			rlimits, err := agent.ParseRlimits(map[string]int64{
+				"nofile": 4096,
+				"as":     -1,
+			})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(rlimits).To(Equal([]agent.Rlimit{
+				{
+					Name:     "as",
+					Resource: syscall.RLIMIT_AS,
+					Cur:      ^uint64(0),
+				},
+				{
+					Name:     "nofile",
+					Resource: syscall.RLIMIT_NOFILE,
+					Cur:      4096,
+				},
+			}))
+		})
+
+		It("returns an error for an unknown limit", func() {
+			_, err := agent.ParseRlimits(map[string]int64{"memlock": 64})
+			Expect(err).To(MatchError(`unknown rlimit "memlock"`))
+		})
+	})
+
+	Describe("SysProcAttr", func() {
+		var runner *agent.Runner
+
+		BeforeEach(func() {
+			runner = &agent.Runner{}
+		})
+
+		It("leaves the credentials alone without a user or group", func() {
+			attr, err := agent.SysProcAttr(runner)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(attr).To(BeNil())
+		})
+
+		It("leaves the credentials alone when they are confab's own", func() {
+			currentUser, err := user.Current()
+			Expect(err).NotTo(HaveOccurred())
+			runner.User = currentUser.Username
+
+			attr, err := agent.SysProcAttr(runner)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(attr).To(BeNil())
+		})
+
+		It("drops to the user and its primary group", func() {
+			nobody, err := user.Lookup("nobody")
+			Expect(err).NotTo(HaveOccurred())
+			uid, err := strconv.Atoi(nobody.Uid)
+			Expect(err).NotTo(HaveOccurred())
+			gid, err := strconv.Atoi(nobody.Gid)
+			Expect(err).NotTo(HaveOccurred())
+
+			runner.User = "nobody"
+
+			attr, err := agent.SysProcAttr(runner)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(attr.Credential).To(Equal(&syscall.Credential{
+				Uid: uint32(uid),
+				Gid: uint32(gid),
+			}))
+		})
+
+		It("uses the group over the user's primary group", func() {
+			runner.User = "nobody"
+			runner.Group = "root"
+
+			attr, err := agent.SysProcAttr(runner)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(attr.Credential.Gid).To(Equal(uint32(0)))
+		})
+
+		It("returns an error when the group does not exist", func() {
+			runner.Group = "no-such-group"
+
+			_, err := agent.SysProcAttr(runner)
+			Expect(err).To(MatchError("group: unknown group no-such-group"))
+		})
+	})
+
+	Describe("LookupGroupID", func() {
+		var groupFile string
+
+		BeforeEach(func() {
+			file, err := ioutil.TempFile("", "group")
+			Expect(err).NotTo(HaveOccurred())
+			defer file.Close()
+
+			_, err = file.WriteString("root:x:0:\nvcap:x:1000:syslog\nbroken:x:abc:\n")
+			Expect(err).NotTo(HaveOccurred())
+			groupFile = file.Name()
+		})
+
+		AfterEach(func() {
+			os.Remove(groupFile)
+		})
+
+		It("finds the gid of the group", func() {
+			Expect(agent.LookupGroupID(groupFile, "vcap")).To(Equal(1000))
+		})
+
+		It("returns an error when the group does not exist", func() {
+			_, err := agent.LookupGroupID(groupFile, "syslog")
+			Expect(err).To(MatchError("group: unknown group syslog"))
+		})
+
+		It("returns an error when the gid is not a number", func() {
+			_, err := agent.LookupGroupID(groupFile, "broken")
+			Expect(err).To(MatchError(ContainSubstring("invalid syntax")))
+		})
+
+		It("returns an error when the file cannot be read", func() {
+			_, err := agent.LookupGroupID("/no/such/group", "vcap")
+			Expect(err).To(MatchError(ContainSubstring("no such file or directory")))
+		})
+	})
+
+	Describe("DefaultGOMAXPROCS", func() {
+		It("is never less than two", func() {
+			Expect(agent.DefaultGOMAXPROCS()).To(BeNumerically(">=", 2))
+		})
+	})
+})
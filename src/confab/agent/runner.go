@@ -7,7 +7,9 @@ import (
 	"io/ioutil"
 	"os"
 	"os/exec"
+	"path/filepath"
 	"strconv"
+	"strings"
 	"syscall"
 	"time"
 
@@ -23,31 +25,164 @@ type Runner struct {
 	Recursors []string
 	Logger    logger
 	cmd       *exec.Cmd
+
+	// LogForwarder is the command that consul's output is piped through,
+	// stdout on fd 3 and stderr on fd 4. It has to outlive confab, which
+	// exits once the agent is configured.
+	LogForwarder []string
+
+	// EventsFile is where the forwarder counts notable log events. The
+	// counts belong to one agent, so Run removes the file before starting a
+	// new one.
+	EventsFile string
+
+	// Rlimits, GOMAXPROCS, Env and Dir are applied to the agent only; User and
+	// Group also apply to the forwarder.
+	Rlimits    []Rlimit
+	GOMAXPROCS int
+	Env        []string
+	Dir        string
+	User       string
+	Group      string
+}
+
+var errAgentNotRunning = errors.New("agent is not running")
+
+// checkIdentity makes sure pid belongs to a running instance of the agent
+// binary, so that a pid reused after a reboot is never mistaken for it. The
+// executable link is checked first; the command line is the fallback when
+// the link cannot be read, e.g. for a process owned by another user.
+// Binaries are matched by name, since the agent may still be running from
+// the package a deploy has just replaced.
+func (r *Runner) checkIdentity(pid int) error {
+	procDir := fmt.Sprintf("/proc/%d", pid)
+	if _, err := os.Stat(procDir); pid <= 0 || os.IsNotExist(err) {
+		return errAgentNotRunning
+	}
+
+	executable, err := os.Readlink(filepath.Join(procDir, "exe"))
+	if err == nil {
+		executable = strings.TrimSuffix(executable, " (deleted)")
+	} else {
+		cmdline, err := ioutil.ReadFile(filepath.Join(procDir, "cmdline"))
+		if err != nil {
+			return err
+		}
+
+		executable = strings.Split(string(cmdline), "\x00")[0]
+		if executable == "" {
+			// zombies have no command line
+			return errAgentNotRunning
+		}
+	}
+
+	if !r.isAgentPath(executable) {
+		return fmt.Errorf("pid %d is %s, not %s", pid, executable, r.Path)
+	}
+
+	return nil
 }
 
-func isRunningProcess(pidFilePath string) bool {
-	pidFileContents, err := ioutil.ReadFile(pidFilePath)
+func (r *Runner) isAgentPath(executable string) bool {
+	if filepath.Base(executable) == filepath.Base(r.Path) {
+		return true
+	}
+
+	resolved, err := filepath.EvalSymlinks(r.Path)
+	return err == nil && filepath.Base(executable) == filepath.Base(resolved)
+}
+
+// lockPIDFile takes a flock on a lock file next to the pid file. The pid file
+// itself is replaced on every write, so it cannot carry the lock.
+func (r *Runner) lockPIDFile(how int) (func(), error) {
+	lockFile, err := os.OpenFile(r.PIDFile+".lock", os.O_CREATE|os.O_RDWR, 0644)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := syscall.Flock(int(lockFile.Fd()), how); err != nil {
+		lockFile.Close()
+		return nil, err
+	}
+
+	return func() {
+		syscall.Flock(int(lockFile.Fd()), syscall.LOCK_UN)
+		lockFile.Close()
+	}, nil
+}
+
+func (r *Runner) readPID() (int, error) {
+	// readers that cannot create the lock file, e.g. when the directory is
+	// missing, fall through to the read and its error
+	if unlock, err := r.lockPIDFile(syscall.LOCK_SH); err == nil {
+		defer unlock()
+	}
+
+	pidFileContents, err := ioutil.ReadFile(r.PIDFile)
 	if err != nil {
-		return false
+		return 0, err
 	}
 
-	pid, err := strconv.Atoi(string(pidFileContents))
+	return strconv.Atoi(strings.TrimSpace(string(pidFileContents)))
+}
+
+// removeStalePIDFile removes a pid file left behind by an agent that is no
+// longer running. It errors when the agent is still running.
+func (r *Runner) removeStalePIDFile() error {
+	if _, err := os.Stat(r.PIDFile); os.IsNotExist(err) {
+		return nil
+	}
+
+	unlock, err := r.lockPIDFile(syscall.LOCK_EX)
 	if err != nil {
-		return false
+		r.Logger.Error("agent-runner.run.lock-pidfile.failed", err, lager.Data{
+			"path": r.PIDFile,
+		})
+		return err
 	}
+	defer unlock()
 
-	proc, err := os.FindProcess(pid)
+	var reason string
+	pidFileContents, err := ioutil.ReadFile(r.PIDFile)
+	if os.IsNotExist(err) {
+		return nil
+	}
+
+	pid, err := strconv.Atoi(strings.TrimSpace(string(pidFileContents)))
 	if err != nil {
-		return false
+		reason = fmt.Sprintf("invalid pid %q", string(pidFileContents))
+	} else {
+		switch err := r.checkIdentity(pid); err {
+		case nil:
+			err := fmt.Errorf("consul_agent is already running, please stop it first")
+			r.Logger.Error("agent-runner.run.consul-already-running", err, lager.Data{
+				"pid": pid,
+			})
+			return err
+		case errAgentNotRunning:
+			reason = "not running"
+		default:
+			reason = err.Error()
+		}
 	}
-	err = proc.Signal(syscall.Signal(0))
-	return err == nil
+
+	r.Logger.Info("agent-runner.run.remove-stale-pidfile", lager.Data{
+		"path":   r.PIDFile,
+		"reason": reason,
+	})
+
+	if err := os.Remove(r.PIDFile); err != nil && !os.IsNotExist(err) {
+		r.Logger.Error("agent-runner.run.remove-stale-pidfile.failed", err, lager.Data{
+			"path": r.PIDFile,
+		})
+		return err
+	}
+
+	return nil
 }
 
 func (r *Runner) Run() error {
-	if isRunningProcess(r.PIDFile) {
-		err := fmt.Errorf("consul_agent is already running, please stop it first")
-		r.Logger.Error("agent-runner.run.consul-already-running", err)
+	if err := r.removeStalePIDFile(); err != nil {
 		return err
 	}
 
@@ -66,15 +201,66 @@ func (r *Runner) Run() error {
 		args = append(args, fmt.Sprintf("-recursor=%s", recursor))
 	}
 
+	sysProcAttr, err := r.sysProcAttr()
+	if err != nil {
+		r.Logger.Error("agent-runner.run.lookup-user.failed", err, lager.Data{
+			"user":  r.User,
+			"group": r.Group,
+		})
+		return err
+	}
+
 	r.cmd = exec.Command(r.Path, args...)
 	r.cmd.Stdout = r.Stdout
 	r.cmd.Stderr = r.Stderr
+	r.cmd.Env = r.environment()
+	r.cmd.Dir = r.Dir
+	r.cmd.SysProcAttr = sysProcAttr
+
+	if r.EventsFile != "" {
+		if err := os.Remove(r.EventsFile); err != nil && !os.IsNotExist(err) {
+			err = errors.New(err.Error())
+			r.Logger.Error("agent-runner.run.remove-events-file.failed", err, lager.Data{
+				"path": r.EventsFile,
+			})
+			return err
+		}
+	}
+
+	var pipes []*os.File
+	if len(r.LogForwarder) > 0 {
+		pipes, err = r.startLogForwarder(sysProcAttr)
+		if err != nil {
+			return err
+		}
+		r.cmd.Stdout = pipes[0]
+		r.cmd.Stderr = pipes[1]
+	}
+
+	// exec has no way to set rlimits on the child alone, so confab takes them
+	// on for as long as it takes to start the agent
+	restoreRlimits, err := r.applyRlimits()
+	if err != nil {
+		for _, pipe := range pipes {
+			pipe.Close()
+		}
+		r.Logger.Error("agent-runner.run.set-rlimits.failed", err)
+		return err
+	}
 
 	r.Logger.Info("agent-runner.run.start", lager.Data{
 		"cmd":  r.Path,
 		"args": args,
 	})
-	err := r.cmd.Start()
+	err = r.cmd.Start()
+	restoreRlimits()
+
+	// consul and the forwarder hold their own copies of the pipe ends; the
+	// forwarder sees EOF once consul exits or fails to start
+	for _, pipe := range pipes {
+		pipe.Close()
+	}
+
 	if err != nil {
 		r.Logger.Error("agent-runner.run.start.failed", errors.New(err.Error()), lager.Data{
 			"cmd":  r.Path,
@@ -89,13 +275,57 @@ func (r *Runner) Run() error {
 	return nil
 }
 
+// startLogForwarder starts the forwarder reading from two new pipes and
+// returns their write ends for consul's stdout and stderr.
+func (r *Runner) startLogForwarder(sysProcAttr *syscall.SysProcAttr) ([]*os.File, error) {
+	stdoutReader, stdoutWriter, err := os.Pipe()
+	if err != nil {
+		r.Logger.Error("agent-runner.run.log-forwarder.pipe.failed", err)
+		return nil, err
+	}
+
+	stderrReader, stderrWriter, err := os.Pipe()
+	if err != nil {
+		stdoutReader.Close()
+		stdoutWriter.Close()
+		r.Logger.Error("agent-runner.run.log-forwarder.pipe.failed", err)
+		return nil, err
+	}
+
+	forwarder := exec.Command(r.LogForwarder[0], r.LogForwarder[1:]...)
+	forwarder.ExtraFiles = []*os.File{stdoutReader, stderrReader}
+	forwarder.Stdout = r.Stdout
+	forwarder.Stderr = r.Stderr
+	forwarder.SysProcAttr = sysProcAttr
+
+	r.Logger.Info("agent-runner.run.log-forwarder.start", lager.Data{
+		"cmd": r.LogForwarder,
+	})
+
+	err = forwarder.Start()
+	stdoutReader.Close()
+	stderrReader.Close()
+	if err != nil {
+		stdoutWriter.Close()
+		stderrWriter.Close()
+		r.Logger.Error("agent-runner.run.log-forwarder.start.failed", errors.New(err.Error()), lager.Data{
+			"cmd": r.LogForwarder,
+		})
+		return nil, err
+	}
+
+	go forwarder.Wait() // reap the forwarder once consul's output closes
+
+	return []*os.File{stdoutWriter, stderrWriter}, nil
+}
+
 func (r *Runner) WritePID() error {
 	r.Logger.Info("agent-runner.run.write-pidfile", lager.Data{
 		"pid":  r.cmd.Process.Pid,
 		"path": r.PIDFile,
 	})
 
-	if err := ioutil.WriteFile(r.PIDFile, []byte(fmt.Sprintf("%d", r.cmd.Process.Pid)), 0644); err != nil {
+	if err := r.writePIDFile(r.cmd.Process.Pid); err != nil {
 		err = fmt.Errorf("error writing PID file: %s", err)
 		r.Logger.Error("agent-runner.run.write-pidfile.failed", err, lager.Data{
 			"pid":  r.cmd.Process.Pid,
@@ -107,18 +337,49 @@ func (r *Runner) WritePID() error {
 	return nil
 }
 
+// writePIDFile writes the pid to a temporary file and renames it over the pid
+// file, so readers never see a partially written pid.
+func (r *Runner) writePIDFile(pid int) error {
+	unlock, err := r.lockPIDFile(syscall.LOCK_EX)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	tempFile, err := ioutil.TempFile(filepath.Dir(r.PIDFile), filepath.Base(r.PIDFile))
+	if err != nil {
+		return err
+	}
+
+	_, err = tempFile.WriteString(strconv.Itoa(pid))
+	if err == nil {
+		err = tempFile.Chmod(0644)
+	}
+	if err == nil {
+		err = tempFile.Sync()
+	}
+	if closeErr := tempFile.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(tempFile.Name())
+		return err
+	}
+
+	return os.Rename(tempFile.Name(), r.PIDFile)
+}
+
 func (r *Runner) getProcess() (*os.Process, error) {
 	if r.cmd != nil && r.cmd.Process != nil {
 		return r.cmd.Process, nil
 	}
 
-	pidFileContents, err := ioutil.ReadFile(r.PIDFile)
+	pid, err := r.readPID()
 	if err != nil {
 		return nil, err
 	}
 
-	pid, err := strconv.Atoi(string(pidFileContents))
-	if err != nil {
+	if err := r.checkIdentity(pid); err != nil {
 		return nil, err
 	}
 
@@ -134,6 +395,10 @@ func (r *Runner) Wait() error {
 	r.Logger.Info("agent-runner.wait.get-process")
 
 	process, err := r.getProcess()
+	if err == errAgentNotRunning {
+		r.Logger.Info("agent-runner.wait.not-running")
+		return nil
+	}
 	if err != nil {
 		r.Logger.Error("agent-runner.wait.get-process.failed", errors.New(err.Error()))
 		return err
@@ -193,6 +458,10 @@ func (r *Runner) Cleanup() error {
 		"pidfile": r.PIDFile,
 	})
 
+	if unlock, err := r.lockPIDFile(syscall.LOCK_EX); err == nil {
+		defer unlock()
+	}
+
 	if err := os.Remove(r.PIDFile); err != nil {
 		err = errors.New(err.Error())
 		r.Logger.Error("agent-runner.cleanup.remove.failed", err, lager.Data{
@@ -10,8 +10,10 @@ import (
 	"io/ioutil"
 	"math/rand"
 	"os"
+	"os/user"
 	"path/filepath"
 	"strconv"
+	"strings"
 	"syscall"
 
 	"github.com/pivotal-golang/lager"
@@ -54,6 +56,7 @@ var _ = Describe("Runner", func() {
 
 	AfterEach(func() {
 		os.Remove(runner.PIDFile)
+		os.Remove(runner.PIDFile + ".lock")
 		os.RemoveAll(runner.ConfigDir)
 	})
 
@@ -189,6 +192,21 @@ var _ = Describe("Runner", func() {
 				Expect(runner.Stop()).To(HaveOccurred())
 			})
 		})
+
+		Context("when the PID file has the PID of a process that is not the agent", func() {
+			It("returns an error without signalling the process", func() {
+				myPID := os.Getpid()
+				Expect(ioutil.WriteFile(runner.PIDFile, []byte(fmt.Sprintf("%d", myPID)), 0644)).To(Succeed())
+
+				Expect(runner.Stop()).To(MatchError(ContainSubstring(fmt.Sprintf("pid %d is ", myPID))))
+				Expect(logger.Messages).NotTo(ContainElement(fakes.LoggerMessage{
+					Action: "agent-runner.stop.signal",
+					Data: []lager.Data{{
+						"pid": myPID,
+					}},
+				}))
+			})
+		})
 	})
 
 	Describe("stop & wait", func() {
@@ -315,9 +333,31 @@ var _ = Describe("Runner", func() {
 			Expect(runner.Wait()).To(Succeed())
 		})
 
+		It("replaces an existing pid file", func() {
+			Expect(ioutil.WriteFile(runner.PIDFile, []byte("some-pid"), 0600)).To(Succeed())
+			Expect(runner.WritePID()).To(Succeed())
+
+			pid, err := getPID(runner)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(pid).NotTo(BeZero())
+
+			matches, err := filepath.Glob(runner.PIDFile + "?*")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(matches).To(ConsistOf(runner.PIDFile + ".lock"))
+
+			Expect(runner.Wait()).To(Succeed())
+		})
+
 		Context("when writing the PID file errors", func() {
 			It("returns the error", func() {
-				Expect(ioutil.WriteFile(runner.PIDFile, []byte("some-pid"), 0100)).To(Succeed())
+				pidDir, err := ioutil.TempDir("", "read-only-pid-dir")
+				Expect(err).NotTo(HaveOccurred())
+				defer os.RemoveAll(pidDir)
+
+				runner.PIDFile = filepath.Join(pidDir, "agent.pid")
+				Expect(os.Chmod(pidDir, 0500)).To(Succeed())
+				defer os.Chmod(pidDir, 0700)
+
 				Expect(runner.WritePID()).To(MatchError(ContainSubstring("error writing PID file")))
 				Expect(runner.WritePID()).To(MatchError(ContainSubstring("permission denied")))
 			})
@@ -401,19 +441,276 @@ var _ = Describe("Runner", func() {
 			Expect(stderrBytes.String()).To(Equal("some standard error"))
 		})
 
+		Context("when a log forwarder is configured", func() {
+			It("pipes the agent output through the forwarder", func() {
+				forwarded := filepath.Join(runner.ConfigDir, "forwarded")
+				runner.LogForwarder = []string{"/bin/sh", "-c", "cat <&3 > $0; cat <&4 >> $0", forwarded}
+
+				Expect(runner.Run()).To(Succeed())
+				Expect(runner.WritePID()).To(Succeed())
+				Expect(runner.Wait()).To(Succeed())
+
+				Eventually(func() (string, error) {
+					contents, err := ioutil.ReadFile(forwarded)
+					return string(contents), err
+				}, "5s").Should(Equal("some standard outsome standard error"))
+			})
+
+			It("removes the events counted for the previous agent", func() {
+				runner.EventsFile = filepath.Join(runner.ConfigDir, "events.json")
+				Expect(ioutil.WriteFile(runner.EventsFile, []byte(`{"raft.election.timeout": 3}`), 0600)).To(Succeed())
+
+				Expect(runner.Run()).To(Succeed())
+				Expect(runner.WritePID()).To(Succeed())
+				Expect(runner.Wait()).To(Succeed())
+
+				_, err := os.Stat(runner.EventsFile)
+				Expect(os.IsNotExist(err)).To(BeTrue())
+			})
+
+			It("returns an error when the events file cannot be removed", func() {
+				runner.EventsFile = filepath.Join(runner.ConfigDir, "events")
+				Expect(os.MkdirAll(filepath.Join(runner.EventsFile, "not-empty"), 0700)).To(Succeed())
+
+				Expect(runner.Run()).To(MatchError(ContainSubstring("directory not empty")))
+				Expect(logger.Messages).To(ContainElement(fakes.LoggerMessage{
+					Action: "agent-runner.run.remove-events-file.failed",
+					Error:  errors.New("remove " + runner.EventsFile + ": directory not empty"),
+					Data: []lager.Data{{
+						"path": runner.EventsFile,
+					}},
+				}))
+			})
+
+			It("returns an error when the forwarder cannot be started", func() {
+				runner.LogForwarder = []string{"/no/such/forwarder"}
+
+				Expect(runner.Run()).To(MatchError(ContainSubstring("no such file or directory")))
+				Expect(logger.Messages).To(ContainElement(fakes.LoggerMessage{
+					Action: "agent-runner.run.log-forwarder.start.failed",
+					Error:  errors.New("fork/exec /no/such/forwarder: no such file or directory"),
+					Data: []lager.Data{{
+						"cmd": []string{"/no/such/forwarder"},
+					}},
+				}))
+			})
+		})
+
+		Context("when the process environment is configured", func() {
+			It("adds the environment and GOMAXPROCS to confab's own", func() {
+				runner.Env = []string{"SOME_VAR=some-value"}
+				runner.GOMAXPROCS = 3
+
+				Expect(runner.Run()).To(Succeed())
+				Expect(runner.WritePID()).To(Succeed())
+				Expect(runner.Wait()).To(Succeed())
+
+				output := getFakeAgentOutput(runner)
+				Expect(output.GOMAXPROCS).To(Equal("3"))
+				Expect(output.Env).To(ContainElement("SOME_VAR=some-value"))
+				Expect(output.Env).To(ContainElement("PATH=" + os.Getenv("PATH")))
+			})
+
+			It("overrides inherited variables instead of duplicating them", func() {
+				Expect(os.Setenv("GOMAXPROCS", "7")).To(Succeed())
+				defer os.Unsetenv("GOMAXPROCS")
+
+				runner.Env = []string{"PATH=/some/bin"}
+				runner.GOMAXPROCS = 3
+
+				Expect(runner.Run()).To(Succeed())
+				Expect(runner.WritePID()).To(Succeed())
+				Expect(runner.Wait()).To(Succeed())
+
+				output := getFakeAgentOutput(runner)
+				Expect(output.GOMAXPROCS).To(Equal("3"))
+
+				var paths, gomaxprocs []string
+				for _, variable := range output.Env {
+					switch {
+					case strings.HasPrefix(variable, "PATH="):
+						paths = append(paths, variable)
+					case strings.HasPrefix(variable, "GOMAXPROCS="):
+						gomaxprocs = append(gomaxprocs, variable)
+					}
+				}
+				Expect(paths).To(Equal([]string{"PATH=/some/bin"}))
+				Expect(gomaxprocs).To(Equal([]string{"GOMAXPROCS=3"}))
+			})
+
+			It("runs the agent in the working directory", func() {
+				runner.Dir = runner.ConfigDir
+
+				Expect(runner.Run()).To(Succeed())
+				Expect(runner.WritePID()).To(Succeed())
+				Expect(runner.Wait()).To(Succeed())
+
+				dir, err := filepath.EvalSymlinks(runner.ConfigDir)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(getFakeAgentOutput(runner).Dir).To(Equal(dir))
+			})
+
+			It("applies the rlimits to the agent but not to confab", func() {
+				var before syscall.Rlimit
+				Expect(syscall.Getrlimit(syscall.RLIMIT_CORE, &before)).To(Succeed())
+				if before.Max < 1024 {
+					Skip("the core file size hard limit is too low to lower the soft limit")
+				}
+
+				runner.Rlimits = []agent.Rlimit{{
+					Name:     "core",
+					Resource: syscall.RLIMIT_CORE,
+					Cur:      1024,
+				}}
+
+				Expect(runner.Run()).To(Succeed())
+				Expect(runner.WritePID()).To(Succeed())
+				Expect(runner.Wait()).To(Succeed())
+
+				Expect(getFakeAgentOutput(runner).Core).To(Equal(uint64(1024)))
+
+				var after syscall.Rlimit
+				Expect(syscall.Getrlimit(syscall.RLIMIT_CORE, &after)).To(Succeed())
+				Expect(after).To(Equal(before))
+			})
+
+			It("lowers a limit above the hard limit to it and leaves the hard limit alone", func() {
+				var before syscall.Rlimit
+				Expect(syscall.Getrlimit(syscall.RLIMIT_NOFILE, &before)).To(Succeed())
+				if before.Max == ^uint64(0) {
+					Skip("the open files hard limit is unlimited")
+				}
+
+				runner.Rlimits = []agent.Rlimit{{
+					Name:     "nofile",
+					Resource: syscall.RLIMIT_NOFILE,
+					Cur:      before.Max + 1,
+				}}
+
+				Expect(runner.Run()).To(Succeed())
+				Expect(runner.WritePID()).To(Succeed())
+				Expect(runner.Wait()).To(Succeed())
+
+				Expect(getFakeAgentOutput(runner).NoFile).To(Equal(before.Max))
+				Expect(logger.Messages).To(ContainElement(fakes.LoggerMessage{
+					Action: "agent-runner.run.set-rlimits.above-hard-limit",
+					Data: []lager.Data{{
+						"name":       "nofile",
+						"limit":      before.Max + 1,
+						"hard_limit": before.Max,
+					}},
+				}))
+
+				var after syscall.Rlimit
+				Expect(syscall.Getrlimit(syscall.RLIMIT_NOFILE, &after)).To(Succeed())
+				Expect(after).To(Equal(before))
+			})
+
+			It("runs the agent as confab's own user without changing credentials", func() {
+				currentUser, err := user.Current()
+				Expect(err).NotTo(HaveOccurred())
+
+				runner.User = currentUser.Username
+
+				Expect(runner.Run()).To(Succeed())
+				Expect(runner.WritePID()).To(Succeed())
+				Expect(runner.Wait()).To(Succeed())
+
+				output := getFakeAgentOutput(runner)
+				Expect(strconv.Itoa(output.UID)).To(Equal(currentUser.Uid))
+				Expect(strconv.Itoa(output.GID)).To(Equal(currentUser.Gid))
+			})
+
+			It("returns an error when the user does not exist", func() {
+				runner.User = "no-such-user"
+
+				Expect(runner.Run()).To(MatchError("user: unknown user no-such-user"))
+				Expect(logger.Messages).To(ContainElement(fakes.LoggerMessage{
+					Action: "agent-runner.run.lookup-user.failed",
+					Error:  user.UnknownUserError("no-such-user"),
+					Data: []lager.Data{{
+						"user":  "no-such-user",
+						"group": "",
+					}},
+				}))
+				Expect(getFakeAgentOutput(runner)).To(Equal(FakeAgentOutput{}))
+			})
+		})
+
 		Context("when the pid file already exists", func() {
-			Context("when the pid file points at the pid of a currently running process", func() {
+			Context("when the pid file points at a running agent", func() {
+				It("errors without running the command", func() {
+					Expect(ioutil.WriteFile(filepath.Join(runner.ConfigDir, "options.json"), []byte(`{ "WaitForHUP": true }`), 0600)).To(Succeed())
+					Expect(runner.Run()).To(Succeed())
+					Expect(runner.WritePID()).To(Succeed())
+					defer runner.Stop()
+
+					pid, err := getPID(runner)
+					Expect(err).NotTo(HaveOccurred())
+
+					secondRunner := agent.Runner{
+						Path:      runner.Path,
+						ConfigDir: runner.ConfigDir,
+						PIDFile:   runner.PIDFile,
+						Logger:    logger,
+					}
+
+					Expect(secondRunner.Run()).To(MatchError("consul_agent is already running, please stop it first"))
+					Expect(logger.Messages).To(ContainElement(fakes.LoggerMessage{
+						Action: "agent-runner.run.consul-already-running",
+						Error:  errors.New("consul_agent is already running, please stop it first"),
+						Data: []lager.Data{{
+							"pid": pid,
+						}},
+					}))
+				})
+			})
+
+			Context("when the pid file points at an agent from another package version", func() {
 				It("errors without running the command", func() {
+					Expect(ioutil.WriteFile(filepath.Join(runner.ConfigDir, "options.json"), []byte(`{ "WaitForHUP": true }`), 0600)).To(Succeed())
+					Expect(runner.Run()).To(Succeed())
+					Expect(runner.WritePID()).To(Succeed())
+					defer runner.Stop()
+
+					binary, err := ioutil.ReadFile(runner.Path)
+					Expect(err).NotTo(HaveOccurred())
+
+					newPackageDir := filepath.Join(runner.ConfigDir, "new-package")
+					Expect(os.Mkdir(newPackageDir, 0700)).To(Succeed())
+
+					newPath := filepath.Join(newPackageDir, filepath.Base(runner.Path))
+					Expect(ioutil.WriteFile(newPath, binary, 0700)).To(Succeed())
+
+					secondRunner := agent.Runner{
+						Path:      newPath,
+						ConfigDir: runner.ConfigDir,
+						PIDFile:   runner.PIDFile,
+						Logger:    logger,
+					}
+
+					Expect(secondRunner.Run()).To(MatchError("consul_agent is already running, please stop it first"))
+				})
+			})
+
+			Context("when the pid file points at a process that is not the agent", func() {
+				It("removes the stale pid file and runs the command", func() {
 					myPID := os.Getpid()
 					Expect(ioutil.WriteFile(runner.PIDFile, []byte(fmt.Sprintf("%d", myPID)), 0666)).To(Succeed())
 
-					Expect(runner.Run()).To(MatchError("consul_agent is already running, please stop it first"))
-					Expect(getFakeAgentOutput(runner).PID).To(Equal(0))
-					Expect(logger.Messages).To(ContainSequence([]fakes.LoggerMessage{
-						{
-							Action: "agent-runner.run.consul-already-running",
-							Error:  errors.New("consul_agent is already running, please stop it first"),
-						},
+					Expect(runner.Run()).To(Succeed())
+					Expect(runner.WritePID()).To(Succeed())
+					Expect(runner.Wait()).To(Succeed())
+
+					executable, err := os.Readlink("/proc/self/exe")
+					Expect(err).NotTo(HaveOccurred())
+
+					Expect(logger.Messages).To(ContainElement(fakes.LoggerMessage{
+						Action: "agent-runner.run.remove-stale-pidfile",
+						Data: []lager.Data{{
+							"path":   runner.PIDFile,
+							"reason": fmt.Sprintf("pid %d is %s, not %s", myPID, executable, runner.Path),
+						}},
 					}))
 				})
 			})
@@ -424,6 +721,29 @@ var _ = Describe("Runner", func() {
 
 					Expect(runner.Run()).To(Succeed())
 					Expect(runner.WritePID()).To(Succeed())
+					Expect(logger.Messages).To(ContainElement(fakes.LoggerMessage{
+						Action: "agent-runner.run.remove-stale-pidfile",
+						Data: []lager.Data{{
+							"path":   runner.PIDFile,
+							"reason": "not running",
+						}},
+					}))
+				})
+			})
+
+			Context("when the pid file contains nonsense", func() {
+				It("removes the stale pid file and runs the command", func() {
+					Expect(ioutil.WriteFile(runner.PIDFile, []byte("nonsense"), 0666)).To(Succeed())
+
+					Expect(runner.Run()).To(Succeed())
+					Expect(logger.Messages).To(ContainElement(fakes.LoggerMessage{
+						Action: "agent-runner.run.remove-stale-pidfile",
+						Data: []lager.Data{{
+							"path":   runner.PIDFile,
+							"reason": `invalid pid "nonsense"`,
+						}},
+					}))
+					Expect(runner.Wait()).To(Succeed())
 				})
 			})
 		})
@@ -480,8 +800,15 @@ var _ = Describe("Runner", func() {
 })
 
 type FakeAgentOutput struct {
-	Args []string
-	PID  int
+	Args       []string
+	PID        int
+	Dir        string
+	GOMAXPROCS string
+	Env        []string
+	UID        int
+	GID        int
+	Core       uint64
+	NoFile     uint64
 }
 
 func getFakeAgentOutput(runner agent.Runner) FakeAgentOutput {
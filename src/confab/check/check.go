@@ -0,0 +1,112 @@
+package check
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+type Status int
+
+const (
+	Passing  Status = 0
+	Warning  Status = 1
+	Critical Status = 2
+)
+
+func (s Status) String() string {
+	switch s {
+	case Passing:
+		return "passing"
+	case Warning:
+		return "warning"
+	default:
+		return "critical"
+	}
+}
+
+type Result struct {
+	Status  Status
+	Message string
+}
+
+func passing(format string, args ...interface{}) Result {
+	return Result{Status: Passing, Message: fmt.Sprintf(format, args...)}
+}
+
+func warning(format string, args ...interface{}) Result {
+	return Result{Status: Warning, Message: fmt.Sprintf(format, args...)}
+}
+
+func critical(format string, args ...interface{}) Result {
+	return Result{Status: Critical, Message: fmt.Sprintf(format, args...)}
+}
+
+func HTTP(url string, expectStatus int, timeout time.Duration) Result {
+	client := &http.Client{Timeout: timeout}
+
+	response, err := client.Get(url)
+	if err != nil {
+		return critical("GET %s failed: %s", url, err)
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode != expectStatus {
+		return critical("GET %s returned %d, expected %d", url, response.StatusCode, expectStatus)
+	}
+
+	return passing("GET %s returned %d", url, response.StatusCode)
+}
+
+func TCP(addr string, timeout time.Duration) Result {
+	conn, err := net.DialTimeout("tcp", addr, timeout)
+	if err != nil {
+		return critical("connecting to %s failed: %s", addr, err)
+	}
+	conn.Close()
+
+	return passing("connected to %s", addr)
+}
+
+func Process(pidFile string) Result {
+	pidFileContents, err := ioutil.ReadFile(pidFile)
+	if err != nil {
+		return critical("reading pid file failed: %s", err)
+	}
+
+	pid, err := strconv.Atoi(strings.TrimSpace(string(pidFileContents)))
+	if err != nil {
+		return critical("pid file %s does not contain a pid: %s", pidFile, err)
+	}
+
+	// EPERM means the process exists but belongs to another user, e.g. the
+	// agent running as vcap
+	if err := syscall.Kill(pid, syscall.Signal(0)); err != nil && err != syscall.EPERM {
+		return critical("process %d is not running: %s", pid, err)
+	}
+
+	return passing("process %d is running", pid)
+}
+
+func FileAge(path string, warnAge, criticalAge time.Duration, now time.Time) Result {
+	info, err := os.Stat(path)
+	if err != nil {
+		return critical("stat %s failed: %s", path, err)
+	}
+
+	age := now.Sub(info.ModTime())
+	switch {
+	case criticalAge > 0 && age > criticalAge:
+		return critical("%s was last modified %s ago, critical after %s", path, age, criticalAge)
+	case warnAge > 0 && age > warnAge:
+		return warning("%s was last modified %s ago, warning after %s", path, age, warnAge)
+	}
+
+	return passing("%s was last modified %s ago", path, age)
+}
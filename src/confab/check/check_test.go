@@ -0,0 +1,158 @@
+package check_test
+
+import (
+	"confab/check"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("check", func() {
+	Describe("HTTP", func() {
+		var server *httptest.Server
+
+		BeforeEach(func() {
+			server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+				if req.URL.Path == "/healthy" {
+					w.WriteHeader(http.StatusOK)
+					return
+				}
+
+				w.WriteHeader(http.StatusServiceUnavailable)
+			}))
+		})
+
+		AfterEach(func() {
+			server.Close()
+		})
+
+		It("passes when the endpoint returns the expected status", func() {
+			result := check.HTTP(server.URL+"/healthy", http.StatusOK, time.Second)
+			Expect(result.Status).To(Equal(check.Passing))
+			Expect(result.Message).To(Equal(fmt.Sprintf("GET %s/healthy returned 200", server.URL)))
+		})
+
+		It("is critical when the endpoint returns a different status", func() {
+			result := check.HTTP(server.URL+"/unhealthy", http.StatusOK, time.Second)
+			Expect(result.Status).To(Equal(check.Critical))
+			Expect(result.Message).To(Equal(fmt.Sprintf("GET %s/unhealthy returned 503, expected 200", server.URL)))
+		})
+
+		It("is critical when the endpoint cannot be reached", func() {
+			url := server.URL
+			server.Close()
+
+			result := check.HTTP(url, http.StatusOK, time.Second)
+			Expect(result.Status).To(Equal(check.Critical))
+			Expect(result.Message).To(ContainSubstring("failed"))
+		})
+	})
+
+	Describe("TCP", func() {
+		It("passes when a connection can be made", func() {
+			listener, err := net.Listen("tcp", "127.0.0.1:0")
+			Expect(err).NotTo(HaveOccurred())
+			defer listener.Close()
+
+			result := check.TCP(listener.Addr().String(), time.Second)
+			Expect(result.Status).To(Equal(check.Passing))
+		})
+
+		It("is critical when nothing is listening", func() {
+			listener, err := net.Listen("tcp", "127.0.0.1:0")
+			Expect(err).NotTo(HaveOccurred())
+			addr := listener.Addr().String()
+			listener.Close()
+
+			result := check.TCP(addr, time.Second)
+			Expect(result.Status).To(Equal(check.Critical))
+			Expect(result.Message).To(ContainSubstring(fmt.Sprintf("connecting to %s failed", addr)))
+		})
+	})
+
+	Describe("Process", func() {
+		var pidFile string
+
+		BeforeEach(func() {
+			dir, err := ioutil.TempDir("", "")
+			Expect(err).NotTo(HaveOccurred())
+			pidFile = filepath.Join(dir, "some.pid")
+		})
+
+		It("passes when the process in the pid file is running", func() {
+			Expect(ioutil.WriteFile(pidFile, []byte(fmt.Sprintf("%d\n", os.Getpid())), 0644)).To(Succeed())
+
+			result := check.Process(pidFile)
+			Expect(result.Status).To(Equal(check.Passing))
+			Expect(result.Message).To(Equal(fmt.Sprintf("process %d is running", os.Getpid())))
+		})
+
+		It("passes when the process belongs to another user", func() {
+			// init is owned by root, so signalling it fails with EPERM unless
+			// the tests run as root
+			Expect(ioutil.WriteFile(pidFile, []byte("1\n"), 0644)).To(Succeed())
+
+			result := check.Process(pidFile)
+			Expect(result.Status).To(Equal(check.Passing))
+			Expect(result.Message).To(Equal("process 1 is running"))
+		})
+
+		It("is critical when the pid file does not exist", func() {
+			result := check.Process(pidFile)
+			Expect(result.Status).To(Equal(check.Critical))
+			Expect(result.Message).To(ContainSubstring("reading pid file failed"))
+		})
+
+		It("is critical when the pid file contains nonsense", func() {
+			Expect(ioutil.WriteFile(pidFile, []byte("nonsense"), 0644)).To(Succeed())
+
+			result := check.Process(pidFile)
+			Expect(result.Status).To(Equal(check.Critical))
+			Expect(result.Message).To(ContainSubstring("does not contain a pid"))
+		})
+	})
+
+	Describe("FileAge", func() {
+		var (
+			path string
+			now  time.Time
+		)
+
+		BeforeEach(func() {
+			file, err := ioutil.TempFile("", "")
+			Expect(err).NotTo(HaveOccurred())
+			file.Close()
+			path = file.Name()
+
+			now = time.Now()
+			Expect(os.Chtimes(path, now, now.Add(-2*time.Minute))).To(Succeed())
+		})
+
+		It("passes when the file is younger than the thresholds", func() {
+			Expect(check.FileAge(path, 5*time.Minute, 10*time.Minute, now).Status).To(Equal(check.Passing))
+		})
+
+		It("warns when the file is older than the warning threshold", func() {
+			Expect(check.FileAge(path, time.Minute, 10*time.Minute, now).Status).To(Equal(check.Warning))
+		})
+
+		It("is critical when the file is older than the critical threshold", func() {
+			result := check.FileAge(path, 30*time.Second, time.Minute, now)
+			Expect(result.Status).To(Equal(check.Critical))
+			Expect(result.Message).To(ContainSubstring("critical after 1m0s"))
+		})
+
+		It("is critical when the file does not exist", func() {
+			Expect(os.Remove(path)).To(Succeed())
+			Expect(check.FileAge(path, time.Minute, time.Minute, now).Status).To(Equal(check.Critical))
+		})
+	})
+})
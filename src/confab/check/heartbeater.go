@@ -0,0 +1,72 @@
+package check
+
+import (
+	"time"
+
+	"github.com/pivotal-golang/lager"
+)
+
+type logger interface {
+	Info(action string, data ...lager.Data)
+	Error(action string, err error, data ...lager.Data)
+}
+
+type ttlUpdater interface {
+	PassTTL(checkID, note string) error
+	WarnTTL(checkID, note string) error
+	FailTTL(checkID, note string) error
+}
+
+type Heartbeater struct {
+	CheckID string
+	Probe   func() Result
+	Agent   ttlUpdater
+	Logger  logger
+}
+
+func (h Heartbeater) Run(ticks <-chan time.Time, stop <-chan struct{}) error {
+	for {
+		h.beat()
+
+		select {
+		case <-ticks:
+		case <-stop:
+			h.Logger.Info("heartbeater.run.stop", lager.Data{
+				"check": h.CheckID,
+			})
+			if err := h.Agent.FailTTL(h.CheckID, "confab heartbeat stopped"); err != nil {
+				h.Logger.Error("heartbeater.run.stop.failed", err, lager.Data{
+					"check": h.CheckID,
+				})
+				return err
+			}
+
+			return nil
+		}
+	}
+}
+
+func (h Heartbeater) beat() {
+	result := h.Probe()
+
+	update := h.Agent.FailTTL
+	switch result.Status {
+	case Passing:
+		update = h.Agent.PassTTL
+	case Warning:
+		update = h.Agent.WarnTTL
+	}
+
+	if err := update(h.CheckID, result.Message); err != nil {
+		h.Logger.Error("heartbeater.beat.update-ttl.failed", err, lager.Data{
+			"check":  h.CheckID,
+			"status": result.Status.String(),
+		})
+		return
+	}
+
+	h.Logger.Info("heartbeater.beat.update-ttl", lager.Data{
+		"check":  h.CheckID,
+		"status": result.Status.String(),
+	})
+}
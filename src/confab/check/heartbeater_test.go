@@ -0,0 +1,144 @@
+package check_test
+
+import (
+	"confab/check"
+	"confab/fakes"
+	"errors"
+	"time"
+
+	"github.com/pivotal-golang/lager"
+
+	. "github.com/pivotal-cf-experimental/gomegamatchers"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Heartbeater", func() {
+	var (
+		agent       *fakes.FakettlUpdater
+		logger      *fakes.Logger
+		results     []check.Result
+		heartbeater check.Heartbeater
+		ticks       chan time.Time
+		stop        chan struct{}
+	)
+
+	BeforeEach(func() {
+		agent = &fakes.FakettlUpdater{}
+		logger = &fakes.Logger{}
+		results = []check.Result{
+			{Status: check.Passing, Message: "all good"},
+			{Status: check.Warning, Message: "slow"},
+			{Status: check.Critical, Message: "down"},
+		}
+
+		probeCount := 0
+		heartbeater = check.Heartbeater{
+			CheckID: "service:router",
+			Probe: func() check.Result {
+				result := results[probeCount%len(results)]
+				probeCount++
+				return result
+			},
+			Agent:  agent,
+			Logger: logger,
+		}
+
+		ticks = make(chan time.Time)
+		stop = make(chan struct{})
+	})
+
+	It("reports the probe result on every tick and fails the check when stopped", func() {
+		done := make(chan error)
+		go func() {
+			done <- heartbeater.Run(ticks, stop)
+		}()
+
+		ticks <- time.Now()
+		ticks <- time.Now()
+		close(stop)
+		Eventually(done).Should(Receive(BeNil()))
+
+		Expect(agent.PassTTLCallCount()).To(Equal(1))
+		checkID, note := agent.PassTTLArgsForCall(0)
+		Expect(checkID).To(Equal("service:router"))
+		Expect(note).To(Equal("all good"))
+
+		Expect(agent.WarnTTLCallCount()).To(Equal(1))
+		_, note = agent.WarnTTLArgsForCall(0)
+		Expect(note).To(Equal("slow"))
+
+		Expect(agent.FailTTLCallCount()).To(Equal(2))
+		_, note = agent.FailTTLArgsForCall(0)
+		Expect(note).To(Equal("down"))
+		_, note = agent.FailTTLArgsForCall(1)
+		Expect(note).To(Equal("confab heartbeat stopped"))
+
+		Expect(logger.Messages).To(ContainSequence([]fakes.LoggerMessage{
+			{
+				Action: "heartbeater.beat.update-ttl",
+				Data: []lager.Data{{
+					"check":  "service:router",
+					"status": "passing",
+				}},
+			},
+			{
+				Action: "heartbeater.beat.update-ttl",
+				Data: []lager.Data{{
+					"check":  "service:router",
+					"status": "warning",
+				}},
+			},
+			{
+				Action: "heartbeater.beat.update-ttl",
+				Data: []lager.Data{{
+					"check":  "service:router",
+					"status": "critical",
+				}},
+			},
+			{
+				Action: "heartbeater.run.stop",
+				Data: []lager.Data{{
+					"check": "service:router",
+				}},
+			},
+		}))
+	})
+
+	Context("when updating the check fails", func() {
+		It("logs the error and keeps beating", func() {
+			agent.PassTTLReturns(errors.New("agent unavailable"))
+
+			done := make(chan error)
+			go func() {
+				done <- heartbeater.Run(ticks, stop)
+			}()
+
+			ticks <- time.Now()
+			close(stop)
+			Eventually(done).Should(Receive(BeNil()))
+
+			Expect(agent.WarnTTLCallCount()).To(Equal(1))
+			Expect(logger.Messages).To(ContainSequence([]fakes.LoggerMessage{
+				{
+					Action: "heartbeater.beat.update-ttl.failed",
+					Error:  errors.New("agent unavailable"),
+					Data: []lager.Data{{
+						"check":  "service:router",
+						"status": "passing",
+					}},
+				},
+			}))
+		})
+	})
+
+	Context("when the final update fails", func() {
+		It("returns the error", func() {
+			agent.FailTTLReturns(errors.New("agent unavailable"))
+			close(stop)
+
+			Expect(heartbeater.Run(ticks, stop)).To(MatchError("agent unavailable"))
+		})
+	})
+})
@@ -0,0 +1,179 @@
+package main
+
+import (
+	"confab"
+	"confab/check"
+	"errors"
+	"flag"
+	"fmt"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/hashicorp/consul/api"
+	"github.com/pivotal-golang/lager"
+)
+
+func runCheck(args []string) int {
+	probe, err := parseProbe(args)
+	if err != nil {
+		return int(check.Critical)
+	}
+
+	result := probe()
+	fmt.Fprintf(os.Stdout, "%s: %s\n", result.Status, result.Message)
+	return int(result.Status)
+}
+
+func runHeartbeat(args []string) int {
+	var (
+		service             string
+		checkID             string
+		interval            time.Duration
+		heartbeatConfigFile string
+	)
+
+	flagSet := flag.NewFlagSet("heartbeat", flag.ContinueOnError)
+	flagSet.StringVar(&service, "service", "", "specifies the `id` of the service whose TTL check is updated")
+	flagSet.StringVar(&checkID, "check-id", "", "specifies the `id` of the TTL check, defaults to \"service:<service>\"")
+	flagSet.DurationVar(&interval, "interval", 10*time.Second, "specifies how often the probe is evaluated")
+	flagSet.StringVar(&heartbeatConfigFile, "config-file", "", "specifies the config `file` the agent address is read from")
+
+	if err := flagSet.Parse(args); err != nil {
+		return 1
+	}
+
+	if service == "" && checkID == "" {
+		stderr.Printf("usage: confab heartbeat --service ID [--check-id ID] [--interval DURATION] [--config-file FILE] MODE OPTIONS\n\n")
+		stderr.Println("MODE: \"http\", \"tcp\", \"process\" or \"file-age\"")
+		return 1
+	}
+
+	if checkID == "" {
+		checkID = fmt.Sprintf("service:%s", service)
+	}
+
+	probe, err := parseProbe(flagSet.Args())
+	if err != nil {
+		return 1
+	}
+
+	config := confab.DefaultConfig()
+	if heartbeatConfigFile != "" {
+		config, err = readConfig(heartbeatConfigFile)
+		if err != nil {
+			stderr.Printf("error reading configuration file: %s", err)
+			return 1
+		}
+	}
+
+	consulAPIClient, err := api.NewClient(agentAPIConfig(config))
+	if err != nil {
+		panic(err) // not tested, NewClient never errors
+	}
+
+	logger := lager.NewLogger("confab")
+	logger.RegisterSink(lager.NewWriterSink(os.Stdout, lager.INFO))
+
+	heartbeater := check.Heartbeater{
+		CheckID: checkID,
+		Probe:   probe,
+		Agent:   consulAPIClient.Agent(),
+		Logger:  logger,
+	}
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+
+	stop := make(chan struct{})
+	go func() {
+		<-signals
+		close(stop)
+	}()
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	if err := heartbeater.Run(ticker.C, stop); err != nil {
+		stderr.Printf("error stopping heartbeat: %s", err)
+		return 1
+	}
+
+	return 0
+}
+
+func parseProbe(args []string) (func() check.Result, error) {
+	if len(args) < 1 {
+		stderr.Printf("usage: confab check MODE OPTIONS\n\n")
+		stderr.Println("MODE: \"http\", \"tcp\", \"process\" or \"file-age\"")
+		return nil, errors.New("missing check mode")
+	}
+
+	mode := args[0]
+	flagSet := flag.NewFlagSet(mode, flag.ContinueOnError)
+
+	var (
+		url          string
+		expectStatus int
+		addr         string
+		pidFile      string
+		path         string
+		warnAge      time.Duration
+		criticalAge  time.Duration
+		timeout      time.Duration
+	)
+	flagSet.DurationVar(&timeout, "timeout", 5*time.Second, "specifies how long to wait for a response")
+
+	switch mode {
+	case "http":
+		flagSet.StringVar(&url, "url", "", "specifies the `url` to GET")
+		flagSet.IntVar(&expectStatus, "expect-status", http.StatusOK, "specifies the expected HTTP `status` code")
+	case "tcp":
+		flagSet.StringVar(&addr, "addr", "", "specifies the `host:port` to connect to")
+	case "process":
+		flagSet.StringVar(&pidFile, "pidfile", "", "specifies the pid `file` of the process")
+	case "file-age":
+		flagSet.StringVar(&path, "path", "", "specifies the `file` whose modification time is checked")
+		flagSet.DurationVar(&warnAge, "warn", 0, "specifies the age after which the check warns")
+		flagSet.DurationVar(&criticalAge, "critical", 0, "specifies the age after which the check is critical")
+	default:
+		err := fmt.Errorf("invalid check MODE %q", mode)
+		stderr.Print(err)
+		return nil, err
+	}
+
+	if err := flagSet.Parse(args[1:]); err != nil {
+		return nil, err
+	}
+
+	var required, value string
+	switch mode {
+	case "http":
+		required, value = "url", url
+	case "tcp":
+		required, value = "addr", addr
+	case "process":
+		required, value = "pidfile", pidFile
+	case "file-age":
+		required, value = "path", path
+	}
+
+	if value == "" {
+		stderr.Printf("usage: confab check %s OPTIONS\n\n", mode)
+		flagSet.PrintDefaults()
+		return nil, fmt.Errorf("missing required flag --%s", required)
+	}
+
+	switch mode {
+	case "http":
+		return func() check.Result { return check.HTTP(url, expectStatus, timeout) }, nil
+	case "tcp":
+		return func() check.Result { return check.TCP(addr, timeout) }, nil
+	case "process":
+		return func() check.Result { return check.Process(pidFile) }, nil
+	default:
+		return func() check.Result { return check.FileAge(path, warnAge, criticalAge, time.Now()) }, nil
+	}
+}
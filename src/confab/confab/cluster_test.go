@@ -0,0 +1,176 @@
+package main_test
+
+import (
+	"confab/fakes/cluster"
+	"errors"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("confab against a fake cluster", func() {
+	const (
+		oldKey = "AgICAgICAgICAgICAgICAg=="
+		key1   = "AAAAAAAAAAAAAAAAAAAAAA=="
+		key2   = "AQEBAQEBAQEBAQEBAQEBAQ=="
+	)
+
+	var (
+		tempDir         string
+		consulConfigDir string
+		pidFile         string
+		configFile      string
+		c               *cluster.Cluster
+	)
+
+	// startCluster runs the local agent on the ports confab talks to, next to
+	// three servers that have not joined yet
+	startCluster := func(local cluster.AgentConfig) {
+		local.HTTPAddr = "127.0.0.1:8500"
+		local.RPCAddr = "127.0.0.1:8400"
+
+		agents := []cluster.AgentConfig{local}
+		for _, server := range []cluster.AgentConfig{
+			{Name: "consul-z1-0", Addr: "10.0.0.1", Server: true},
+			{Name: "consul-z1-1", Addr: "10.0.0.2", Server: true},
+			{Name: "consul-z2-0", Addr: "10.0.0.3", Server: true},
+		} {
+			if server.Name != local.Name {
+				agents = append(agents, server)
+			}
+		}
+
+		for _, agent := range agents {
+			_, err := c.Start(agent)
+			Expect(err).NotTo(HaveOccurred())
+		}
+	}
+
+	joinAll := func(c *cluster.Cluster) {
+		for _, member := range []string{"consul-z1-0", "consul-z1-1", "consul-z2-0", "cell-z1-0"} {
+			c.Join(member)
+		}
+		c.SetLeader("consul-z1-1")
+	}
+
+	writeConfig := func(mode string, node string, keys []string) {
+		writeConfigurationFile(configFile, map[string]interface{}{
+			"node": map[string]interface{}{
+				"name":  node,
+				"index": 0,
+			},
+			"path": map[string]interface{}{
+				"agent_path":        pathToFakeProcess,
+				"consul_config_dir": consulConfigDir,
+				"pid_file":          pidFile,
+			},
+			"consul": map[string]interface{}{
+				"require_ssl":  len(keys) > 0,
+				"encrypt_keys": keys,
+				"agent": map[string]interface{}{
+					"mode": mode,
+					"servers": map[string]interface{}{
+						"lan": []string{"10.0.0.1", "10.0.0.2", "10.0.0.3"},
+					},
+				},
+			},
+			"confab": map[string]interface{}{
+				"timeout_in_seconds": 5,
+			},
+		})
+	}
+
+	BeforeEach(func() {
+		var err error
+		tempDir, err = ioutil.TempDir("", "testing")
+		Expect(err).NotTo(HaveOccurred())
+
+		consulConfigDir, err = ioutil.TempDir(tempDir, "fake-agent-config-dir")
+		Expect(err).NotTo(HaveOccurred())
+
+		pidFile = filepath.Join(tempDir, "fake-pid-file")
+		configFile = filepath.Join(tempDir, "config-file")
+
+		options := []byte(`{"WaitForHUP": true}`)
+		Expect(ioutil.WriteFile(filepath.Join(consulConfigDir, "options.json"), options, 0600)).To(Succeed())
+
+		c = cluster.New()
+	})
+
+	AfterEach(func() {
+		killProcessWithPIDFile(pidFile)
+		Expect(c.Close()).To(Succeed())
+		Expect(os.RemoveAll(tempDir)).To(Succeed())
+	})
+
+	Context("when booting a client", func() {
+		BeforeEach(func() {
+			startCluster(cluster.AgentConfig{Name: "cell-z1-0", Addr: "10.0.0.4"})
+			writeConfig("client", "cell_z1", nil)
+		})
+
+		It("waits for the servers to join before writing the pid file", func() {
+			c.After(2*time.Second, joinAll)
+
+			start := time.Now()
+			cmd := exec.Command(pathToConfab, "start", "--config-file", configFile)
+			Eventually(cmd.Run, COMMAND_TIMEOUT, COMMAND_TIMEOUT).Should(Succeed())
+			Expect(time.Since(start)).To(BeNumerically(">=", 2*time.Second))
+
+			Expect(pidIsForRunningProcess(pidFile)).To(BeTrue())
+		})
+
+		It("fails when the members can never be listed", func() {
+			joinAll(c)
+			c.InjectFault("cell-z1-0", cluster.FaultMembers, errors.New("serf is down"))
+
+			cmd := exec.Command(pathToConfab, "start", "--config-file", configFile)
+			Eventually(cmd.Run, COMMAND_TIMEOUT, COMMAND_TIMEOUT).ShouldNot(Succeed())
+
+			_, err := os.Stat(pidFile)
+			Expect(os.IsNotExist(err)).To(BeTrue())
+		})
+	})
+
+	Context("when booting a server", func() {
+		BeforeEach(func() {
+			startCluster(cluster.AgentConfig{Name: "consul-z1-0", Addr: "10.0.0.1", Server: true})
+			writeConfig("server", "consul_z1", []string{key2, key1})
+		})
+
+		It("rotates the keyring to the first encrypt key", func() {
+			joinAll(c)
+			c.InstallKey(key1)
+			c.InstallKey(oldKey)
+
+			cmd := exec.Command(pathToConfab, "start", "--config-file", configFile)
+			Eventually(cmd.Run, COMMAND_TIMEOUT, COMMAND_TIMEOUT).Should(Succeed())
+
+			Expect(c.PrimaryKey()).To(Equal(key2))
+			Expect(c.Keys()).To(Equal(map[string]int{
+				key1: 3,
+				key2: 3,
+			}))
+		})
+
+		It("waits for the raft log to catch up when it is the last server to roll", func() {
+			joinAll(c)
+			c.SetRaftIndexes("consul-z1-0", 5, 10)
+			c.After(2*time.Second, func(c *cluster.Cluster) {
+				c.SetRaftIndexes("consul-z1-0", 10, 10)
+			})
+
+			start := time.Now()
+			cmd := exec.Command(pathToConfab, "start", "--config-file", configFile)
+			Eventually(cmd.Run, COMMAND_TIMEOUT, COMMAND_TIMEOUT).Should(Succeed())
+			Expect(time.Since(start)).To(BeNumerically(">=", 2*time.Second))
+
+			Expect(pidIsForRunningProcess(pidFile)).To(BeTrue())
+		})
+	})
+})
@@ -401,6 +401,28 @@ var _ = Describe("confab", func() {
 				}))
 			})
 
+			It("rotates the keys in order", func() {
+				scenario := []byte(`{"list_keys": [{"keys": ["old-key"]}]}`)
+				Expect(ioutil.WriteFile(filepath.Join(consulConfigDir, "scenario.json"), scenario, 0600)).To(Succeed())
+
+				cmd := exec.Command(pathToConfab,
+					"start",
+					"--config-file", configFile.Name(),
+				)
+				Eventually(cmd.Run, COMMAND_TIMEOUT, COMMAND_TIMEOUT).Should(Succeed())
+
+				calls, err := fakeAgentCalls(consulConfigDir)
+				Expect(err).NotTo(HaveOccurred())
+
+				var names []string
+				for _, call := range calls {
+					names = append(names, call.Name)
+				}
+				Expect(names).To(Equal([]string{"stats", "listkeys", "removekey", "installkey", "installkey", "usekey"}))
+				Expect(calls[2].Args).To(Equal([]string{"old-key"}))
+				Expect(calls[5].Args).To(Equal(calls[3].Args))
+			})
+
 			It("checks sync state up to the timeout", func() {
 				writeConfigurationFile(configFile.Name(), map[string]interface{}{
 					"path": map[string]interface{}{
@@ -508,6 +530,139 @@ var _ = Describe("confab", func() {
 		})
 	})
 
+	Context("when draining before stopping", func() {
+		BeforeEach(func() {
+			writeConfigurationFile(configFile.Name(), map[string]interface{}{
+				"path": map[string]interface{}{
+					"agent_path":        pathToFakeAgent,
+					"consul_config_dir": consulConfigDir,
+					"pid_file":          pidFile.Name(),
+				},
+				"consul": map[string]interface{}{
+					"agent": map[string]interface{}{
+						"drain_time_in_seconds": 1,
+						"servers": map[string]interface{}{
+							"lan": []string{"member-1", "member-2", "member-3"},
+						},
+					},
+				},
+			})
+		})
+
+		AfterEach(func() {
+			killProcessWithPIDFile(pidFile.Name())
+		})
+
+		It("takes the node out of maintenance when it starts again", func() {
+			start := exec.Command(pathToConfab, "start", "--config-file", configFile.Name())
+			Eventually(start.Run, COMMAND_TIMEOUT, COMMAND_TIMEOUT).Should(Succeed())
+
+			stop := exec.Command(pathToConfab, "stop", "--config-file", configFile.Name())
+			Eventually(stop.Run, COMMAND_TIMEOUT, COMMAND_TIMEOUT).Should(Succeed())
+
+			Eventually(func() bool {
+				return pidIsForRunningProcess(pidFile.Name())
+			}, "5s").Should(BeFalse())
+
+			reason, err := ioutil.ReadFile(filepath.Join(consulConfigDir, "fake-maintenance"))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(string(reason)).To(Equal("confab is draining the node before stopping the agent"))
+
+			start = exec.Command(pathToConfab, "start", "--config-file", configFile.Name())
+			Eventually(start.Run, COMMAND_TIMEOUT, COMMAND_TIMEOUT).Should(Succeed())
+
+			calls, err := fakeAgentCalls(consulConfigDir)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(calls).To(ContainElement(fakeAgentCall{
+				Name: "disablemaintenance",
+				Args: []string{},
+			}))
+
+			_, err = os.Stat(filepath.Join(consulConfigDir, "fake-maintenance"))
+			Expect(os.IsNotExist(err)).To(BeTrue())
+		})
+	})
+
+	Context("when reporting status", func() {
+		BeforeEach(func() {
+			writeConfigurationFile(configFile.Name(), map[string]interface{}{
+				"path": map[string]interface{}{
+					"agent_path":        pathToFakeAgent,
+					"consul_config_dir": consulConfigDir,
+					"pid_file":          pidFile.Name(),
+				},
+				"consul": map[string]interface{}{
+					"agent": map[string]interface{}{
+						"servers": map[string]interface{}{
+							"lan": []string{"member-1", "member-2", "member-3"},
+						},
+					},
+				},
+			})
+		})
+
+		It("prints the members and their versions", func() {
+			cmd := exec.Command(pathToConfab,
+				"start",
+				"--config-file", configFile.Name(),
+			)
+			Eventually(cmd.Run, COMMAND_TIMEOUT, COMMAND_TIMEOUT).Should(Succeed())
+
+			cmd = exec.Command(pathToConfab, "status")
+			buffer := bytes.NewBuffer([]byte{})
+			cmd.Stdout = buffer
+			Eventually(cmd.Run, COMMAND_TIMEOUT, COMMAND_TIMEOUT).Should(Succeed())
+			Expect(buffer).To(ContainSubstring("member-1:0"))
+			Expect(buffer).To(ContainSubstring("member-3:0"))
+			Expect(buffer).To(ContainSubstring("builds: unknown (3 members)"))
+		})
+	})
+
+	Context("when checking", func() {
+		It("exits 0 when the check passes", func() {
+			listener, err := net.Listen("tcp", "127.0.0.1:0")
+			Expect(err).NotTo(HaveOccurred())
+			defer listener.Close()
+
+			cmd := exec.Command(pathToConfab, "check", "tcp", "--addr", listener.Addr().String())
+			buffer := bytes.NewBuffer([]byte{})
+			cmd.Stdout = buffer
+			Eventually(cmd.Run, COMMAND_TIMEOUT, COMMAND_TIMEOUT).Should(Succeed())
+			Expect(buffer).To(ContainSubstring(fmt.Sprintf("passing: connected to %s", listener.Addr().String())))
+		})
+
+		It("exits 2 when the check is critical", func() {
+			cmd := exec.Command(pathToConfab, "check", "process", "--pidfile", pidFile.Name())
+			buffer := bytes.NewBuffer([]byte{})
+			cmd.Stdout = buffer
+			err := cmd.Run()
+			Expect(err).To(HaveOccurred())
+			Expect(err.(*exec.ExitError).Sys().(syscall.WaitStatus).ExitStatus()).To(Equal(2))
+			Expect(buffer).To(ContainSubstring("critical: reading pid file failed"))
+		})
+
+		It("exits 2 and prints usage when the mode is invalid", func() {
+			cmd := exec.Command(pathToConfab, "check", "banana")
+			buffer := bytes.NewBuffer([]byte{})
+			cmd.Stderr = buffer
+			err := cmd.Run()
+			Expect(err).To(HaveOccurred())
+			Expect(err.(*exec.ExitError).Sys().(syscall.WaitStatus).ExitStatus()).To(Equal(2))
+			Expect(buffer).To(ContainSubstring(`invalid check MODE "banana"`))
+		})
+
+		It("exits 2 and prints usage when a required flag is missing", func() {
+			cmd := exec.Command(pathToConfab, "check", "http")
+			buffer := bytes.NewBuffer([]byte{})
+			cmd.Stderr = buffer
+			err := cmd.Run()
+			Expect(err).To(HaveOccurred())
+			Expect(err.(*exec.ExitError).Sys().(syscall.WaitStatus).ExitStatus()).To(Equal(2))
+			Expect(buffer).To(ContainSubstring("usage: confab check http OPTIONS"))
+			Expect(buffer).To(ContainSubstring("-url"))
+		})
+	})
+
 	Context("failure cases", func() {
 		BeforeEach(func() {
 			writeConfigurationFile(configFile.Name(), map[string]interface{}{
@@ -529,7 +684,7 @@ var _ = Describe("confab", func() {
 
 				usageLines := []string{
 					"usage: confab COMMAND OPTIONS",
-					"COMMAND: \"start\" or \"stop\"",
+					"COMMAND: \"start\", \"stop\", \"maintenance ACTION\", \"check MODE\", \"heartbeat\", \"status\" or \"forward-logs\"",
 					"-config-file",
 					"specifies the config file",
 				}
@@ -635,6 +790,40 @@ var _ = Describe("confab", func() {
 			})
 		})
 
+		Context("when the rlimits are invalid", func() {
+			BeforeEach(func() {
+				writeConfigurationFile(configFile.Name(), map[string]interface{}{
+					"path": map[string]interface{}{
+						"agent_path":        pathToFakeAgent,
+						"consul_config_dir": consulConfigDir,
+						"pid_file":          pidFile.Name(),
+					},
+					"consul": map[string]interface{}{
+						"agent": map[string]interface{}{
+							"servers": map[string]interface{}{
+								"lan": []string{"member-1"},
+							},
+							"process": map[string]interface{}{
+								"rlimits": map[string]interface{}{
+									"memlock": 64,
+								},
+							},
+						},
+					},
+				})
+			})
+
+			It("prints an error and usage", func() {
+				cmd := exec.Command(pathToConfab, "start",
+					"--config-file", configFile.Name())
+				buffer := bytes.NewBuffer([]byte{})
+				cmd.Stderr = buffer
+				Eventually(cmd.Run, COMMAND_TIMEOUT, COMMAND_TIMEOUT).ShouldNot(Succeed())
+				Expect(buffer).To(ContainSubstring(`"rlimits" are invalid: unknown rlimit "memlock"`))
+				Expect(buffer).To(ContainSubstring("usage: confab COMMAND OPTIONS"))
+			})
+		})
+
 		Context("when the consul config dir is not provided", func() {
 			BeforeEach(func() {
 				writeConfigurationFile(configFile.Name(), map[string]interface{}{
@@ -904,6 +1093,26 @@ func fakeAgentOutput(configDir string) (map[string]interface{}, error) {
 	return decodedFakeOutput, nil
 }
 
+type fakeAgentCall struct {
+	Name string
+	Args []string
+}
+
+func fakeAgentCalls(configDir string) ([]fakeAgentCall, error) {
+	fakeCalls, err := ioutil.ReadFile(filepath.Join(configDir, "fake-calls.json"))
+	if err != nil {
+		return nil, err
+	}
+
+	var calls []fakeAgentCall
+	err = json.Unmarshal(fakeCalls, &calls)
+	if err != nil {
+		return nil, err
+	}
+
+	return calls, nil
+}
+
 func killProcessAttachedToPort(port int) {
 	cmdLine := fmt.Sprintf("lsof -i :%d | tail -1 | cut -d' ' -f4", port)
 	cmd := exec.Command("bash", "-c", cmdLine)
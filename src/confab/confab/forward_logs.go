@@ -0,0 +1,52 @@
+package main
+
+import (
+	"confab/agent"
+	"flag"
+	"os"
+	"sync"
+
+	"github.com/pivotal-golang/lager"
+)
+
+// runForwardLogs is started by the runner with consul's stdout on fd 3 and
+// its stderr on fd 4, and runs until consul closes both.
+func runForwardLogs(args []string) int {
+	var eventsFile string
+
+	flagSet := flag.NewFlagSet("forward-logs", flag.ContinueOnError)
+	flagSet.StringVar(&eventsFile, "events-file", "", "specifies the `file` the counts of notable consul log events are written to")
+
+	if err := flagSet.Parse(args); err != nil {
+		return 1
+	}
+
+	logger := lager.NewLogger("confab")
+	logger.RegisterSink(lager.NewWriterSink(os.Stdout, lager.INFO))
+
+	forwarder := &agent.LogForwarder{
+		Logger:     logger,
+		EventsFile: eventsFile,
+	}
+
+	streams := map[string]*os.File{
+		"stdout": os.NewFile(3, "consul-stdout"),
+		"stderr": os.NewFile(4, "consul-stderr"),
+	}
+
+	var wg sync.WaitGroup
+	for stream, file := range streams {
+		wg.Add(1)
+		go func(stream string, file *os.File) {
+			defer wg.Done()
+			if err := forwarder.Copy(file, stream); err != nil {
+				logger.Error("consul-log.copy.failed", err, lager.Data{
+					"stream": stream,
+				})
+			}
+		}(stream, file)
+	}
+	wg.Wait()
+
+	return 0
+}
@@ -7,14 +7,18 @@ import (
 	"fmt"
 	"io/ioutil"
 	"log"
+	"net"
 	"os"
 	"os/exec"
+	"path/filepath"
+	"sort"
 	"time"
 
 	"github.com/pivotal-golang/lager"
 
 	"github.com/hashicorp/consul/api"
 	consulagent "github.com/hashicorp/consul/command/agent"
+	"github.com/miekg/dns"
 	"github.com/pivotal-golang/clock"
 )
 
@@ -30,9 +34,19 @@ func (ss *stringSlice) Set(value string) error {
 	return nil
 }
 
+// the ports of the agent's client interfaces, as written by the consul
+// config definer
+const (
+	agentHTTPPort = "8500"
+	agentRPCPort  = "8400"
+	agentDNSPort  = "53"
+)
+
 var (
-	recursors  stringSlice
-	configFile string
+	recursors          stringSlice
+	configFile         string
+	maintenanceService string
+	maintenanceReason  string
 
 	stdout = log.New(os.Stdout, "", 0)
 	stderr = log.New(os.Stderr, "", 0)
@@ -44,22 +58,41 @@ func main() {
 	flagSet := flag.NewFlagSet("flags", flag.ContinueOnError)
 	flagSet.Var(&recursors, "recursor", "specifies the address of an upstream DNS `server`, may be specified multiple times")
 	flagSet.StringVar(&configFile, "config-file", "", "specifies the config `file`")
+	flagSet.StringVar(&maintenanceService, "service", "", "specifies the `id` of the service to put into maintenance mode, defaults to the whole node")
+	flagSet.StringVar(&maintenanceReason, "reason", "", "specifies the `reason` recorded when enabling maintenance mode")
 
 	if len(os.Args) < 2 {
 		printUsageAndExit("invalid number of arguments", flagSet)
 	}
 
-	if err := flagSet.Parse(os.Args[2:]); err != nil {
-		os.Exit(1)
+	command := os.Args[1]
+	args := os.Args[2:]
+
+	switch command {
+	case "check":
+		os.Exit(runCheck(args))
+	case "heartbeat":
+		os.Exit(runHeartbeat(args))
+	case "status":
+		os.Exit(runStatus(args))
+	case "forward-logs":
+		os.Exit(runForwardLogs(args))
 	}
 
-	configFileContents, err := ioutil.ReadFile(configFile)
-	if err != nil {
-		stderr.Printf("error reading configuration file: %s", err)
+	var maintenanceAction string
+	if command == "maintenance" {
+		if len(args) < 1 {
+			printUsageAndExit("maintenance requires an ACTION of \"enable\" or \"disable\"", flagSet)
+		}
+		maintenanceAction = args[0]
+		args = args[1:]
+	}
+
+	if err := flagSet.Parse(args); err != nil {
 		os.Exit(1)
 	}
 
-	config, err := confab.ConfigFromJSON(configFileContents)
+	config, err := readConfig(configFile)
 	if err != nil {
 		stderr.Printf("error reading configuration file: %s", err)
 		os.Exit(1)
@@ -74,6 +107,17 @@ func main() {
 		printUsageAndExit("\"pid_file\" cannot be empty", flagSet)
 	}
 
+	process := config.Consul.Agent.Process
+	rlimits, err := agent.ParseRlimits(process.Rlimits)
+	if err != nil {
+		printUsageAndExit(fmt.Sprintf("\"rlimits\" are invalid: %s", err), flagSet)
+	}
+
+	gomaxprocs := process.GOMAXPROCS
+	if gomaxprocs == 0 {
+		gomaxprocs = agent.DefaultGOMAXPROCS()
+	}
+
 	logger := lager.NewLogger("confab")
 	logger.RegisterSink(lager.NewWriterSink(os.Stdout, lager.INFO))
 
@@ -85,18 +129,34 @@ func main() {
 		Stdout:    os.Stdout,
 		Stderr:    os.Stderr,
 		Logger:    logger,
+		LogForwarder: []string{
+			"/proc/self/exe", "forward-logs",
+			"--events-file", eventsFile(config.Path.PIDFile),
+		},
+		EventsFile: eventsFile(config.Path.PIDFile),
+		Rlimits:    rlimits,
+		GOMAXPROCS: gomaxprocs,
+		Env:        environment(process.Env),
+		Dir:        process.WorkingDir,
+		User:       process.User,
+		Group:      process.Group,
 	}
 
-	consulAPIClient, err := api.NewClient(api.DefaultConfig())
+	consulAPIClient, err := api.NewClient(agentAPIConfig(config))
 	if err != nil {
 		panic(err) // not tested, NewClient never errors
 	}
 
 	agentClient := &agent.Client{
-		ExpectedMembers: config.Consul.Agent.Servers.LAN,
-		ConsulAPIAgent:  consulAPIClient.Agent(),
-		ConsulRPCClient: nil,
-		Logger:          logger,
+		ExpectedMembers:        config.Consul.Agent.Servers.LAN,
+		ConsulAPIAgent:         consulAPIClient.Agent(),
+		ConsulAPIStatus:        consulAPIClient.Status(),
+		ConsulAPIPreparedQuery: consulAPIClient.PreparedQuery(),
+		ConsulAPIKV:            consulAPIClient.KV(),
+		ConsulRPCClient:        nil,
+		DNSClient:              new(dns.Client),
+		DNSAddress:             net.JoinHostPort(confab.AgentClientAddr(config.Consul.Agent), agentDNSPort),
+		Logger:                 logger,
 	}
 
 	controller = confab.Controller{
@@ -111,13 +171,15 @@ func main() {
 		Config:         config,
 	}
 
-	switch os.Args[1] {
+	switch command {
 	case "start":
 		start(flagSet, path, controller, agentClient)
 	case "stop":
 		stop(path, controller, agentClient)
+	case "maintenance":
+		maintenance(flagSet, maintenanceAction, controller)
 	default:
-		printUsageAndExit(fmt.Sprintf("invalid COMMAND %q", os.Args[1]), flagSet)
+		printUsageAndExit(fmt.Sprintf("invalid COMMAND %q", command), flagSet)
 	}
 }
 
@@ -130,7 +192,7 @@ func start(flagSet *flag.FlagSet, path string, controller confab.Controller, age
 			controller.Config.Path.ConsulConfigDir), flagSet)
 	}
 
-	if len(agentClient.ExpectedMembers) == 0 {
+	if len(agentClient.ExpectedMembers) == 0 && controller.Config.Consul.Agent.BootstrapMode != "single" {
 		printUsageAndExit("at least one \"expected-member\" must be provided", flagSet)
 	}
 
@@ -155,12 +217,12 @@ func start(flagSet *flag.FlagSet, path string, controller confab.Controller, age
 	if controller.Config.Consul.Agent.Mode == "server" {
 		configureServer(controller, agentClient, timeout)
 	} else {
-		configureClient(controller)
+		configureClient(controller, timeout)
 	}
 }
 
 func configureServer(controller confab.Controller, agentClient *agent.Client, timeout confab.Timeout) {
-	rpcClient, err := consulagent.NewRPCClient("localhost:8400")
+	rpcClient, err := consulagent.NewRPCClient(net.JoinHostPort(confab.AgentClientAddr(controller.Config.Consul.Agent), agentRPCPort))
 
 	if err != nil {
 		stderr.Printf("error connecting to RPC server: %s", err)
@@ -175,30 +237,51 @@ func configureServer(controller confab.Controller, agentClient *agent.Client, ti
 	}
 }
 
-func configureClient(controller confab.Controller) {
-	if err := controller.ConfigureClient(); err != nil {
+func configureClient(controller confab.Controller, timeout confab.Timeout) {
+	if err := controller.ConfigureClient(timeout); err != nil {
 		stderr.Printf("error configuring client: %s", err)
 		exit(controller, 1)
 	}
 }
 
 func stop(path string, controller confab.Controller, agentClient *agent.Client) {
-	rpcClient, err := consulagent.NewRPCClient("localhost:8400")
+	rpcClient, err := consulagent.NewRPCClient(net.JoinHostPort(confab.AgentClientAddr(controller.Config.Consul.Agent), agentRPCPort))
 	if err != nil {
 		stderr.Printf("error connecting to RPC server: %s", err)
 		exit(controller, 1)
 	}
 
 	agentClient.ConsulRPCClient = &agent.RPCClient{*rpcClient}
+	controller.DrainAgent()
+
 	stderr.Printf("stopping agent")
 	controller.StopAgent()
 	stderr.Printf("stopped agent")
 }
 
+func maintenance(flagSet *flag.FlagSet, action string, controller confab.Controller) {
+	var err error
+	switch action {
+	case "enable":
+		err = controller.EnableMaintenance(maintenanceService, maintenanceReason)
+	case "disable":
+		err = controller.DisableMaintenance(maintenanceService)
+	default:
+		printUsageAndExit(fmt.Sprintf("invalid maintenance ACTION %q", action), flagSet)
+	}
+
+	if err != nil {
+		stderr.Printf("error setting maintenance mode: %s", err)
+		os.Exit(1)
+	}
+}
+
 func printUsageAndExit(message string, flagSet *flag.FlagSet) {
 	stderr.Printf("%s\n\n", message)
 	stderr.Println("usage: confab COMMAND OPTIONS\n")
-	stderr.Println("COMMAND: \"start\" or \"stop\"")
+	stderr.Println("COMMAND: \"start\", \"stop\", \"maintenance ACTION\", \"check MODE\", \"heartbeat\", \"status\" or \"forward-logs\"")
+	stderr.Println("ACTION: \"enable\" or \"disable\"")
+	stderr.Println("MODE: \"http\", \"tcp\", \"process\" or \"file-age\"")
 	stderr.Println("\nOPTIONS:")
 	flagSet.PrintDefaults()
 	stderr.Println()
@@ -206,7 +289,7 @@ func printUsageAndExit(message string, flagSet *flag.FlagSet) {
 }
 
 func validCommand(command string) bool {
-	for _, c := range []string{"start", "stop"} {
+	for _, c := range []string{"start", "stop", "maintenance", "check", "heartbeat", "status", "forward-logs"} {
 		if command == c {
 			return true
 		}
@@ -215,7 +298,40 @@ func validCommand(command string) bool {
 	return false
 }
 
+// eventsFile keeps the counts of notable consul log events next to the pid
+// file, where confab status can find them.
+func eventsFile(pidFile string) string {
+	return filepath.Join(filepath.Dir(pidFile), "consul_log_events.json")
+}
+
 func exit(controller confab.Controller, code int) {
 	controller.StopAgent()
 	os.Exit(code)
 }
+
+func readConfig(path string) (confab.Config, error) {
+	configFileContents, err := ioutil.ReadFile(path)
+	if err != nil {
+		return confab.Config{}, err
+	}
+
+	return confab.ConfigFromJSON(configFileContents)
+}
+
+// agentAPIConfig points the consul API client at the agent's configured
+// client address instead of the library's default of 127.0.0.1:8500.
+func agentAPIConfig(config confab.Config) *api.Config {
+	apiConfig := api.DefaultConfig()
+	apiConfig.Address = net.JoinHostPort(confab.AgentClientAddr(config.Consul.Agent), agentHTTPPort)
+	return apiConfig
+}
+
+func environment(env map[string]string) []string {
+	var variables []string
+	for name, value := range env {
+		variables = append(variables, fmt.Sprintf("%s=%s", name, value))
+	}
+	sort.Strings(variables)
+
+	return variables
+}
@@ -14,8 +14,9 @@ func TestMain(t *testing.T) {
 }
 
 var (
-	pathToFakeAgent string
-	pathToConfab    string
+	pathToFakeAgent   string
+	pathToFakeProcess string
+	pathToConfab      string
 )
 
 var _ = BeforeSuite(func() {
@@ -23,6 +24,9 @@ var _ = BeforeSuite(func() {
 	pathToFakeAgent, err = gexec.Build("confab/fakes/agent")
 	Expect(err).NotTo(HaveOccurred())
 
+	pathToFakeProcess, err = gexec.Build("confab/fakes/process")
+	Expect(err).NotTo(HaveOccurred())
+
 	pathToConfab, err = gexec.Build("confab/confab")
 	Expect(err).NotTo(HaveOccurred())
 })
@@ -0,0 +1,187 @@
+package main
+
+import (
+	"confab"
+	"confab/agent"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/hashicorp/consul/api"
+	"github.com/pivotal-golang/lager"
+)
+
+type status struct {
+	Members   []agent.Member      `json:"members"`
+	Spread    agent.VersionSpread `json:"spread"`
+	LogEvents map[string]int      `json:"log_events"`
+}
+
+func runStatus(args []string) int {
+	var (
+		asJSON           bool
+		logEventsFile    string
+		statusConfigFile string
+	)
+
+	flagSet := flag.NewFlagSet("status", flag.ContinueOnError)
+	flagSet.BoolVar(&asJSON, "json", false, "prints the status as JSON")
+	flagSet.StringVar(&logEventsFile, "events-file", "", "specifies the `file` the counts of notable consul log events are read from, defaults to the one next to the pid file")
+	flagSet.StringVar(&statusConfigFile, "config-file", "", "specifies the config `file` the agent address and pid file are read from")
+
+	if err := flagSet.Parse(args); err != nil {
+		return 1
+	}
+
+	config := confab.DefaultConfig()
+	if statusConfigFile != "" {
+		var err error
+		config, err = readConfig(statusConfigFile)
+		if err != nil {
+			stderr.Printf("error reading configuration file: %s", err)
+			return 1
+		}
+	}
+
+	if logEventsFile == "" {
+		logEventsFile = eventsFile(config.Path.PIDFile)
+	}
+
+	consulAPIClient, err := api.NewClient(agentAPIConfig(config))
+	if err != nil {
+		panic(err) // not tested, NewClient never errors
+	}
+
+	// the status is the output here, so the client logs go nowhere
+	agentClient := agent.Client{
+		ConsulAPIAgent: consulAPIClient.Agent(),
+		Logger:         lager.NewLogger("confab"),
+	}
+
+	members, err := agentClient.Members()
+	if err != nil {
+		stderr.Printf("error reading members: %s", err)
+		return 1
+	}
+
+	sort.Sort(membersByName(members))
+	s := status{
+		Members:   members,
+		Spread:    agent.Spread(members),
+		LogEvents: map[string]int{},
+	}
+
+	// the file only exists once the forwarder has seen a notable event
+	if counts, err := agent.ReadEventCounts(logEventsFile); err == nil {
+		s.LogEvents = counts
+	}
+
+	if asJSON {
+		if err := json.NewEncoder(os.Stdout).Encode(s); err != nil {
+			stderr.Printf("error writing status: %s", err)
+			return 1
+		}
+		return 0
+	}
+
+	printStatus(os.Stdout, s)
+	return 0
+}
+
+func printStatus(w io.Writer, s status) {
+	table := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(table, "NAME\tADDRESS\tSTATUS\tROLE\tDC\tPROTOCOL\tSERF\tBUILD")
+	for _, member := range s.Members {
+		fmt.Fprintf(table, "%s\t%s:%d\t%s\t%s\t%s\t%s\t%s\t%s\n",
+			member.Name, member.Addr, member.Port, member.Status, member.Role, member.Datacenter,
+			formatProtocol(member.Protocol), formatProtocol(member.SerfProtocol), member.Build)
+	}
+	table.Flush()
+
+	fmt.Fprintln(w)
+	fmt.Fprintf(w, "protocols: %s\n", formatIntSpread(s.Spread.Protocols))
+	fmt.Fprintf(w, "serf protocols: %s\n", formatIntSpread(s.Spread.SerfProtocols))
+	fmt.Fprintf(w, "builds: %s\n", formatStringSpread(s.Spread.Builds))
+	fmt.Fprintf(w, "consul log events: %s\n", formatLogEvents(s.LogEvents))
+}
+
+func formatLogEvents(counts map[string]int) string {
+	if len(counts) == 0 {
+		return "none"
+	}
+
+	var names []string
+	for name := range counts {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var parts []string
+	for _, name := range names {
+		parts = append(parts, fmt.Sprintf("%s (%d)", name, counts[name]))
+	}
+
+	return strings.Join(parts, ", ")
+}
+
+func formatProtocol(protocol agent.MemberProtocol) string {
+	return fmt.Sprintf("%d (%d-%d)", protocol.Current, protocol.Min, protocol.Max)
+}
+
+func formatIntSpread(spread map[string][]string) string {
+	var versions []int
+	for version := range spread {
+		v, err := strconv.Atoi(version)
+		if err != nil {
+			continue // not tested, Spread only writes decimal keys
+		}
+		versions = append(versions, v)
+	}
+	sort.Ints(versions)
+
+	var parts []string
+	for _, version := range versions {
+		parts = append(parts, fmt.Sprintf("%d (%s)", version, countMembers(spread[strconv.Itoa(version)])))
+	}
+
+	return strings.Join(parts, ", ")
+}
+
+func formatStringSpread(spread map[string][]string) string {
+	var versions []string
+	for version := range spread {
+		versions = append(versions, version)
+	}
+	sort.Strings(versions)
+
+	var parts []string
+	for _, version := range versions {
+		name := version
+		if name == "" {
+			name = "unknown"
+		}
+		parts = append(parts, fmt.Sprintf("%s (%s)", name, countMembers(spread[version])))
+	}
+
+	return strings.Join(parts, ", ")
+}
+
+func countMembers(names []string) string {
+	if len(names) == 1 {
+		return "1 member"
+	}
+
+	return fmt.Sprintf("%d members", len(names))
+}
+
+type membersByName []agent.Member
+
+func (m membersByName) Len() int           { return len(m) }
+func (m membersByName) Swap(i, j int)      { m[i], m[j] = m[j], m[i] }
+func (m membersByName) Less(i, j int) bool { return m[i].Name < m[j].Name }
@@ -1,6 +1,9 @@
 package confab
 
-import "encoding/json"
+import (
+	"encoding/json"
+	"net"
+)
 
 type Config struct {
 	Node   ConfigNode
@@ -14,9 +17,31 @@ type ConfigConfab struct {
 }
 
 type ConfigConsul struct {
-	Agent       ConfigConsulAgent
-	RequireSSL  bool     `json:"require_ssl"`
-	EncryptKeys []string `json:"encrypt_keys"`
+	Agent           ConfigConsulAgent
+	RequireSSL      bool                           `json:"require_ssl"`
+	EncryptKeys     []string                       `json:"encrypt_keys"`
+	PreparedQueries []ConfigConsulPreparedQuery    `json:"prepared_queries"`
+	KVSeed          map[string]ConfigConsulKVEntry `json:"kv_seed"`
+	KVSeedMode      string                         `json:"kv_seed_mode"`
+}
+
+type ConfigConsulKVEntry struct {
+	Value string `json:"value"`
+	Flags uint64 `json:"flags"`
+}
+
+type ConfigConsulPreparedQuery struct {
+	Name        string                            `json:"name"`
+	Service     string                            `json:"service"`
+	OnlyPassing bool                              `json:"only_passing"`
+	Tags        []string                          `json:"tags"`
+	Failover    ConfigConsulPreparedQueryFailover `json:"failover"`
+	DNSTTL      string                            `json:"dns_ttl"`
+}
+
+type ConfigConsulPreparedQueryFailover struct {
+	NearestN    int      `json:"nearest_n"`
+	Datacenters []string `json:"datacenters"`
 }
 
 type ConfigPath struct {
@@ -29,15 +54,56 @@ type ConfigNode struct {
 	Name       string
 	Index      int
 	ExternalIP string `json:"external_ip"`
+	Deployment string `json:"deployment"`
+	AZ         string `json:"az"`
+	ID         string `json:"id"`
+	Bootstrap  bool   `json:"bootstrap"`
 }
 
 type ConfigConsulAgent struct {
-	Servers         ConfigConsulAgentServers
-	Services        map[string]ServiceDefinition
-	Mode            string
-	Datacenter      string `json:"datacenter"`
-	LogLevel        string `json:"log_level"`
-	ProtocolVersion int    `json:"protocol_version"`
+	Servers            ConfigConsulAgentServers
+	Services           map[string]ServiceDefinition
+	Mode               string
+	Datacenter         string                           `json:"datacenter"`
+	LogLevel           string                           `json:"log_level"`
+	ProtocolVersion    int                              `json:"protocol_version"`
+	ClientReadiness    ConfigConsulAgentClientReadiness `json:"client_readiness"`
+	WaitForServices    bool                             `json:"wait_for_services"`
+	DrainTimeInSeconds int                              `json:"drain_time_in_seconds"`
+	NodeMeta           bool                             `json:"node_meta"`
+	AdvertiseAddr      string                           `json:"advertise_addr"`
+	AdvertiseAddrWAN   string                           `json:"advertise_addr_wan"`
+	ClientAddr         string                           `json:"client_addr"`
+	SerfLANBind        string                           `json:"serf_lan_bind"`
+	ExtraConfig        map[string]interface{}           `json:"extra_config"`
+	Watches            []ConsulConfigWatch              `json:"watches"`
+	Connect            ConfigConsulAgentConnect         `json:"connect"`
+	BootstrapMode      string                           `json:"bootstrap_mode"`
+	ReconcilePeers     bool                             `json:"reconcile_peers"`
+	ProtocolPolicy     string                           `json:"protocol_policy"`
+	Process            ConfigConsulAgentProcess         `json:"process"`
+}
+
+// User and Group only take effect when confab runs as root. The consul_agent
+// job starts confab as vcap and does not expose them.
+type ConfigConsulAgentProcess struct {
+	User       string            `json:"user"`
+	Group      string            `json:"group"`
+	Rlimits    map[string]int64  `json:"rlimits"`
+	GOMAXPROCS int               `json:"gomaxprocs"`
+	Env        map[string]string `json:"env"`
+	WorkingDir string            `json:"working_dir"`
+}
+
+type ConfigConsulAgentConnect struct {
+	Enabled    bool
+	CAProvider string                 `json:"ca_provider"`
+	CAConfig   map[string]interface{} `json:"ca_config"`
+}
+
+type ConfigConsulAgentClientReadiness struct {
+	Enabled  bool
+	DNSCheck bool `json:"dns_check"`
 }
 
 type ConfigConsulAgentServers struct {
@@ -73,3 +139,15 @@ func ConfigFromJSON(configData []byte) (Config, error) {
 
 	return config, nil
 }
+
+// AgentClientAddr is the address confab reaches the local agent's HTTP, DNS
+// and RPC interfaces on: the configured client_addr, or loopback when it is
+// empty or binds every interface.
+func AgentClientAddr(agent ConfigConsulAgent) string {
+	ip := net.ParseIP(agent.ClientAddr)
+	if ip == nil || ip.IsUnspecified() {
+		return "127.0.0.1"
+	}
+
+	return ip.String()
+}
@@ -38,7 +38,11 @@ var _ = Describe("Config", func() {
 				"node": {
 					"name": "nodename",
 					"index": 1234,
-					"external_ip": "10.0.0.1"
+					"external_ip": "10.0.0.1",
+					"deployment": "cf",
+					"az": "z1",
+					"id": "some-instance-id",
+					"bootstrap": true
 				},
 				"path": {
 					"agent_path": "/path/to/agent",
@@ -55,10 +59,61 @@ var _ = Describe("Config", func() {
 						"mode": "server",
 						"datacenter": "dc1",
 						"log_level": "debug",
-						"protocol_version": 1
+						"protocol_version": 1,
+						"client_readiness": {
+							"enabled": true,
+							"dns_check": true
+						},
+						"wait_for_services": true,
+						"drain_time_in_seconds": 10,
+						"node_meta": true,
+						"advertise_addr": "203.0.113.10",
+						"advertise_addr_wan": "198.51.100.10",
+						"client_addr": "0.0.0.0",
+						"serf_lan_bind": "10.0.1.5",
+						"extra_config": {
+							"log_level": "warn"
+						},
+						"connect": {
+							"enabled": true,
+							"ca_provider": "consul"
+						},
+						"bootstrap_mode": "join",
+						"reconcile_peers": true,
+						"process": {
+							"user": "vcap",
+							"group": "vcap",
+							"rlimits": {
+								"as": -1,
+								"nofile": 4096
+							},
+							"gomaxprocs": 4,
+							"env": {
+								"SOME_VAR": "some-value"
+							},
+							"working_dir": "/var/vcap/store/consul_agent"
+						}
 					},
 					"require_ssl": true,
-					"encrypt_keys": ["key-1", "key-2"]
+					"encrypt_keys": ["key-1", "key-2"],
+					"prepared_queries": [{
+						"name": "router",
+						"service": "gorouter",
+						"only_passing": true,
+						"tags": ["z1"],
+						"failover": {
+							"nearest_n": 2,
+							"datacenters": ["dc2"]
+						},
+						"dns_ttl": "10s"
+					}],
+					"kv_seed": {
+						"features/new-ui": {
+							"value": "true",
+							"flags": 1
+						}
+					},
+					"kv_seed_mode": "enforce"
 				},
 				"confab": {
 					"timeout_in_seconds": 30
@@ -77,6 +132,10 @@ var _ = Describe("Config", func() {
 					Name:       "nodename",
 					Index:      1234,
 					ExternalIP: "10.0.0.1",
+					Deployment: "cf",
+					AZ:         "z1",
+					ID:         "some-instance-id",
+					Bootstrap:  true,
 				},
 				Consul: confab.ConfigConsul{
 					Agent: confab.ConfigConsulAgent{
@@ -89,12 +148,65 @@ var _ = Describe("Config", func() {
 						Datacenter:      "dc1",
 						LogLevel:        "debug",
 						ProtocolVersion: 1,
+						ClientReadiness: confab.ConfigConsulAgentClientReadiness{
+							Enabled:  true,
+							DNSCheck: true,
+						},
+						WaitForServices:    true,
+						DrainTimeInSeconds: 10,
+						NodeMeta:           true,
+						AdvertiseAddr:      "203.0.113.10",
+						AdvertiseAddrWAN:   "198.51.100.10",
+						ClientAddr:         "0.0.0.0",
+						SerfLANBind:        "10.0.1.5",
+						ExtraConfig: map[string]interface{}{
+							"log_level": "warn",
+						},
+						Connect: confab.ConfigConsulAgentConnect{
+							Enabled:    true,
+							CAProvider: "consul",
+						},
+						BootstrapMode:  "join",
+						ReconcilePeers: true,
+						Process: confab.ConfigConsulAgentProcess{
+							User:  "vcap",
+							Group: "vcap",
+							Rlimits: map[string]int64{
+								"as":     -1,
+								"nofile": 4096,
+							},
+							GOMAXPROCS: 4,
+							Env: map[string]string{
+								"SOME_VAR": "some-value",
+							},
+							WorkingDir: "/var/vcap/store/consul_agent",
+						},
 						Servers: confab.ConfigConsulAgentServers{
 							LAN: []string{},
 						},
 					},
 					RequireSSL:  true,
 					EncryptKeys: []string{"key-1", "key-2"},
+					PreparedQueries: []confab.ConfigConsulPreparedQuery{
+						{
+							Name:        "router",
+							Service:     "gorouter",
+							OnlyPassing: true,
+							Tags:        []string{"z1"},
+							Failover: confab.ConfigConsulPreparedQueryFailover{
+								NearestN:    2,
+								Datacenters: []string{"dc2"},
+							},
+							DNSTTL: "10s",
+						},
+					},
+					KVSeed: map[string]confab.ConfigConsulKVEntry{
+						"features/new-ui": {
+							Value: "true",
+							Flags: 1,
+						},
+					},
+					KVSeedMode: "enforce",
 				},
 				Confab: confab.ConfigConfab{
 					TimeoutInSeconds: 30,
@@ -132,4 +244,18 @@ var _ = Describe("Config", func() {
 			Expect(err).To(MatchError(ContainSubstring("invalid character")))
 		})
 	})
+
+	Describe("AgentClientAddr", func() {
+		It("returns the configured client address", func() {
+			Expect(confab.AgentClientAddr(confab.ConfigConsulAgent{ClientAddr: "10.0.0.1"})).To(Equal("10.0.0.1"))
+		})
+
+		It("defaults to loopback", func() {
+			Expect(confab.AgentClientAddr(confab.ConfigConsulAgent{})).To(Equal("127.0.0.1"))
+		})
+
+		It("uses loopback when the agent binds every interface", func() {
+			Expect(confab.AgentClientAddr(confab.ConfigConsulAgent{ClientAddr: "0.0.0.0"})).To(Equal("127.0.0.1"))
+		})
+	})
 })
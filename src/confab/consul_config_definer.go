@@ -5,37 +5,97 @@ import (
 	"encoding/base64"
 	"fmt"
 	"path/filepath"
+	"strconv"
 	"strings"
+	"time"
 
 	"golang.org/x/crypto/pbkdf2"
 )
 
 type ConsulConfig struct {
-	Server               bool              `json:"server"`
-	Domain               string            `json:"domain"`
-	Datacenter           string            `json:"datacenter"`
-	DataDir              string            `json:"data_dir"`
-	LogLevel             string            `json:"log_level"`
-	NodeName             string            `json:"node_name"`
-	Ports                ConsulConfigPorts `json:"ports"`
-	RejoinAfterLeave     bool              `json:"rejoin_after_leave"`
-	RetryJoin            []string          `json:"retry_join"`
-	BindAddr             string            `json:"bind_addr"`
-	DisableRemoteExec    bool              `json:"disable_remote_exec"`
-	DisableUpdateCheck   bool              `json:"disable_update_check"`
-	Protocol             int               `json:"protocol"`
-	VerifyOutgoing       *bool             `json:"verify_outgoing,omitempty"`
-	VerifyIncoming       *bool             `json:"verify_incoming,omitempty"`
-	VerifyServerHostname *bool             `json:"verify_server_hostname,omitempty"`
-	CAFile               *string           `json:"ca_file,omitempty"`
-	KeyFile              *string           `json:"key_file,omitempty"`
-	CertFile             *string           `json:"cert_file,omitempty"`
-	Encrypt              *string           `json:"encrypt,omitempty"`
-	BootstrapExpect      *int              `json:"bootstrap_expect,omitempty"`
+	Server               bool                 `json:"server"`
+	Domain               string               `json:"domain"`
+	Datacenter           string               `json:"datacenter"`
+	DataDir              string               `json:"data_dir"`
+	LogLevel             string               `json:"log_level"`
+	NodeName             string               `json:"node_name"`
+	Ports                ConsulConfigPorts    `json:"ports"`
+	RejoinAfterLeave     bool                 `json:"rejoin_after_leave"`
+	RetryJoin            []string             `json:"retry_join"`
+	BindAddr             string               `json:"bind_addr"`
+	DisableRemoteExec    bool                 `json:"disable_remote_exec"`
+	DisableUpdateCheck   bool                 `json:"disable_update_check"`
+	Protocol             int                  `json:"protocol"`
+	VerifyOutgoing       *bool                `json:"verify_outgoing,omitempty"`
+	VerifyIncoming       *bool                `json:"verify_incoming,omitempty"`
+	VerifyServerHostname *bool                `json:"verify_server_hostname,omitempty"`
+	CAFile               *string              `json:"ca_file,omitempty"`
+	KeyFile              *string              `json:"key_file,omitempty"`
+	CertFile             *string              `json:"cert_file,omitempty"`
+	Encrypt              *string              `json:"encrypt,omitempty"`
+	Bootstrap            *bool                `json:"bootstrap,omitempty"`
+	BootstrapExpect      *int                 `json:"bootstrap_expect,omitempty"`
+	NodeMeta             map[string]string    `json:"node_meta,omitempty"`
+	AdvertiseAddr        *string              `json:"advertise_addr,omitempty"`
+	AdvertiseAddrWAN     *string              `json:"advertise_addr_wan,omitempty"`
+	ClientAddr           *string              `json:"client_addr,omitempty"`
+	SerfLANBind          *string              `json:"serf_lan_bind,omitempty"`
+	Watches              []ConsulConfigWatch  `json:"watches,omitempty"`
+	Connect              *ConsulConfigConnect `json:"connect,omitempty"`
+}
+
+var managedConfigKeys = []string{
+	"addresses",
+	"advertise_addr",
+	"advertise_addr_wan",
+	"bind_addr",
+	"bootstrap",
+	"bootstrap_expect",
+	"ca_file",
+	"cert_file",
+	"client_addr",
+	"connect",
+	"data_dir",
+	"domain",
+	"encrypt",
+	"key_file",
+	"node_meta",
+	"node_name",
+	"ports",
+	"protocol",
+	"retry_join",
+	"serf_lan_bind",
+	"server",
+	"start_join",
+	"verify_incoming",
+	"verify_outgoing",
+	"verify_server_hostname",
+	"watches",
 }
 
 type ConsulConfigPorts struct {
-	DNS int `json:"dns"`
+	DNS  int `json:"dns"`
+	GRPC int `json:"grpc,omitempty"`
+}
+
+type ConsulConfigConnect struct {
+	Enabled    bool                   `json:"enabled"`
+	CAProvider string                 `json:"ca_provider,omitempty"`
+	CAConfig   map[string]interface{} `json:"ca_config,omitempty"`
+}
+
+type ConsulConfigWatch struct {
+	Type        string `json:"type"`
+	Key         string `json:"key,omitempty"`
+	Prefix      string `json:"prefix,omitempty"`
+	Service     string `json:"service,omitempty"`
+	Tag         string `json:"tag,omitempty"`
+	PassingOnly bool   `json:"passingonly,omitempty"`
+	State       string `json:"state,omitempty"`
+	Name        string `json:"name,omitempty"`
+	Datacenter  string `json:"datacenter,omitempty"`
+	Token       string `json:"token,omitempty"`
+	Handler     string `json:"handler"`
 }
 
 func GenerateConfiguration(config Config) ConsulConfig {
@@ -44,8 +104,7 @@ func GenerateConfiguration(config Config) ConsulConfig {
 		lan = []string{}
 	}
 
-	nodeName := strings.Replace(config.Node.Name, "_", "-", -1)
-	nodeName = fmt.Sprintf("%s-%d", nodeName, config.Node.Index)
+	nodeName := NodeName(config.Node)
 
 	isServer := config.Consul.Agent.Mode == "server"
 
@@ -88,12 +147,161 @@ func GenerateConfiguration(config Config) ConsulConfig {
 	}
 
 	if isServer {
-		consulConfig.BootstrapExpect = intPtr(len(config.Consul.Agent.Servers.LAN))
+		switch config.Consul.Agent.BootstrapMode {
+		case "single":
+			consulConfig.Bootstrap = boolPtr(true)
+		case "join":
+			// the cluster has already bootstrapped, so counting servers that
+			// are not deployed yet would only hold up the election
+		default:
+			consulConfig.BootstrapExpect = intPtr(len(config.Consul.Agent.Servers.LAN))
+		}
+	}
+
+	if config.Consul.Agent.AdvertiseAddr != "" {
+		consulConfig.AdvertiseAddr = strPtr(config.Consul.Agent.AdvertiseAddr)
+	}
+
+	if config.Consul.Agent.AdvertiseAddrWAN != "" {
+		consulConfig.AdvertiseAddrWAN = strPtr(config.Consul.Agent.AdvertiseAddrWAN)
+	}
+
+	if config.Consul.Agent.ClientAddr != "" {
+		consulConfig.ClientAddr = strPtr(config.Consul.Agent.ClientAddr)
+	}
+
+	if config.Consul.Agent.SerfLANBind != "" {
+		consulConfig.SerfLANBind = strPtr(config.Consul.Agent.SerfLANBind)
+	}
+
+	if len(config.Consul.Agent.Watches) > 0 {
+		consulConfig.Watches = config.Consul.Agent.Watches
+	}
+
+	if config.Consul.Agent.NodeMeta {
+		consulConfig.NodeMeta = nodeMeta(config.Node)
+	}
+
+	if config.Consul.Agent.Connect.Enabled {
+		consulConfig.Connect = &ConsulConfigConnect{
+			Enabled:    true,
+			CAProvider: config.Consul.Agent.Connect.CAProvider,
+			CAConfig:   config.Consul.Agent.Connect.CAConfig,
+		}
+		consulConfig.Ports.GRPC = 8502
 	}
 
 	return consulConfig
 }
 
+func NodeName(node ConfigNode) string {
+	return fmt.Sprintf("%s-%d", strings.Replace(node.Name, "_", "-", -1), node.Index)
+}
+
+func nodeMeta(node ConfigNode) map[string]string {
+	meta := map[string]string{
+		"bootstrap": strconv.FormatBool(node.Bootstrap),
+	}
+
+	if node.Deployment != "" {
+		meta["deployment"] = node.Deployment
+	}
+
+	if node.AZ != "" {
+		meta["az"] = node.AZ
+	}
+
+	if node.ID != "" {
+		meta["instance_id"] = node.ID
+	}
+
+	return meta
+}
+
+func validateExtraConfig(extraConfig map[string]interface{}) error {
+	var conflicts []string
+	for _, key := range managedConfigKeys {
+		if _, ok := extraConfig[key]; ok {
+			conflicts = append(conflicts, key)
+		}
+	}
+
+	if len(conflicts) > 0 {
+		return fmt.Errorf("extra_config cannot override keys managed by confab: %s", strings.Join(conflicts, ", "))
+	}
+
+	return nil
+}
+
+// validatePreparedQueries rejects definitions consul would refuse, before
+// confab starts retrying the reconciliation against the cluster.
+func validatePreparedQueries(queries []ConfigConsulPreparedQuery) error {
+	declared := map[string]bool{}
+	for i, query := range queries {
+		if query.Name == "" {
+			return fmt.Errorf("prepared query %d has no name", i)
+		}
+
+		if declared[query.Name] {
+			return fmt.Errorf("prepared query %q is declared more than once", query.Name)
+		}
+		declared[query.Name] = true
+
+		if query.Service == "" {
+			return fmt.Errorf("prepared query %q has no service", query.Name)
+		}
+
+		if query.DNSTTL != "" {
+			if _, err := time.ParseDuration(query.DNSTTL); err != nil {
+				return fmt.Errorf("prepared query %q has an invalid dns_ttl %q", query.Name, query.DNSTTL)
+			}
+		}
+	}
+
+	return nil
+}
+
+func validateBootstrap(agent ConfigConsulAgent) error {
+	switch agent.BootstrapMode {
+	case "", "expect", "join":
+	case "single":
+		if len(agent.Servers.LAN) > 1 {
+			return fmt.Errorf("bootstrap_mode \"single\" cannot be used with %d lan servers", len(agent.Servers.LAN))
+		}
+	default:
+		return fmt.Errorf("invalid bootstrap_mode %q, must be \"expect\", \"single\" or \"join\"", agent.BootstrapMode)
+	}
+
+	return nil
+}
+
+func validateWatches(watches []ConsulConfigWatch) error {
+	for i, watch := range watches {
+		var required, value string
+		switch watch.Type {
+		case "key":
+			required, value = "key", watch.Key
+		case "keyprefix":
+			required, value = "prefix", watch.Prefix
+		case "service":
+			required, value = "service", watch.Service
+		case "services", "nodes", "checks", "event":
+		default:
+			return fmt.Errorf("watch %d has unknown type %q", i, watch.Type)
+		}
+
+		if required != "" && value == "" {
+			return fmt.Errorf("watch %d of type %q is missing %q", i, watch.Type, required)
+		}
+
+		if watch.Handler == "" {
+			return fmt.Errorf("watch %d of type %q is missing a handler", i, watch.Type)
+		}
+	}
+
+	return nil
+}
+
 func encryptKey(key string) *string {
 	decodedKey, err := base64.StdEncoding.DecodeString(key)
 
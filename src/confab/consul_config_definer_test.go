@@ -169,6 +169,142 @@ var _ = Describe("ConsulConfigDefiner", func() {
 			})
 		})
 
+		Describe("advertise_addr", func() {
+			It("defaults to nil", func() {
+				Expect(consulConfig.AdvertiseAddr).To(BeNil())
+			})
+
+			Context("when `consul.agent.advertise_addr` is provided", func() {
+				It("uses that value", func() {
+					consulConfig = confab.GenerateConfiguration(confab.Config{
+						Consul: confab.ConfigConsul{
+							Agent: confab.ConfigConsulAgent{
+								AdvertiseAddr: "203.0.113.10",
+							},
+						},
+					})
+					Expect(consulConfig.AdvertiseAddr).NotTo(BeNil())
+					Expect(*consulConfig.AdvertiseAddr).To(Equal("203.0.113.10"))
+				})
+			})
+		})
+
+		Describe("advertise_addr_wan", func() {
+			It("defaults to nil", func() {
+				Expect(consulConfig.AdvertiseAddrWAN).To(BeNil())
+			})
+
+			Context("when `consul.agent.advertise_addr_wan` is provided", func() {
+				It("uses that value", func() {
+					consulConfig = confab.GenerateConfiguration(confab.Config{
+						Consul: confab.ConfigConsul{
+							Agent: confab.ConfigConsulAgent{
+								AdvertiseAddrWAN: "198.51.100.10",
+							},
+						},
+					})
+					Expect(consulConfig.AdvertiseAddrWAN).NotTo(BeNil())
+					Expect(*consulConfig.AdvertiseAddrWAN).To(Equal("198.51.100.10"))
+				})
+			})
+		})
+
+		Describe("client_addr", func() {
+			It("defaults to nil", func() {
+				Expect(consulConfig.ClientAddr).To(BeNil())
+			})
+
+			Context("when `consul.agent.client_addr` is provided", func() {
+				It("uses that value", func() {
+					consulConfig = confab.GenerateConfiguration(confab.Config{
+						Consul: confab.ConfigConsul{
+							Agent: confab.ConfigConsulAgent{
+								ClientAddr: "0.0.0.0",
+							},
+						},
+					})
+					Expect(consulConfig.ClientAddr).NotTo(BeNil())
+					Expect(*consulConfig.ClientAddr).To(Equal("0.0.0.0"))
+				})
+			})
+		})
+
+		Describe("serf_lan_bind", func() {
+			It("defaults to nil", func() {
+				Expect(consulConfig.SerfLANBind).To(BeNil())
+			})
+
+			Context("when `consul.agent.serf_lan_bind` is provided", func() {
+				It("uses that value", func() {
+					consulConfig = confab.GenerateConfiguration(confab.Config{
+						Consul: confab.ConfigConsul{
+							Agent: confab.ConfigConsulAgent{
+								SerfLANBind: "10.0.1.5",
+							},
+						},
+					})
+					Expect(consulConfig.SerfLANBind).NotTo(BeNil())
+					Expect(*consulConfig.SerfLANBind).To(Equal("10.0.1.5"))
+				})
+			})
+		})
+
+		Describe("watches", func() {
+			It("defaults to nil", func() {
+				Expect(consulConfig.Watches).To(BeNil())
+			})
+
+			Context("when `consul.agent.watches` is provided", func() {
+				It("uses those values", func() {
+					watches := []confab.ConsulConfigWatch{
+						{Type: "keyprefix", Prefix: "haproxy/", Handler: "/var/vcap/jobs/haproxy/bin/reload"},
+						{Type: "service", Service: "router", PassingOnly: true, Handler: "/var/vcap/jobs/haproxy/bin/reload"},
+					}
+					consulConfig = confab.GenerateConfiguration(confab.Config{
+						Consul: confab.ConfigConsul{
+							Agent: confab.ConfigConsulAgent{
+								Watches: watches,
+							},
+						},
+					})
+					Expect(consulConfig.Watches).To(Equal(watches))
+				})
+			})
+		})
+
+		Describe("connect", func() {
+			It("defaults to nil", func() {
+				Expect(consulConfig.Connect).To(BeNil())
+				Expect(consulConfig.Ports.GRPC).To(Equal(0))
+			})
+
+			Context("when `consul.agent.connect.enabled` is true", func() {
+				It("enables connect with the configured CA and opens the grpc port", func() {
+					consulConfig = confab.GenerateConfiguration(confab.Config{
+						Consul: confab.ConfigConsul{
+							Agent: confab.ConfigConsulAgent{
+								Connect: confab.ConfigConsulAgentConnect{
+									Enabled:    true,
+									CAProvider: "vault",
+									CAConfig: map[string]interface{}{
+										"address": "https://vault.service.cf.internal:8200",
+									},
+								},
+							},
+						},
+					})
+					Expect(consulConfig.Connect).To(Equal(&confab.ConsulConfigConnect{
+						Enabled:    true,
+						CAProvider: "vault",
+						CAConfig: map[string]interface{}{
+							"address": "https://vault.service.cf.internal:8200",
+						},
+					}))
+					Expect(consulConfig.Ports.GRPC).To(Equal(8502))
+				})
+			})
+		})
+
 		Describe("disable_remote_exec", func() {
 			It("defaults to true", func() {
 				Expect(consulConfig.DisableRemoteExec).To(BeTrue())
@@ -426,6 +562,86 @@ var _ = Describe("ConsulConfigDefiner", func() {
 					})
 					Expect(consulConfig.BootstrapExpect).NotTo(BeNil())
 					Expect(*consulConfig.BootstrapExpect).To(Equal(3))
+					Expect(consulConfig.Bootstrap).To(BeNil())
+				})
+
+				Context("when `consul.agent.bootstrap_mode` is `single`", func() {
+					It("bootstraps the server on its own", func() {
+						consulConfig = confab.GenerateConfiguration(confab.Config{
+							Consul: confab.ConfigConsul{
+								Agent: confab.ConfigConsulAgent{
+									Mode:          "server",
+									BootstrapMode: "single",
+								},
+							},
+						})
+						Expect(consulConfig.BootstrapExpect).To(BeNil())
+						Expect(consulConfig.Bootstrap).NotTo(BeNil())
+						Expect(*consulConfig.Bootstrap).To(BeTrue())
+					})
+				})
+
+				Context("when `consul.agent.bootstrap_mode` is `join`", func() {
+					It("neither bootstraps nor expects servers", func() {
+						consulConfig = confab.GenerateConfiguration(confab.Config{
+							Consul: confab.ConfigConsul{
+								Agent: confab.ConfigConsulAgent{
+									Mode:          "server",
+									BootstrapMode: "join",
+									Servers: confab.ConfigConsulAgentServers{
+										LAN: []string{"first-server", "second-server", "third-server", "fourth-server"},
+									},
+								},
+							},
+						})
+						Expect(consulConfig.BootstrapExpect).To(BeNil())
+						Expect(consulConfig.Bootstrap).To(BeNil())
+					})
+				})
+			})
+		})
+
+		Describe("node_meta", func() {
+			It("defaults to nil", func() {
+				Expect(consulConfig.NodeMeta).To(BeNil())
+			})
+
+			Context("when `consul.agent.node_meta` is true", func() {
+				It("describes the bosh instance", func() {
+					consulConfig = confab.GenerateConfiguration(confab.Config{
+						Node: confab.ConfigNode{
+							Name:       "consul_z1",
+							Index:      0,
+							Deployment: "cf",
+							AZ:         "z1",
+							ID:         "some-instance-id",
+							Bootstrap:  true,
+						},
+						Consul: confab.ConfigConsul{
+							Agent: confab.ConfigConsulAgent{
+								NodeMeta: true,
+							},
+						},
+					})
+					Expect(consulConfig.NodeMeta).To(Equal(map[string]string{
+						"deployment":  "cf",
+						"az":          "z1",
+						"instance_id": "some-instance-id",
+						"bootstrap":   "true",
+					}))
+				})
+
+				It("omits the values that are not known", func() {
+					consulConfig = confab.GenerateConfiguration(confab.Config{
+						Consul: confab.ConfigConsul{
+							Agent: confab.ConfigConsulAgent{
+								NodeMeta: true,
+							},
+						},
+					})
+					Expect(consulConfig.NodeMeta).To(Equal(map[string]string{
+						"bootstrap": "false",
+					}))
 				})
 			})
 		})
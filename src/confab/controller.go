@@ -3,14 +3,25 @@ package confab
 import (
 	"encoding/json"
 	"errors"
+	"fmt"
 	"io/ioutil"
+	"net"
 	"os"
 	"path/filepath"
+	"sort"
 	"time"
 
+	"confab/agent"
+
+	"github.com/hashicorp/consul/api"
 	"github.com/pivotal-golang/lager"
 )
 
+const (
+	readinessDNSName = "consul.service.cf.internal"
+	drainReason      = "confab is draining the node before stopping the agent"
+)
+
 type agentRunner interface {
 	Run() error
 	Stop() error
@@ -22,13 +33,24 @@ type agentRunner interface {
 type agentClient interface {
 	VerifyJoined() error
 	VerifySynced() error
-	IsLastNode() (bool, error)
+	VerifyLeader() error
+	VerifyServices([]string) error
+	VerifyDNS(string) error
+	IsLastNode() (agent.LastNodeResult, error)
 	SetKeys([]string) error
 	Leave() error
+	EnableMaintenance(serviceID, reason string) error
+	DisableMaintenance(serviceID string) error
+	NodeMaintenanceReason() (string, error)
+	ReconcilePreparedQueries([]api.PreparedQueryDefinition) error
+	SeedKV(pairs []api.KVPair, enforce bool) error
+	RaftPeers() ([]string, error)
+	RemovePeer(peer string) error
+	ProtocolCompatibility(node string) (agent.Compatibility, error)
 }
 
 type serviceDefiner interface {
-	GenerateDefinitions(Config) []ServiceDefinition
+	GenerateDefinitions(Config) ([]ServiceDefinition, error)
 	WriteDefinitions(string, []ServiceDefinition) error
 }
 
@@ -55,6 +77,11 @@ type Controller struct {
 }
 
 func (c Controller) BootAgent(timeout Timeout) error {
+	if err := validateProtocolPolicy(c.Config.Consul.Agent.ProtocolPolicy); err != nil {
+		c.Logger.Error("controller.boot-agent.validate-protocol-policy.failed", err)
+		return err
+	}
+
 	c.Logger.Info("controller.boot-agent.run")
 	err := c.AgentRunner.Run()
 	if err != nil {
@@ -69,10 +96,92 @@ func (c Controller) BootAgent(timeout Timeout) error {
 		return err
 	}
 
+	if err := c.endDrain(); err != nil {
+		return err
+	}
+
+	if err := c.checkProtocol(); err != nil {
+		return err
+	}
+
 	c.Logger.Info("controller.boot-agent.success")
 	return nil
 }
 
+// endDrain takes the node out of the maintenance mode DrainAgent put it in.
+// Consul keeps maintenance mode across restarts, so without this a drained
+// node would come back out of DNS. Maintenance an operator enabled with a
+// reason of their own is left alone.
+func (c Controller) endDrain() error {
+	c.Logger.Info("controller.boot-agent.end-drain")
+	reason, err := c.AgentClient.NodeMaintenanceReason()
+	if err != nil {
+		c.Logger.Error("controller.boot-agent.end-drain.failed", err)
+		return err
+	}
+
+	if reason != drainReason {
+		return nil
+	}
+
+	c.Logger.Info("controller.boot-agent.end-drain.disable-maintenance")
+	if err := c.AgentClient.DisableMaintenance(""); err != nil {
+		c.Logger.Error("controller.boot-agent.end-drain.disable-maintenance.failed", err)
+		return err
+	}
+
+	return nil
+}
+
+func validateProtocolPolicy(policy string) error {
+	switch policy {
+	case "", "warn", "fail", "ignore":
+		return nil
+	default:
+		return fmt.Errorf(`invalid protocol_policy %q, must be "warn", "fail" or "ignore"`, policy)
+	}
+}
+
+// checkProtocol compares the protocols the local agent speaks with the rest
+// of the cluster. Mismatches are logged, and only stop the boot when the
+// policy is "fail".
+func (c Controller) checkProtocol() error {
+	policy := c.Config.Consul.Agent.ProtocolPolicy
+	if policy == "ignore" {
+		return nil
+	}
+
+	node := NodeName(c.Config.Node)
+	c.Logger.Info("controller.boot-agent.check-protocol", lager.Data{
+		"node":   node,
+		"policy": policy,
+	})
+
+	compatibility, err := c.AgentClient.ProtocolCompatibility(node)
+	if err != nil {
+		c.Logger.Error("controller.boot-agent.check-protocol.failed", err)
+		if policy == "fail" {
+			return err
+		}
+		return nil
+	}
+
+	if !compatibility.Compatible {
+		err := fmt.Errorf("protocol %d (serf %d) is outside the cluster range %d-%d (serf %d-%d)",
+			compatibility.Local.Protocol.Current, compatibility.Local.SerfProtocol.Current,
+			compatibility.ProtocolRange.Min, compatibility.ProtocolRange.Max,
+			compatibility.SerfProtocolRange.Min, compatibility.SerfProtocolRange.Max)
+		c.Logger.Error("controller.boot-agent.check-protocol.incompatible", err, lager.Data{
+			"spread": compatibility.Spread,
+		})
+		if policy == "fail" {
+			return err
+		}
+	}
+
+	return nil
+}
+
 func (c Controller) callWithTimeout(timeout Timeout, f func() error) error {
 	for {
 		select {
@@ -98,7 +207,13 @@ func (c Controller) ConfigureServer(timeout Timeout) error {
 		return err
 	}
 
-	if lastNode {
+	c.Logger.Info("controller.configure-server.is-last-node.result", lager.Data{
+		"is_last_node": lastNode.IsLastNode,
+		"missing":      lastNode.Missing,
+		"unexpected":   lastNode.Unexpected,
+	})
+
+	if lastNode.IsLastNode {
 		c.Logger.Info("controller.configure-server.verify-synced")
 		if err := c.callWithTimeout(timeout, c.AgentClient.VerifySynced); err != nil {
 			c.Logger.Error("controller.configure-server.verify-synced.failed", err)
@@ -126,6 +241,38 @@ func (c Controller) ConfigureServer(timeout Timeout) error {
 		}
 	}
 
+	if len(c.Config.Consul.PreparedQueries) > 0 || len(c.Config.Consul.KVSeed) > 0 || c.Config.Consul.Agent.ReconcilePeers {
+		c.Logger.Info("controller.configure-server.verify-leader")
+		if err := c.callWithTimeout(timeout, c.AgentClient.VerifyLeader); err != nil {
+			c.Logger.Error("controller.configure-server.verify-leader.failed", err)
+			return err
+		}
+	}
+
+	if c.Config.Consul.Agent.ReconcilePeers {
+		if err := c.reconcilePeers(timeout); err != nil {
+			return err
+		}
+	}
+
+	if len(c.Config.Consul.KVSeed) > 0 {
+		if err := c.seedKV(timeout); err != nil {
+			return err
+		}
+	}
+
+	if len(c.Config.Consul.PreparedQueries) > 0 {
+		if err := c.reconcilePreparedQueries(timeout); err != nil {
+			return err
+		}
+	}
+
+	if c.Config.Consul.Agent.WaitForServices {
+		if err := c.waitForServices(timeout, "controller.configure-server"); err != nil {
+			return err
+		}
+	}
+
 	if err := c.AgentRunner.WritePID(); err != nil {
 		c.Logger.Error("controller.configure-server.write-pid.failed", err)
 		return err
@@ -135,15 +282,283 @@ func (c Controller) ConfigureServer(timeout Timeout) error {
 	return nil
 }
 
-func (c Controller) ConfigureClient() error {
-	err := c.AgentRunner.WritePID()
+func (c Controller) ConfigureClient(timeout Timeout) error {
+	if c.Config.Consul.Agent.ClientReadiness.Enabled {
+		if err := c.verifyClientReadiness(timeout); err != nil {
+			return err
+		}
+	} else if c.Config.Consul.Agent.WaitForServices {
+		if err := c.waitForServices(timeout, "controller.configure-client"); err != nil {
+			return err
+		}
+	}
+
+	if err := c.AgentRunner.WritePID(); err != nil {
+		c.Logger.Error("controller.configure-client.write-pid.failed", err)
+		return err
+	}
+
+	c.Logger.Info("controller.configure-client.success")
+	return nil
+}
+
+func (c Controller) verifyClientReadiness(timeout Timeout) error {
+	c.Logger.Info("controller.configure-client.verify-leader")
+	if err := c.callWithTimeout(timeout, c.AgentClient.VerifyLeader); err != nil {
+		c.Logger.Error("controller.configure-client.verify-leader.failed", err)
+		return err
+	}
+
+	if err := c.waitForServices(timeout, "controller.configure-client"); err != nil {
+		return err
+	}
+
+	if c.Config.Consul.Agent.ClientReadiness.DNSCheck {
+		c.Logger.Info("controller.configure-client.verify-dns", lager.Data{
+			"name": readinessDNSName,
+		})
+		if err := c.callWithTimeout(timeout, func() error {
+			return c.AgentClient.VerifyDNS(readinessDNSName)
+		}); err != nil {
+			c.Logger.Error("controller.configure-client.verify-dns.failed", err)
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (c Controller) reconcilePeers(timeout Timeout) error {
+	expected := map[string]bool{}
+	for _, member := range c.Config.Consul.Agent.Servers.LAN {
+		if net.ParseIP(member) == nil {
+			c.Logger.Info("controller.configure-server.reconcile-peers.skipped", lager.Data{
+				"reason": fmt.Sprintf("lan server %q is not an ip address", member),
+			})
+			return nil
+		}
+		expected[member] = true
+	}
+
+	c.Logger.Info("controller.configure-server.reconcile-peers.raft-peers")
+	peers, err := c.AgentClient.RaftPeers()
+	if err != nil {
+		c.Logger.Error("controller.configure-server.reconcile-peers.raft-peers.failed", err)
+		return err
+	}
+
+	var stale []string
+	for _, peer := range peers {
+		host := peer
+		if h, _, err := net.SplitHostPort(peer); err == nil {
+			host = h
+		}
+
+		if !expected[host] {
+			stale = append(stale, peer)
+		}
+	}
+
+	c.Logger.Info("controller.configure-server.reconcile-peers", lager.Data{
+		"peers": peers,
+		"stale": stale,
+	})
+
+	// remove one peer at a time and make sure the cluster still has a leader
+	// before touching the next one
+	for _, peer := range stale {
+		c.Logger.Info("controller.configure-server.reconcile-peers.remove-peer", lager.Data{
+			"peer": peer,
+		})
+		if err := c.AgentClient.RemovePeer(peer); err != nil {
+			c.Logger.Error("controller.configure-server.reconcile-peers.remove-peer.failed", err, lager.Data{
+				"peer": peer,
+			})
+			return err
+		}
+
+		if err := c.callWithTimeout(timeout, c.AgentClient.VerifyLeader); err != nil {
+			c.Logger.Error("controller.configure-server.reconcile-peers.verify-leader.failed", err, lager.Data{
+				"peer": peer,
+			})
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (c Controller) seedKV(timeout Timeout) error {
+	mode := c.Config.Consul.KVSeedMode
+	if mode == "" {
+		mode = "create_missing"
+	}
+
+	if mode != "create_missing" && mode != "enforce" {
+		err := fmt.Errorf("invalid kv_seed_mode %q, must be \"create_missing\" or \"enforce\"", mode)
+		c.Logger.Error("controller.configure-server.seed-kv.invalid-mode", err)
+		return err
+	}
+
+	var keys []string
+	for key := range c.Config.Consul.KVSeed {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	pairs := []api.KVPair{}
+	for _, key := range keys {
+		entry := c.Config.Consul.KVSeed[key]
+		pairs = append(pairs, api.KVPair{
+			Key:   key,
+			Value: []byte(entry.Value),
+			Flags: entry.Flags,
+		})
+	}
+
+	c.Logger.Info("controller.configure-server.seed-kv", lager.Data{
+		"keys": keys,
+		"mode": mode,
+	})
+	if err := c.callWithTimeout(timeout, func() error {
+		return c.AgentClient.SeedKV(pairs, mode == "enforce")
+	}); err != nil {
+		c.Logger.Error("controller.configure-server.seed-kv.failed", err)
+		return err
+	}
+
+	return nil
+}
+
+func (c Controller) reconcilePreparedQueries(timeout Timeout) error {
+	queries := c.preparedQueries()
+	c.Logger.Info("controller.configure-server.reconcile-prepared-queries", lager.Data{
+		"count": len(queries),
+	})
+	if err := c.callWithTimeout(timeout, func() error {
+		return c.AgentClient.ReconcilePreparedQueries(queries)
+	}); err != nil {
+		c.Logger.Error("controller.configure-server.reconcile-prepared-queries.failed", err)
+		return err
+	}
+
+	return nil
+}
+
+func (c Controller) preparedQueries() []api.PreparedQueryDefinition {
+	queries := []api.PreparedQueryDefinition{}
+	for _, query := range c.Config.Consul.PreparedQueries {
+		queries = append(queries, api.PreparedQueryDefinition{
+			Name: query.Name,
+			Service: api.ServiceQuery{
+				Service: query.Service,
+				Failover: api.QueryDatacenterOptions{
+					NearestN:    query.Failover.NearestN,
+					Datacenters: query.Failover.Datacenters,
+				},
+				OnlyPassing: query.OnlyPassing,
+				Tags:        query.Tags,
+			},
+			DNS: api.QueryDNSOptions{
+				TTL: query.DNSTTL,
+			},
+		})
+	}
+
+	return queries
+}
+
+func (c Controller) waitForServices(timeout Timeout, action string) error {
+	serviceIDs, err := c.serviceIDs()
 	if err != nil {
+		c.Logger.Error(action+".generate-definitions.failed", err)
+		return err
+	}
+
+	c.Logger.Info(action+".verify-services", lager.Data{
+		"services": serviceIDs,
+	})
+	if err := c.callWithTimeout(timeout, func() error {
+		return c.AgentClient.VerifyServices(serviceIDs)
+	}); err != nil {
+		c.Logger.Error(action+".verify-services.failed", err, lager.Data{
+			"services": serviceIDs,
+		})
 		return err
 	}
 
 	return nil
 }
 
+func (c Controller) serviceIDs() ([]string, error) {
+	definitions, err := c.ServiceDefiner.GenerateDefinitions(c.Config)
+	if err != nil {
+		return nil, err
+	}
+
+	serviceIDs := []string{}
+	for _, definition := range definitions {
+		if definition.ID != "" {
+			serviceIDs = append(serviceIDs, definition.ID)
+		} else {
+			serviceIDs = append(serviceIDs, definition.Name)
+		}
+	}
+
+	return serviceIDs, nil
+}
+
+func (c Controller) EnableMaintenance(serviceID, reason string) error {
+	c.Logger.Info("controller.enable-maintenance", lager.Data{
+		"service": serviceID,
+		"reason":  reason,
+	})
+	if err := c.AgentClient.EnableMaintenance(serviceID, reason); err != nil {
+		c.Logger.Error("controller.enable-maintenance.failed", err, lager.Data{
+			"service": serviceID,
+		})
+		return err
+	}
+
+	c.Logger.Info("controller.enable-maintenance.success")
+	return nil
+}
+
+func (c Controller) DisableMaintenance(serviceID string) error {
+	c.Logger.Info("controller.disable-maintenance", lager.Data{
+		"service": serviceID,
+	})
+	if err := c.AgentClient.DisableMaintenance(serviceID); err != nil {
+		c.Logger.Error("controller.disable-maintenance.failed", err, lager.Data{
+			"service": serviceID,
+		})
+		return err
+	}
+
+	c.Logger.Info("controller.disable-maintenance.success")
+	return nil
+}
+
+func (c Controller) DrainAgent() {
+	drainTime := time.Duration(c.Config.Consul.Agent.DrainTimeInSeconds) * time.Second
+	if drainTime <= 0 {
+		return
+	}
+
+	c.Logger.Info("controller.drain-agent.enable-maintenance")
+	if err := c.AgentClient.EnableMaintenance("", drainReason); err != nil {
+		c.Logger.Error("controller.drain-agent.enable-maintenance.failed", err)
+		return
+	}
+
+	c.Logger.Info("controller.drain-agent.wait", lager.Data{
+		"drain_time": drainTime.String(),
+	})
+	c.SyncRetryClock.Sleep(drainTime)
+
+	c.Logger.Info("controller.drain-agent.success")
+}
+
 func (c Controller) StopAgent() {
 	c.Logger.Info("controller.stop-agent.leave")
 	if err := c.AgentClient.Leave(); err != nil {
@@ -170,7 +585,11 @@ func (c Controller) StopAgent() {
 
 func (c Controller) WriteServiceDefinitions() error {
 	c.Logger.Info("controller.write-service-definitions.generate-definitions")
-	definitions := c.ServiceDefiner.GenerateDefinitions(c.Config)
+	definitions, err := c.ServiceDefiner.GenerateDefinitions(c.Config)
+	if err != nil {
+		c.Logger.Error("controller.write-service-definitions.generate-definitions.failed", err)
+		return err
+	}
 
 	c.Logger.Info("controller.write-service-definitions.write")
 	if err := c.ServiceDefiner.WriteDefinitions(c.ConfigDir, definitions); err != nil {
@@ -186,6 +605,35 @@ func (c Controller) WriteConsulConfig() error {
 	c.Logger.Info("controller.write-consul-config.generate-configuration")
 	consulConfig := GenerateConfiguration(c.Config)
 
+	extraConfig := c.Config.Consul.Agent.ExtraConfig
+	if err := validateExtraConfig(extraConfig); err != nil {
+		c.Logger.Error("controller.write-consul-config.validate-extra-configuration.failed", err)
+		return err
+	}
+
+	if err := validateWatches(c.Config.Consul.Agent.Watches); err != nil {
+		c.Logger.Error("controller.write-consul-config.validate-watches.failed", err)
+		return err
+	}
+
+	if err := validatePreparedQueries(c.Config.Consul.PreparedQueries); err != nil {
+		c.Logger.Error("controller.write-consul-config.validate-prepared-queries.failed", err)
+		return err
+	}
+
+	if err := validateBootstrap(c.Config.Consul.Agent); err != nil {
+		c.Logger.Error("controller.write-consul-config.validate-bootstrap.failed", err)
+		return err
+	}
+
+	if consulConfig.BootstrapExpect != nil && *consulConfig.BootstrapExpect%2 == 0 {
+		err := fmt.Errorf("an even bootstrap_expect of %d tolerates no more failures than %d servers", *consulConfig.BootstrapExpect, *consulConfig.BootstrapExpect-1)
+		c.Logger.Error("controller.write-consul-config.bootstrap-expect.even-count", err, lager.Data{
+			"servers":         *consulConfig.BootstrapExpect,
+			"fault_tolerance": (*consulConfig.BootstrapExpect - 1) / 2,
+		})
+	}
+
 	data, err := json.Marshal(&consulConfig)
 	if err != nil {
 		return err
@@ -200,6 +648,33 @@ func (c Controller) WriteConsulConfig() error {
 		return err
 	}
 
+	extraConfigPath := filepath.Join(c.Config.Path.ConsulConfigDir, "extra-config.json")
+	if len(extraConfig) > 0 {
+		data, err = json.Marshal(extraConfig)
+		if err != nil {
+			return err
+		}
+
+		c.Logger.Info("controller.write-consul-config.write-extra-configuration", lager.Data{
+			"config": extraConfig,
+		})
+		err = ioutil.WriteFile(extraConfigPath, data, os.ModePerm)
+		if err != nil {
+			c.Logger.Error("controller.write-consul-config.write-extra-configuration.failed", errors.New(err.Error()))
+			return err
+		}
+	} else if _, err := os.Stat(extraConfigPath); err == nil {
+		// consul loads every file in the config dir, so extra config that has
+		// been cleared from the manifest has to go
+		c.Logger.Info("controller.write-consul-config.remove-extra-configuration", lager.Data{
+			"path": extraConfigPath,
+		})
+		if err := removeFile(extraConfigPath); err != nil {
+			c.Logger.Error("controller.write-consul-config.remove-extra-configuration.failed", errors.New(err.Error()))
+			return err
+		}
+	}
+
 	c.Logger.Info("controller.write-consul-config.success")
 	return nil
 }
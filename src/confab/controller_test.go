@@ -2,7 +2,9 @@ package confab_test
 
 import (
 	"confab"
+	"confab/agent"
 	"confab/fakes"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"io/ioutil"
@@ -10,6 +12,7 @@ import (
 	"path/filepath"
 	"time"
 
+	"github.com/hashicorp/consul/api"
 	"github.com/pivotal-golang/lager"
 
 	. "github.com/pivotal-cf-experimental/gomegamatchers"
@@ -35,6 +38,7 @@ var _ = Describe("Controller", func() {
 		agentClient = &fakes.AgentClient{}
 		agentClient.VerifyJoinedCalls.Returns.Errors = []error{nil}
 		agentClient.VerifySyncedCalls.Returns.Errors = []error{nil}
+		agentClient.ProtocolCompatibilityCall.Returns.Compatibility = agent.Compatibility{Compatible: true}
 
 		agentRunner = &fakes.AgentRunner{}
 		agentRunner.RunCalls.Returns.Errors = []error{nil}
@@ -98,6 +102,9 @@ var _ = Describe("Controller", func() {
 				"cert_file": "/var/vcap/jobs/consul_agent/config/certs/agent.crt"
 			}`))
 
+			_, err = os.Stat(filepath.Join(configDir, "extra-config.json"))
+			Expect(os.IsNotExist(err)).To(BeTrue())
+
 			Expect(logger.Messages).To(ContainSequence([]fakes.LoggerMessage{
 				{
 					Action: "controller.write-consul-config.generate-configuration",
@@ -114,6 +121,271 @@ var _ = Describe("Controller", func() {
 			}))
 		})
 
+		Context("when extra config is provided", func() {
+			BeforeEach(func() {
+				controller.Config.Consul.Agent.ExtraConfig = map[string]interface{}{
+					"log_level": "warn",
+					"dns_config": map[string]interface{}{
+						"allow_stale": true,
+					},
+				}
+			})
+
+			It("writes the extra config to a separate file in the consul_config dir", func() {
+				Expect(controller.WriteConsulConfig()).To(Succeed())
+
+				buf, err := ioutil.ReadFile(filepath.Join(configDir, "extra-config.json"))
+				Expect(err).NotTo(HaveOccurred())
+				Expect(buf).To(MatchJSON(`{
+					"log_level": "warn",
+					"dns_config": {
+						"allow_stale": true
+					}
+				}`))
+
+				Expect(logger.Messages).To(ContainSequence([]fakes.LoggerMessage{
+					{
+						Action: "controller.write-consul-config.write-extra-configuration",
+						Data: []lager.Data{{
+							"config": controller.Config.Consul.Agent.ExtraConfig,
+						}},
+					},
+					{
+						Action: "controller.write-consul-config.success",
+					},
+				}))
+			})
+
+			Context("when the extra config is cleared later", func() {
+				It("removes the extra config file", func() {
+					Expect(controller.WriteConsulConfig()).To(Succeed())
+
+					controller.Config.Consul.Agent.ExtraConfig = nil
+					Expect(controller.WriteConsulConfig()).To(Succeed())
+
+					extraConfigPath := filepath.Join(configDir, "extra-config.json")
+					_, err := os.Stat(extraConfigPath)
+					Expect(os.IsNotExist(err)).To(BeTrue())
+
+					Expect(logger.Messages).To(ContainSequence([]fakes.LoggerMessage{
+						{
+							Action: "controller.write-consul-config.remove-extra-configuration",
+							Data: []lager.Data{{
+								"path": extraConfigPath,
+							}},
+						},
+						{
+							Action: "controller.write-consul-config.success",
+						},
+					}))
+				})
+
+				It("returns an error when the file cannot be removed", func() {
+					Expect(controller.WriteConsulConfig()).To(Succeed())
+
+					confab.SetRemoveFile(func(string) error {
+						return errors.New("remove error")
+					})
+					defer confab.ResetRemoveFile()
+
+					controller.Config.Consul.Agent.ExtraConfig = nil
+					Expect(controller.WriteConsulConfig()).To(MatchError("remove error"))
+					Expect(logger.Messages).To(ContainElement(fakes.LoggerMessage{
+						Action: "controller.write-consul-config.remove-extra-configuration.failed",
+						Error:  errors.New("remove error"),
+					}))
+				})
+			})
+
+			Context("when the extra config overrides keys managed by confab", func() {
+				It("returns an error without writing any config", func() {
+					controller.Config.Consul.Agent.ExtraConfig["server"] = true
+					controller.Config.Consul.Agent.ExtraConfig["bootstrap_expect"] = 1
+
+					err := controller.WriteConsulConfig()
+					Expect(err).To(MatchError("extra_config cannot override keys managed by confab: bootstrap_expect, server"))
+
+					_, err = os.Stat(filepath.Join(configDir, "config.json"))
+					Expect(os.IsNotExist(err)).To(BeTrue())
+					_, err = os.Stat(filepath.Join(configDir, "extra-config.json"))
+					Expect(os.IsNotExist(err)).To(BeTrue())
+
+					Expect(logger.Messages).To(ContainSequence([]fakes.LoggerMessage{
+						{
+							Action: "controller.write-consul-config.validate-extra-configuration.failed",
+							Error:  errors.New("extra_config cannot override keys managed by confab: bootstrap_expect, server"),
+						},
+					}))
+				})
+
+				It("rejects the addresses and ports confab relies on", func() {
+					controller.Config.Consul.Agent.ExtraConfig["ports"] = map[string]interface{}{"http": 8501}
+					controller.Config.Consul.Agent.ExtraConfig["client_addr"] = "0.0.0.0"
+
+					Expect(controller.WriteConsulConfig()).To(MatchError("extra_config cannot override keys managed by confab: client_addr, ports"))
+				})
+			})
+		})
+
+		Context("when watches are provided", func() {
+			It("renders them into the config file", func() {
+				controller.Config.Consul.Agent.Watches = []confab.ConsulConfigWatch{
+					{Type: "key", Key: "haproxy/config", Handler: "/var/vcap/jobs/haproxy/bin/reload"},
+				}
+
+				Expect(controller.WriteConsulConfig()).To(Succeed())
+
+				buf, err := ioutil.ReadFile(filepath.Join(configDir, "config.json"))
+				Expect(err).NotTo(HaveOccurred())
+
+				var config map[string]interface{}
+				Expect(json.Unmarshal(buf, &config)).To(Succeed())
+				Expect(config["watches"]).To(Equal([]interface{}{
+					map[string]interface{}{
+						"type":    "key",
+						"key":     "haproxy/config",
+						"handler": "/var/vcap/jobs/haproxy/bin/reload",
+					},
+				}))
+			})
+
+			Context("when a watch has an unknown type", func() {
+				It("returns an error without writing any config", func() {
+					controller.Config.Consul.Agent.Watches = []confab.ConsulConfigWatch{
+						{Type: "event", Name: "deploy", Handler: "/bin/true"},
+						{Type: "banana", Handler: "/bin/true"},
+					}
+
+					Expect(controller.WriteConsulConfig()).To(MatchError(`watch 1 has unknown type "banana"`))
+
+					_, err := os.Stat(filepath.Join(configDir, "config.json"))
+					Expect(os.IsNotExist(err)).To(BeTrue())
+
+					Expect(logger.Messages).To(ContainSequence([]fakes.LoggerMessage{
+						{
+							Action: "controller.write-consul-config.validate-watches.failed",
+							Error:  errors.New(`watch 1 has unknown type "banana"`),
+						},
+					}))
+				})
+			})
+
+			Context("when a prepared query is invalid", func() {
+				It("returns an error without writing any config", func() {
+					controller.Config.Consul.PreparedQueries = []confab.ConfigConsulPreparedQuery{
+						{Name: "router", Service: "gorouter"},
+						{Service: "uaa"},
+					}
+
+					Expect(controller.WriteConsulConfig()).To(MatchError("prepared query 1 has no name"))
+
+					_, err := os.Stat(filepath.Join(configDir, "config.json"))
+					Expect(os.IsNotExist(err)).To(BeTrue())
+
+					Expect(logger.Messages).To(ContainElement(fakes.LoggerMessage{
+						Action: "controller.write-consul-config.validate-prepared-queries.failed",
+						Error:  errors.New("prepared query 1 has no name"),
+					}))
+				})
+
+				It("rejects queries declared twice", func() {
+					controller.Config.Consul.PreparedQueries = []confab.ConfigConsulPreparedQuery{
+						{Name: "router", Service: "gorouter"},
+						{Name: "router", Service: "gorouter"},
+					}
+
+					Expect(controller.WriteConsulConfig()).To(MatchError(`prepared query "router" is declared more than once`))
+				})
+
+				It("rejects queries without a service", func() {
+					controller.Config.Consul.PreparedQueries = []confab.ConfigConsulPreparedQuery{
+						{Name: "router"},
+					}
+
+					Expect(controller.WriteConsulConfig()).To(MatchError(`prepared query "router" has no service`))
+				})
+
+				It("rejects an invalid dns ttl", func() {
+					controller.Config.Consul.PreparedQueries = []confab.ConfigConsulPreparedQuery{
+						{Name: "router", Service: "gorouter", DNSTTL: "ten seconds"},
+					}
+
+					Expect(controller.WriteConsulConfig()).To(MatchError(`prepared query "router" has an invalid dns_ttl "ten seconds"`))
+				})
+			})
+
+			Context("when a watch has no handler", func() {
+				It("returns an error", func() {
+					controller.Config.Consul.Agent.Watches = []confab.ConsulConfigWatch{
+						{Type: "nodes"},
+					}
+
+					Expect(controller.WriteConsulConfig()).To(MatchError(`watch 0 of type "nodes" is missing a handler`))
+				})
+			})
+
+			Context("when a watch is missing a parameter required by its type", func() {
+				It("returns an error", func() {
+					controller.Config.Consul.Agent.Watches = []confab.ConsulConfigWatch{
+						{Type: "keyprefix", Handler: "/bin/true"},
+					}
+
+					Expect(controller.WriteConsulConfig()).To(MatchError(`watch 0 of type "keyprefix" is missing "prefix"`))
+				})
+			})
+		})
+
+		Context("when bootstrapping servers", func() {
+			BeforeEach(func() {
+				controller.Config.Consul.Agent.Mode = "server"
+			})
+
+			It("warns when the number of servers is even", func() {
+				controller.Config.Consul.Agent.Servers.LAN = []string{"server-1", "server-2", "server-3", "server-4"}
+
+				Expect(controller.WriteConsulConfig()).To(Succeed())
+				Expect(logger.Messages).To(ContainElement(fakes.LoggerMessage{
+					Action: "controller.write-consul-config.bootstrap-expect.even-count",
+					Error:  errors.New("an even bootstrap_expect of 4 tolerates no more failures than 3 servers"),
+					Data: []lager.Data{{
+						"servers":         4,
+						"fault_tolerance": 1,
+					}},
+				}))
+			})
+
+			It("does not warn when the number of servers is odd", func() {
+				controller.Config.Consul.Agent.Servers.LAN = []string{"server-1", "server-2", "server-3"}
+
+				Expect(controller.WriteConsulConfig()).To(Succeed())
+				for _, message := range logger.Messages {
+					Expect(message.Action).NotTo(Equal("controller.write-consul-config.bootstrap-expect.even-count"))
+				}
+			})
+
+			It("returns an error for an unknown bootstrap mode", func() {
+				controller.Config.Consul.Agent.BootstrapMode = "eventually"
+
+				err := controller.WriteConsulConfig()
+				Expect(err).To(MatchError(`invalid bootstrap_mode "eventually", must be "expect", "single" or "join"`))
+
+				_, err = os.Stat(filepath.Join(configDir, "config.json"))
+				Expect(os.IsNotExist(err)).To(BeTrue())
+
+				Expect(logger.Messages).To(ContainElement(fakes.LoggerMessage{
+					Action: "controller.write-consul-config.validate-bootstrap.failed",
+					Error:  errors.New(`invalid bootstrap_mode "eventually", must be "expect", "single" or "join"`),
+				}))
+			})
+
+			It("returns an error when single mode is used with several servers", func() {
+				controller.Config.Consul.Agent.BootstrapMode = "single"
+				controller.Config.Consul.Agent.Servers.LAN = []string{"server-1", "server-2"}
+
+				Expect(controller.WriteConsulConfig()).To(MatchError(`bootstrap_mode "single" cannot be used with 2 lan servers`))
+			})
+		})
+
 		Context("failure cases", func() {
 			It("returns an error when the config file can't be written to", func() {
 				err := os.Chmod(configDir, 0000)
@@ -143,18 +415,168 @@ var _ = Describe("Controller", func() {
 
 	Describe("ConfigureClient", func() {
 		It("writes the pid file", func() {
-			err := controller.ConfigureClient()
+			err := controller.ConfigureClient(confab.NewTimeout(make(chan time.Time)))
 			Expect(err).NotTo(HaveOccurred())
 
 			Expect(agentRunner.WritePIDCall.CallCount).To(Equal(1))
+			Expect(agentClient.VerifyLeaderCalls.CallCount).To(Equal(0))
+			Expect(agentClient.VerifyServicesCalls.CallCount).To(Equal(0))
+			Expect(agentClient.VerifyDNSCalls.CallCount).To(Equal(0))
+		})
+
+		Context("when client readiness is enabled", func() {
+			BeforeEach(func() {
+				controller.Config.Consul.Agent.ClientReadiness.Enabled = true
+				agentClient.VerifyLeaderCalls.Returns.Errors = []error{nil}
+				agentClient.VerifyServicesCalls.Returns.Errors = []error{nil}
+				agentClient.VerifyDNSCalls.Returns.Errors = []error{nil}
+				serviceDefiner.GenerateDefinitionsCall.Returns.Definitions = []confab.ServiceDefinition{
+					{ServiceName: "router", Name: "gorouter"},
+					{ServiceName: "cloud_controller", Name: "cloud-controller", ID: "cc-id"},
+				}
+			})
+
+			It("waits for a leader and passing services before writing the pid file", func() {
+				Expect(controller.ConfigureClient(confab.NewTimeout(make(chan time.Time)))).To(Succeed())
+				Expect(agentClient.VerifyLeaderCalls.CallCount).To(Equal(1))
+				Expect(agentClient.VerifyServicesCalls.CallCount).To(Equal(1))
+				Expect(agentClient.VerifyServicesCalls.Receives.ServiceIDs).To(Equal([]string{"gorouter", "cc-id"}))
+				Expect(agentClient.VerifyDNSCalls.CallCount).To(Equal(0))
+				Expect(agentRunner.WritePIDCall.CallCount).To(Equal(1))
+				Expect(logger.Messages).To(ContainSequence([]fakes.LoggerMessage{
+					{
+						Action: "controller.configure-client.verify-leader",
+					},
+					{
+						Action: "controller.configure-client.verify-services",
+						Data: []lager.Data{{
+							"services": []string{"gorouter", "cc-id"},
+						}},
+					},
+					{
+						Action: "controller.configure-client.success",
+					},
+				}))
+			})
+
+			Context("when the dns check is enabled", func() {
+				It("queries the agent's dns interface", func() {
+					controller.Config.Consul.Agent.ClientReadiness.DNSCheck = true
+
+					Expect(controller.ConfigureClient(confab.NewTimeout(make(chan time.Time)))).To(Succeed())
+					Expect(agentClient.VerifyDNSCalls.CallCount).To(Equal(1))
+					Expect(agentClient.VerifyDNSCalls.Receives.Name).To(Equal("consul.service.cf.internal"))
+					Expect(agentRunner.WritePIDCall.CallCount).To(Equal(1))
+					Expect(logger.Messages).To(ContainSequence([]fakes.LoggerMessage{
+						{
+							Action: "controller.configure-client.verify-dns",
+							Data: []lager.Data{{
+								"name": "consul.service.cf.internal",
+							}},
+						},
+						{
+							Action: "controller.configure-client.success",
+						},
+					}))
+				})
+			})
+
+			Context("when the services are not passing at first", func() {
+				It("retries until they pass", func() {
+					agentClient.VerifyServicesCalls.Returns.Errors = []error{errors.New("critical"), errors.New("critical"), nil}
+
+					Expect(controller.ConfigureClient(confab.NewTimeout(make(chan time.Time)))).To(Succeed())
+					Expect(agentClient.VerifyServicesCalls.CallCount).To(Equal(3))
+					Expect(clock.SleepCall.CallCount).To(Equal(2))
+					Expect(agentRunner.WritePIDCall.CallCount).To(Equal(1))
+				})
+			})
+
+			Context("when a leader is never found within the timeout", func() {
+				It("returns an error without writing the pid file", func() {
+					timer := make(chan time.Time)
+					timeout := confab.NewTimeout(timer)
+					timer <- time.Now()
+
+					Expect(controller.ConfigureClient(timeout)).To(MatchError("timeout exceeded"))
+					Expect(agentClient.VerifyServicesCalls.CallCount).To(Equal(0))
+					Expect(agentRunner.WritePIDCall.CallCount).To(Equal(0))
+					Expect(logger.Messages).To(ContainSequence([]fakes.LoggerMessage{
+						{
+							Action: "controller.configure-client.verify-leader",
+						},
+						{
+							Action: "controller.configure-client.verify-leader.failed",
+							Error:  errors.New("timeout exceeded"),
+						},
+					}))
+				})
+			})
+		})
+
+		Context("when waiting for services is enabled", func() {
+			BeforeEach(func() {
+				controller.Config.Consul.Agent.WaitForServices = true
+				serviceDefiner.GenerateDefinitionsCall.Returns.Definitions = []confab.ServiceDefinition{
+					{ServiceName: "router", Name: "gorouter"},
+				}
+			})
+
+			It("waits for the service checks to pass before writing the pid file", func() {
+				agentClient.VerifyServicesCalls.Returns.Errors = []error{errors.New("critical"), nil}
+
+				Expect(controller.ConfigureClient(confab.NewTimeout(make(chan time.Time)))).To(Succeed())
+				Expect(agentClient.VerifyLeaderCalls.CallCount).To(Equal(0))
+				Expect(agentClient.VerifyServicesCalls.CallCount).To(Equal(2))
+				Expect(agentClient.VerifyServicesCalls.Receives.ServiceIDs).To(Equal([]string{"gorouter"}))
+				Expect(clock.SleepCall.CallCount).To(Equal(1))
+				Expect(agentRunner.WritePIDCall.CallCount).To(Equal(1))
+				Expect(logger.Messages).To(ContainSequence([]fakes.LoggerMessage{
+					{
+						Action: "controller.configure-client.verify-services",
+						Data: []lager.Data{{
+							"services": []string{"gorouter"},
+						}},
+					},
+					{
+						Action: "controller.configure-client.success",
+					},
+				}))
+			})
+
+			Context("when the checks never pass within the timeout", func() {
+				It("returns an error without writing the pid file", func() {
+					timer := make(chan time.Time)
+					timeout := confab.NewTimeout(timer)
+					timer <- time.Now()
+
+					Expect(controller.ConfigureClient(timeout)).To(MatchError("timeout exceeded"))
+					Expect(agentRunner.WritePIDCall.CallCount).To(Equal(0))
+					Expect(logger.Messages).To(ContainSequence([]fakes.LoggerMessage{
+						{
+							Action: "controller.configure-client.verify-services.failed",
+							Error:  errors.New("timeout exceeded"),
+							Data: []lager.Data{{
+								"services": []string{"gorouter"},
+							}},
+						},
+					}))
+				})
+			})
 		})
 
 		Context("failure cases", func() {
 			It("returns an error when the pid file can not be written", func() {
 				agentRunner.WritePIDCall.Returns.Error = errors.New("something bad happened")
 
-				err := controller.ConfigureClient()
+				err := controller.ConfigureClient(confab.NewTimeout(make(chan time.Time)))
 				Expect(err).To(MatchError("something bad happened"))
+				Expect(logger.Messages).To(ContainSequence([]fakes.LoggerMessage{
+					{
+						Action: "controller.configure-client.write-pid.failed",
+						Error:  errors.New("something bad happened"),
+					},
+				}))
 			})
 		})
 	})
@@ -184,6 +606,21 @@ var _ = Describe("Controller", func() {
 			}))
 		})
 
+		Context("when the definitions cannot be generated", func() {
+			It("returns the error without writing any definitions", func() {
+				serviceDefiner.GenerateDefinitionsCall.Returns.Error = errors.New("collision")
+
+				Expect(controller.WriteServiceDefinitions()).To(MatchError("collision"))
+				Expect(serviceDefiner.WriteDefinitionsCall.Receives.Definitions).To(BeNil())
+				Expect(logger.Messages).To(ContainSequence([]fakes.LoggerMessage{
+					{
+						Action: "controller.write-service-definitions.generate-definitions.failed",
+						Error:  errors.New("collision"),
+					},
+				}))
+			})
+		})
+
 		Context("when there is an error", func() {
 			It("returns the error", func() {
 				serviceDefiner.WriteDefinitionsCall.Returns.Error = errors.New("write definitions error")
@@ -219,6 +656,16 @@ var _ = Describe("Controller", func() {
 				{
 					Action: "controller.boot-agent.verify-joined",
 				},
+				{
+					Action: "controller.boot-agent.end-drain",
+				},
+				{
+					Action: "controller.boot-agent.check-protocol",
+					Data: []lager.Data{{
+						"node":   "node-0",
+						"policy": "",
+					}},
+				},
 				{
 					Action: "controller.boot-agent.success",
 				},
@@ -262,6 +709,16 @@ var _ = Describe("Controller", func() {
 					{
 						Action: "controller.boot-agent.verify-joined",
 					},
+					{
+						Action: "controller.boot-agent.end-drain",
+					},
+					{
+						Action: "controller.boot-agent.check-protocol",
+						Data: []lager.Data{{
+							"node":   "node-0",
+							"policy": "",
+						}},
+					},
 					{
 						Action: "controller.boot-agent.success",
 					},
@@ -302,6 +759,154 @@ var _ = Describe("Controller", func() {
 		})
 	})
 
+	Describe("BootAgent after a drain", func() {
+		It("takes the node out of the maintenance mode a drain left it in", func() {
+			controller.Config.Consul.Agent.DrainTimeInSeconds = 5
+			controller.DrainAgent()
+			Expect(agentClient.EnableMaintenanceCall.CallCount).To(Equal(1))
+
+			// consul keeps the maintenance check across the restart
+			agentClient.NodeMaintenanceReasonCall.Returns.Reason = agentClient.EnableMaintenanceCall.Receives.Reason
+
+			Expect(controller.BootAgent(confab.NewTimeout(make(chan time.Time)))).To(Succeed())
+			Expect(agentClient.NodeMaintenanceReasonCall.CallCount).To(Equal(1))
+			Expect(agentClient.DisableMaintenanceCall.CallCount).To(Equal(1))
+			Expect(agentClient.DisableMaintenanceCall.Receives.ServiceID).To(Equal(""))
+			Expect(logger.Messages).To(ContainSequence([]fakes.LoggerMessage{
+				{
+					Action: "controller.boot-agent.end-drain",
+				},
+				{
+					Action: "controller.boot-agent.end-drain.disable-maintenance",
+				},
+			}))
+		})
+
+		It("leaves the node alone when it is not in maintenance", func() {
+			Expect(controller.BootAgent(confab.NewTimeout(make(chan time.Time)))).To(Succeed())
+			Expect(agentClient.NodeMaintenanceReasonCall.CallCount).To(Equal(1))
+			Expect(agentClient.DisableMaintenanceCall.CallCount).To(Equal(0))
+		})
+
+		It("leaves maintenance enabled by an operator alone", func() {
+			agentClient.NodeMaintenanceReasonCall.Returns.Reason = "replacing disks"
+
+			Expect(controller.BootAgent(confab.NewTimeout(make(chan time.Time)))).To(Succeed())
+			Expect(agentClient.DisableMaintenanceCall.CallCount).To(Equal(0))
+		})
+
+		Context("when the maintenance reason cannot be read", func() {
+			It("returns an error", func() {
+				agentClient.NodeMaintenanceReasonCall.Returns.Error = errors.New("checks error")
+
+				Expect(controller.BootAgent(confab.NewTimeout(make(chan time.Time)))).To(MatchError("checks error"))
+				Expect(logger.Messages).To(ContainElement(fakes.LoggerMessage{
+					Action: "controller.boot-agent.end-drain.failed",
+					Error:  errors.New("checks error"),
+				}))
+			})
+		})
+
+		Context("when maintenance cannot be disabled", func() {
+			It("returns an error", func() {
+				agentClient.NodeMaintenanceReasonCall.Returns.Reason = "confab is draining the node before stopping the agent"
+				agentClient.DisableMaintenanceCall.Returns.Error = errors.New("maintenance error")
+
+				Expect(controller.BootAgent(confab.NewTimeout(make(chan time.Time)))).To(MatchError("maintenance error"))
+				Expect(logger.Messages).To(ContainElement(fakes.LoggerMessage{
+					Action: "controller.boot-agent.end-drain.disable-maintenance.failed",
+					Error:  errors.New("maintenance error"),
+				}))
+			})
+		})
+	})
+
+	Describe("BootAgent protocol checks", func() {
+		var incompatible agent.Compatibility
+
+		BeforeEach(func() {
+			incompatible = agent.Compatibility{
+				Local: agent.Member{
+					Name:         "node-0",
+					Protocol:     agent.MemberProtocol{Min: 1, Max: 3, Current: 3},
+					SerfProtocol: agent.MemberProtocol{Min: 1, Max: 4, Current: 4},
+				},
+				ProtocolRange:     agent.ProtocolRange{Min: 1, Max: 2},
+				SerfProtocolRange: agent.ProtocolRange{Min: 1, Max: 4},
+				Spread: agent.VersionSpread{
+					Protocols: map[string][]string{"2": {"node-1"}, "3": {"node-0"}},
+				},
+			}
+		})
+
+		It("checks the protocols of the local node", func() {
+			Expect(controller.BootAgent(confab.NewTimeout(make(chan time.Time)))).To(Succeed())
+			Expect(agentClient.ProtocolCompatibilityCall.CallCount).To(Equal(1))
+			Expect(agentClient.ProtocolCompatibilityCall.Receives.Node).To(Equal("node-0"))
+		})
+
+		Context("when the local node is incompatible", func() {
+			BeforeEach(func() {
+				agentClient.ProtocolCompatibilityCall.Returns.Compatibility = incompatible
+			})
+
+			It("warns by default", func() {
+				Expect(controller.BootAgent(confab.NewTimeout(make(chan time.Time)))).To(Succeed())
+				Expect(logger.Messages).To(ContainElement(fakes.LoggerMessage{
+					Action: "controller.boot-agent.check-protocol.incompatible",
+					Error:  errors.New("protocol 3 (serf 4) is outside the cluster range 1-2 (serf 1-4)"),
+					Data: []lager.Data{{
+						"spread": incompatible.Spread,
+					}},
+				}))
+			})
+
+			It("fails when the policy is fail", func() {
+				controller.Config.Consul.Agent.ProtocolPolicy = "fail"
+
+				err := controller.BootAgent(confab.NewTimeout(make(chan time.Time)))
+				Expect(err).To(MatchError("protocol 3 (serf 4) is outside the cluster range 1-2 (serf 1-4)"))
+			})
+
+			It("does not check when the policy is ignore", func() {
+				controller.Config.Consul.Agent.ProtocolPolicy = "ignore"
+
+				Expect(controller.BootAgent(confab.NewTimeout(make(chan time.Time)))).To(Succeed())
+				Expect(agentClient.ProtocolCompatibilityCall.CallCount).To(Equal(0))
+			})
+		})
+
+		Context("when the compatibility check fails", func() {
+			BeforeEach(func() {
+				agentClient.ProtocolCompatibilityCall.Returns.Error = errors.New("members error")
+			})
+
+			It("warns by default", func() {
+				Expect(controller.BootAgent(confab.NewTimeout(make(chan time.Time)))).To(Succeed())
+				Expect(logger.Messages).To(ContainElement(fakes.LoggerMessage{
+					Action: "controller.boot-agent.check-protocol.failed",
+					Error:  errors.New("members error"),
+				}))
+			})
+
+			It("fails when the policy is fail", func() {
+				controller.Config.Consul.Agent.ProtocolPolicy = "fail"
+
+				Expect(controller.BootAgent(confab.NewTimeout(make(chan time.Time)))).To(MatchError("members error"))
+			})
+		})
+
+		Context("when the policy is invalid", func() {
+			It("returns an error without starting the agent", func() {
+				controller.Config.Consul.Agent.ProtocolPolicy = "sometimes"
+
+				err := controller.BootAgent(confab.NewTimeout(make(chan time.Time)))
+				Expect(err).To(MatchError(`invalid protocol_policy "sometimes", must be "warn", "fail" or "ignore"`))
+				Expect(agentRunner.RunCalls.CallCount).To(Equal(0))
+			})
+		})
+	})
+
 	Describe("StopAgent", func() {
 		It("tells client to leave the cluster and waits for the agent to stop", func() {
 			controller.StopAgent()
@@ -393,64 +998,197 @@ var _ = Describe("Controller", func() {
 			})
 		})
 
-		Context("when agent runner Wait() returns an error", func() {
-			BeforeEach(func() {
-				agentRunner.WaitCall.Returns.Error = errors.New("wait error")
-			})
+		Context("when agent runner Wait() returns an error", func() {
+			BeforeEach(func() {
+				agentRunner.WaitCall.Returns.Error = errors.New("wait error")
+			})
+
+			It("logs the error", func() {
+				controller.StopAgent()
+				Expect(logger.Messages).To(ContainSequence([]fakes.LoggerMessage{
+					{
+						Action: "controller.stop-agent.leave",
+					},
+					{
+						Action: "controller.stop-agent.wait",
+					},
+					{
+						Action: "controller.stop-agent.wait.failed",
+						Error:  errors.New("wait error"),
+					},
+					{
+						Action: "controller.stop-agent.cleanup",
+					},
+					{
+						Action: "controller.stop-agent.success",
+					},
+				}))
+			})
+		})
+
+		Context("when agent runner Cleanup() returns an error", func() {
+			BeforeEach(func() {
+				agentRunner.CleanupCall.Returns.Error = errors.New("cleanup error")
+			})
+
+			It("logs the error", func() {
+				controller.StopAgent()
+				Expect(logger.Messages).To(ContainSequence([]fakes.LoggerMessage{
+					{
+						Action: "controller.stop-agent.leave",
+					},
+					{
+						Action: "controller.stop-agent.wait",
+					},
+					{
+						Action: "controller.stop-agent.cleanup",
+					},
+					{
+						Action: "controller.stop-agent.cleanup.failed",
+						Error:  errors.New("cleanup error"),
+					},
+					{
+						Action: "controller.stop-agent.success",
+					},
+				}))
+			})
+		})
+	})
+
+	Describe("EnableMaintenance", func() {
+		It("puts the agent into maintenance mode", func() {
+			Expect(controller.EnableMaintenance("router", "upgrading")).To(Succeed())
+			Expect(agentClient.EnableMaintenanceCall.CallCount).To(Equal(1))
+			Expect(agentClient.EnableMaintenanceCall.Receives.ServiceID).To(Equal("router"))
+			Expect(agentClient.EnableMaintenanceCall.Receives.Reason).To(Equal("upgrading"))
+			Expect(logger.Messages).To(ContainSequence([]fakes.LoggerMessage{
+				{
+					Action: "controller.enable-maintenance",
+					Data: []lager.Data{{
+						"service": "router",
+						"reason":  "upgrading",
+					}},
+				},
+				{
+					Action: "controller.enable-maintenance.success",
+				},
+			}))
+		})
+
+		Context("when the agent client fails", func() {
+			It("returns the error", func() {
+				agentClient.EnableMaintenanceCall.Returns.Error = errors.New("maintenance error")
+
+				Expect(controller.EnableMaintenance("", "")).To(MatchError("maintenance error"))
+				Expect(logger.Messages).To(ContainSequence([]fakes.LoggerMessage{
+					{
+						Action: "controller.enable-maintenance.failed",
+						Error:  errors.New("maintenance error"),
+						Data: []lager.Data{{
+							"service": "",
+						}},
+					},
+				}))
+			})
+		})
+	})
+
+	Describe("DisableMaintenance", func() {
+		It("takes the agent out of maintenance mode", func() {
+			Expect(controller.DisableMaintenance("")).To(Succeed())
+			Expect(agentClient.DisableMaintenanceCall.CallCount).To(Equal(1))
+			Expect(agentClient.DisableMaintenanceCall.Receives.ServiceID).To(Equal(""))
+			Expect(logger.Messages).To(ContainSequence([]fakes.LoggerMessage{
+				{
+					Action: "controller.disable-maintenance",
+					Data: []lager.Data{{
+						"service": "",
+					}},
+				},
+				{
+					Action: "controller.disable-maintenance.success",
+				},
+			}))
+		})
 
-			It("logs the error", func() {
-				controller.StopAgent()
+		Context("when the agent client fails", func() {
+			It("returns the error", func() {
+				agentClient.DisableMaintenanceCall.Returns.Error = errors.New("maintenance error")
+
+				Expect(controller.DisableMaintenance("router")).To(MatchError("maintenance error"))
 				Expect(logger.Messages).To(ContainSequence([]fakes.LoggerMessage{
 					{
-						Action: "controller.stop-agent.leave",
-					},
-					{
-						Action: "controller.stop-agent.wait",
-					},
-					{
-						Action: "controller.stop-agent.wait.failed",
-						Error:  errors.New("wait error"),
-					},
-					{
-						Action: "controller.stop-agent.cleanup",
-					},
-					{
-						Action: "controller.stop-agent.success",
+						Action: "controller.disable-maintenance.failed",
+						Error:  errors.New("maintenance error"),
+						Data: []lager.Data{{
+							"service": "router",
+						}},
 					},
 				}))
 			})
 		})
+	})
 
-		Context("when agent runner Cleanup() returns an error", func() {
+	Describe("DrainAgent", func() {
+		It("does nothing when no drain time is configured", func() {
+			controller.DrainAgent()
+			Expect(agentClient.EnableMaintenanceCall.CallCount).To(Equal(0))
+			Expect(clock.SleepCall.CallCount).To(Equal(0))
+		})
+
+		Context("when a drain time is configured", func() {
 			BeforeEach(func() {
-				agentRunner.CleanupCall.Returns.Error = errors.New("cleanup error")
+				controller.Config.Consul.Agent.DrainTimeInSeconds = 15
 			})
 
-			It("logs the error", func() {
-				controller.StopAgent()
+			It("enables node maintenance and waits for the drain time", func() {
+				controller.DrainAgent()
+				Expect(agentClient.EnableMaintenanceCall.CallCount).To(Equal(1))
+				Expect(agentClient.EnableMaintenanceCall.Receives.ServiceID).To(Equal(""))
+				Expect(agentClient.EnableMaintenanceCall.Receives.Reason).NotTo(BeEmpty())
+				Expect(clock.SleepCall.CallCount).To(Equal(1))
+				Expect(clock.SleepCall.Receives.Duration).To(Equal(15 * time.Second))
 				Expect(logger.Messages).To(ContainSequence([]fakes.LoggerMessage{
 					{
-						Action: "controller.stop-agent.leave",
-					},
-					{
-						Action: "controller.stop-agent.wait",
-					},
-					{
-						Action: "controller.stop-agent.cleanup",
+						Action: "controller.drain-agent.enable-maintenance",
 					},
 					{
-						Action: "controller.stop-agent.cleanup.failed",
-						Error:  errors.New("cleanup error"),
+						Action: "controller.drain-agent.wait",
+						Data: []lager.Data{{
+							"drain_time": "15s",
+						}},
 					},
 					{
-						Action: "controller.stop-agent.success",
+						Action: "controller.drain-agent.success",
 					},
 				}))
 			})
+
+			Context("when enabling maintenance fails", func() {
+				It("logs the error and does not wait", func() {
+					agentClient.EnableMaintenanceCall.Returns.Error = errors.New("maintenance error")
+
+					controller.DrainAgent()
+					Expect(clock.SleepCall.CallCount).To(Equal(0))
+					Expect(logger.Messages).To(ContainSequence([]fakes.LoggerMessage{
+						{
+							Action: "controller.drain-agent.enable-maintenance.failed",
+							Error:  errors.New("maintenance error"),
+						},
+					}))
+				})
+			})
 		})
 	})
 
 	Describe("ConfigureServer", func() {
+		BeforeEach(func() {
+			agentClient.IsLastNodeCall.Returns.Result = agent.LastNodeResult{
+				Missing:    []string{"member3"},
+				Unexpected: []string{},
+			}
+		})
+
 		Context("when it is not the last node in the cluster", func() {
 			It("does not check that it is synced", func() {
 				Expect(controller.ConfigureServer(confab.NewTimeout(make(chan time.Time)))).To(Succeed())
@@ -460,6 +1198,14 @@ var _ = Describe("Controller", func() {
 					{
 						Action: "controller.configure-server.is-last-node",
 					},
+					{
+						Action: "controller.configure-server.is-last-node.result",
+						Data: []lager.Data{{
+							"is_last_node": false,
+							"missing":      []string{"member3"},
+							"unexpected":   []string{},
+						}},
+					},
 					{
 						Action: "controller.configure-server.set-keys",
 						Data: []lager.Data{{
@@ -485,6 +1231,14 @@ var _ = Describe("Controller", func() {
 					{
 						Action: "controller.configure-server.is-last-node",
 					},
+					{
+						Action: "controller.configure-server.is-last-node.result",
+						Data: []lager.Data{{
+							"is_last_node": false,
+							"missing":      []string{"member3"},
+							"unexpected":   []string{},
+						}},
+					},
 					{
 						Action: "controller.configure-server.set-keys",
 						Data: []lager.Data{{
@@ -511,6 +1265,14 @@ var _ = Describe("Controller", func() {
 						{
 							Action: "controller.configure-server.is-last-node",
 						},
+						{
+							Action: "controller.configure-server.is-last-node.result",
+							Data: []lager.Data{{
+								"is_last_node": false,
+								"missing":      []string{"member3"},
+								"unexpected":   []string{},
+							}},
+						},
 						{
 							Action: "controller.configure-server.set-keys",
 							Data: []lager.Data{{
@@ -540,6 +1302,14 @@ var _ = Describe("Controller", func() {
 						{
 							Action: "controller.configure-server.is-last-node",
 						},
+						{
+							Action: "controller.configure-server.is-last-node.result",
+							Data: []lager.Data{{
+								"is_last_node": false,
+								"missing":      []string{"member3"},
+								"unexpected":   []string{},
+							}},
+						},
 						{
 							Action: "controller.configure-server.success",
 						},
@@ -561,6 +1331,14 @@ var _ = Describe("Controller", func() {
 						{
 							Action: "controller.configure-server.is-last-node",
 						},
+						{
+							Action: "controller.configure-server.is-last-node.result",
+							Data: []lager.Data{{
+								"is_last_node": false,
+								"missing":      []string{"member3"},
+								"unexpected":   []string{},
+							}},
+						},
 						{
 							Action: "controller.configure-server.no-encrypt-keys",
 							Error:  errors.New("encrypt keys cannot be empty if ssl is enabled"),
@@ -572,7 +1350,11 @@ var _ = Describe("Controller", func() {
 
 		Context("when it is the last node in the cluster", func() {
 			BeforeEach(func() {
-				agentClient.IsLastNodeCall.Returns.IsLastNode = true
+				agentClient.IsLastNodeCall.Returns.Result = agent.LastNodeResult{
+					IsLastNode: true,
+					Missing:    []string{},
+					Unexpected: []string{},
+				}
 			})
 
 			It("checks that it is synced", func() {
@@ -584,6 +1366,14 @@ var _ = Describe("Controller", func() {
 					{
 						Action: "controller.configure-server.is-last-node",
 					},
+					{
+						Action: "controller.configure-server.is-last-node.result",
+						Data: []lager.Data{{
+							"is_last_node": true,
+							"missing":      []string{},
+							"unexpected":   []string{},
+						}},
+					},
 					{
 						Action: "controller.configure-server.verify-synced",
 					},
@@ -616,6 +1406,14 @@ var _ = Describe("Controller", func() {
 						{
 							Action: "controller.configure-server.is-last-node",
 						},
+						{
+							Action: "controller.configure-server.is-last-node.result",
+							Data: []lager.Data{{
+								"is_last_node": true,
+								"missing":      []string{},
+								"unexpected":   []string{},
+							}},
+						},
 						{
 							Action: "controller.configure-server.verify-synced",
 						},
@@ -653,6 +1451,14 @@ var _ = Describe("Controller", func() {
 						{
 							Action: "controller.configure-server.is-last-node",
 						},
+						{
+							Action: "controller.configure-server.is-last-node.result",
+							Data: []lager.Data{{
+								"is_last_node": true,
+								"missing":      []string{},
+								"unexpected":   []string{},
+							}},
+						},
 						{
 							Action: "controller.configure-server.verify-synced",
 						},
@@ -684,6 +1490,272 @@ var _ = Describe("Controller", func() {
 			})
 		})
 
+		Context("when waiting for services is enabled", func() {
+			BeforeEach(func() {
+				controller.Config.Consul.Agent.WaitForServices = true
+				serviceDefiner.GenerateDefinitionsCall.Returns.Definitions = []confab.ServiceDefinition{
+					{ServiceName: "cloud_controller", Name: "cloud-controller", ID: "cc-id"},
+				}
+			})
+
+			It("waits for the service checks to pass before writing the pid file", func() {
+				agentClient.VerifyServicesCalls.Returns.Errors = []error{nil}
+
+				Expect(controller.ConfigureServer(confab.NewTimeout(make(chan time.Time)))).To(Succeed())
+				Expect(agentClient.VerifyServicesCalls.CallCount).To(Equal(1))
+				Expect(agentClient.VerifyServicesCalls.Receives.ServiceIDs).To(Equal([]string{"cc-id"}))
+				Expect(agentRunner.WritePIDCall.CallCount).To(Equal(1))
+				Expect(logger.Messages).To(ContainSequence([]fakes.LoggerMessage{
+					{
+						Action: "controller.configure-server.verify-services",
+						Data: []lager.Data{{
+							"services": []string{"cc-id"},
+						}},
+					},
+					{
+						Action: "controller.configure-server.success",
+					},
+				}))
+			})
+
+			Context("when the checks never pass within the timeout", func() {
+				It("returns an error without writing the pid file", func() {
+					agentClient.VerifyServicesCalls.Returns.Errors = []error{errors.New("critical")}
+					timer := make(chan time.Time)
+					timeout := confab.NewTimeout(timer)
+					timer <- time.Now()
+
+					Expect(controller.ConfigureServer(timeout)).To(MatchError("timeout exceeded"))
+					Expect(agentRunner.WritePIDCall.CallCount).To(Equal(0))
+				})
+			})
+		})
+
+		Context("when reconciling raft peers", func() {
+			BeforeEach(func() {
+				controller.Config.Consul.Agent.ReconcilePeers = true
+				controller.Config.Consul.Agent.Servers.LAN = []string{"10.0.0.1", "10.0.0.2", "10.0.0.3"}
+				agentClient.RaftPeersCall.Returns.Peers = []string{"10.0.0.1:8300", "10.0.0.2:8300", "10.0.0.4:8300", "10.0.0.3:8300", "10.0.0.5:8300"}
+				agentClient.RemovePeerCalls.Returns.Errors = []error{nil, nil}
+				agentClient.VerifyLeaderCalls.Returns.Errors = []error{nil, nil, errors.New("no known leader"), nil}
+			})
+
+			It("removes peers that are not lan servers one at a time, verifying the leader after each", func() {
+				Expect(controller.ConfigureServer(confab.NewTimeout(make(chan time.Time)))).To(Succeed())
+				Expect(agentClient.RaftPeersCall.CallCount).To(Equal(1))
+				Expect(agentClient.RemovePeerCalls.Receives.Peers).To(Equal([]string{"10.0.0.4:8300", "10.0.0.5:8300"}))
+				Expect(agentClient.VerifyLeaderCalls.CallCount).To(Equal(4))
+				Expect(agentRunner.WritePIDCall.CallCount).To(Equal(1))
+				Expect(logger.Messages).To(ContainSequence([]fakes.LoggerMessage{
+					{
+						Action: "controller.configure-server.verify-leader",
+					},
+					{
+						Action: "controller.configure-server.reconcile-peers.raft-peers",
+					},
+					{
+						Action: "controller.configure-server.reconcile-peers",
+						Data: []lager.Data{{
+							"peers": []string{"10.0.0.1:8300", "10.0.0.2:8300", "10.0.0.4:8300", "10.0.0.3:8300", "10.0.0.5:8300"},
+							"stale": []string{"10.0.0.4:8300", "10.0.0.5:8300"},
+						}},
+					},
+					{
+						Action: "controller.configure-server.reconcile-peers.remove-peer",
+						Data: []lager.Data{{
+							"peer": "10.0.0.4:8300",
+						}},
+					},
+					{
+						Action: "controller.configure-server.reconcile-peers.remove-peer",
+						Data: []lager.Data{{
+							"peer": "10.0.0.5:8300",
+						}},
+					},
+					{
+						Action: "controller.configure-server.success",
+					},
+				}))
+			})
+
+			It("skips reconciling when the lan servers are not ip addresses", func() {
+				controller.Config.Consul.Agent.Servers.LAN = []string{"consul-0.example.com"}
+
+				Expect(controller.ConfigureServer(confab.NewTimeout(make(chan time.Time)))).To(Succeed())
+				Expect(agentClient.RaftPeersCall.CallCount).To(Equal(0))
+				Expect(agentClient.RemovePeerCalls.CallCount).To(Equal(0))
+				Expect(logger.Messages).To(ContainElement(fakes.LoggerMessage{
+					Action: "controller.configure-server.reconcile-peers.skipped",
+					Data: []lager.Data{{
+						"reason": `lan server "consul-0.example.com" is not an ip address`,
+					}},
+				}))
+			})
+
+			It("stops at the first peer that cannot be removed", func() {
+				agentClient.RemovePeerCalls.Returns.Errors = []error{errors.New("force-leave error")}
+
+				err := controller.ConfigureServer(confab.NewTimeout(make(chan time.Time)))
+				Expect(err).To(MatchError("force-leave error"))
+				Expect(agentClient.RemovePeerCalls.CallCount).To(Equal(1))
+				Expect(agentRunner.WritePIDCall.CallCount).To(Equal(0))
+				Expect(logger.Messages).To(ContainElement(fakes.LoggerMessage{
+					Action: "controller.configure-server.reconcile-peers.remove-peer.failed",
+					Error:  errors.New("force-leave error"),
+					Data: []lager.Data{{
+						"peer": "10.0.0.4:8300",
+					}},
+				}))
+			})
+
+			It("returns an error when the raft peers cannot be listed", func() {
+				agentClient.RaftPeersCall.Returns.Error = errors.New("peers error")
+
+				Expect(controller.ConfigureServer(confab.NewTimeout(make(chan time.Time)))).To(MatchError("peers error"))
+				Expect(agentClient.RemovePeerCalls.CallCount).To(Equal(0))
+			})
+		})
+
+		Context("when kv seed entries are declared", func() {
+			BeforeEach(func() {
+				controller.Config.Consul.KVSeed = map[string]confab.ConfigConsulKVEntry{
+					"service/config":  {Value: "some-config"},
+					"features/new-ui": {Value: "true", Flags: 1},
+				}
+				agentClient.VerifyLeaderCalls.Returns.Errors = []error{nil}
+				agentClient.SeedKVCalls.Returns.Errors = []error{errors.New("cas error"), nil}
+			})
+
+			It("seeds the missing keys in key order once a leader is known", func() {
+				Expect(controller.ConfigureServer(confab.NewTimeout(make(chan time.Time)))).To(Succeed())
+				Expect(agentClient.VerifyLeaderCalls.CallCount).To(Equal(1))
+				Expect(agentClient.SeedKVCalls.CallCount).To(Equal(2))
+				Expect(agentClient.SeedKVCalls.Receives.Enforce).To(BeFalse())
+				Expect(agentClient.SeedKVCalls.Receives.Pairs).To(Equal([]api.KVPair{
+					{Key: "features/new-ui", Value: []byte("true"), Flags: 1},
+					{Key: "service/config", Value: []byte("some-config")},
+				}))
+				Expect(agentRunner.WritePIDCall.CallCount).To(Equal(1))
+				Expect(logger.Messages).To(ContainSequence([]fakes.LoggerMessage{
+					{
+						Action: "controller.configure-server.verify-leader",
+					},
+					{
+						Action: "controller.configure-server.seed-kv",
+						Data: []lager.Data{{
+							"keys": []string{"features/new-ui", "service/config"},
+							"mode": "create_missing",
+						}},
+					},
+					{
+						Action: "controller.configure-server.success",
+					},
+				}))
+			})
+
+			It("enforces the declared values when the mode is enforce", func() {
+				controller.Config.Consul.KVSeedMode = "enforce"
+
+				Expect(controller.ConfigureServer(confab.NewTimeout(make(chan time.Time)))).To(Succeed())
+				Expect(agentClient.SeedKVCalls.Receives.Enforce).To(BeTrue())
+			})
+
+			It("returns an error when the mode is invalid", func() {
+				controller.Config.Consul.KVSeedMode = "banana"
+
+				err := controller.ConfigureServer(confab.NewTimeout(make(chan time.Time)))
+				Expect(err).To(MatchError(`invalid kv_seed_mode "banana", must be "create_missing" or "enforce"`))
+				Expect(agentClient.SeedKVCalls.CallCount).To(Equal(0))
+				Expect(agentRunner.WritePIDCall.CallCount).To(Equal(0))
+			})
+		})
+
+		Context("when prepared queries are declared", func() {
+			BeforeEach(func() {
+				controller.Config.Consul.PreparedQueries = []confab.ConfigConsulPreparedQuery{
+					{
+						Name:        "router",
+						Service:     "gorouter",
+						OnlyPassing: true,
+						Tags:        []string{"z1"},
+						Failover: confab.ConfigConsulPreparedQueryFailover{
+							NearestN:    2,
+							Datacenters: []string{"dc2"},
+						},
+						DNSTTL: "10s",
+					},
+				}
+				agentClient.VerifyLeaderCalls.Returns.Errors = []error{errors.New("no known leader"), nil}
+				agentClient.ReconcilePreparedQueriesCalls.Returns.Errors = []error{nil}
+			})
+
+			It("reconciles them once a leader is known", func() {
+				Expect(controller.ConfigureServer(confab.NewTimeout(make(chan time.Time)))).To(Succeed())
+				Expect(agentClient.VerifyLeaderCalls.CallCount).To(Equal(2))
+				Expect(agentClient.ReconcilePreparedQueriesCalls.CallCount).To(Equal(1))
+				Expect(agentClient.ReconcilePreparedQueriesCalls.Receives.Queries).To(Equal([]api.PreparedQueryDefinition{
+					{
+						Name: "router",
+						Service: api.ServiceQuery{
+							Service: "gorouter",
+							Failover: api.QueryDatacenterOptions{
+								NearestN:    2,
+								Datacenters: []string{"dc2"},
+							},
+							OnlyPassing: true,
+							Tags:        []string{"z1"},
+						},
+						DNS: api.QueryDNSOptions{
+							TTL: "10s",
+						},
+					},
+				}))
+				Expect(agentRunner.WritePIDCall.CallCount).To(Equal(1))
+				Expect(logger.Messages).To(ContainSequence([]fakes.LoggerMessage{
+					{
+						Action: "controller.configure-server.verify-leader",
+					},
+					{
+						Action: "controller.configure-server.reconcile-prepared-queries",
+						Data: []lager.Data{{
+							"count": 1,
+						}},
+					},
+					{
+						Action: "controller.configure-server.success",
+					},
+				}))
+			})
+
+			Context("when reconciling fails at first", func() {
+				It("retries until it succeeds", func() {
+					agentClient.ReconcilePreparedQueriesCalls.Returns.Errors = []error{errors.New("create error"), nil}
+
+					Expect(controller.ConfigureServer(confab.NewTimeout(make(chan time.Time)))).To(Succeed())
+					Expect(agentClient.ReconcilePreparedQueriesCalls.CallCount).To(Equal(2))
+					Expect(agentRunner.WritePIDCall.CallCount).To(Equal(1))
+				})
+			})
+
+			Context("when a leader is never known within the timeout", func() {
+				It("returns an error without reconciling or writing the pid file", func() {
+					timer := make(chan time.Time)
+					timeout := confab.NewTimeout(timer)
+					timer <- time.Now()
+
+					Expect(controller.ConfigureServer(timeout)).To(MatchError("timeout exceeded"))
+					Expect(agentClient.ReconcilePreparedQueriesCalls.CallCount).To(Equal(0))
+					Expect(agentRunner.WritePIDCall.CallCount).To(Equal(0))
+					Expect(logger.Messages).To(ContainSequence([]fakes.LoggerMessage{
+						{
+							Action: "controller.configure-server.verify-leader.failed",
+							Error:  errors.New("timeout exceeded"),
+						},
+					}))
+				})
+			})
+		})
+
 		Context("when writing the PID file fails", func() {
 			It("returns the error", func() {
 				agentRunner.WritePIDCall.Returns.Error = errors.New("failed to write PIDFILE")
@@ -697,6 +1769,14 @@ var _ = Describe("Controller", func() {
 					{
 						Action: "controller.configure-server.is-last-node",
 					},
+					{
+						Action: "controller.configure-server.is-last-node.result",
+						Data: []lager.Data{{
+							"is_last_node": false,
+							"missing":      []string{"member3"},
+							"unexpected":   []string{},
+						}},
+					},
 					{
 						Action: "controller.configure-server.write-pid.failed",
 						Error:  errors.New("failed to write PIDFILE"),
@@ -9,3 +9,11 @@ func SetCreateFile(f func(string) (*os.File, error)) {
 func ResetCreateFile() {
 	createFile = os.Create
 }
+
+func SetRemoveFile(f func(string) error) {
+	removeFile = f
+}
+
+func ResetRemoveFile() {
+	removeFile = os.Remove
+}
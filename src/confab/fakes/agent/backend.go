@@ -0,0 +1,127 @@
+package main
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/hashicorp/consul/consul/structs"
+	"github.com/hashicorp/serf/serf"
+)
+
+type ScenarioBackend struct {
+	Scenario     Scenario
+	Started      time.Time
+	OutputWriter *OutputWriter
+	Left         chan struct{}
+	leaveOnce    sync.Once
+}
+
+func (b *ScenarioBackend) respond(responses []Response) (Response, bool, error) {
+	response, found := responseAt(responses, time.Since(b.Started))
+	if found && response.Error != "" {
+		return response, true, errors.New(response.Error)
+	}
+
+	return response, found, nil
+}
+
+func (b *ScenarioBackend) keyring(responses []Response) (*structs.KeyringResponses, error) {
+	response, found, err := b.respond(responses)
+	if err != nil {
+		return nil, err
+	}
+
+	if !found {
+		return nil, nil
+	}
+
+	keys := map[string]int{}
+	for _, key := range response.Keys {
+		keys[key] = 1
+	}
+
+	return &structs.KeyringResponses{
+		Responses: []*structs.KeyringResponse{
+			{
+				Keys:     keys,
+				NumNodes: 1,
+			},
+		},
+	}, nil
+}
+
+func (b *ScenarioBackend) Members() []string {
+	response, _ := responseAt(b.Scenario.Members, time.Since(b.Started))
+	return response.Members
+}
+
+func (b *ScenarioBackend) ForceLeave(node string) error {
+	b.OutputWriter.Record("forceleave", node)
+	return nil
+}
+
+func (b *ScenarioBackend) JoinWAN(addrs []string) (int, error) {
+	b.OutputWriter.Record("joinwan", addrs...)
+	return 0, nil
+}
+
+func (b *ScenarioBackend) JoinLAN(addrs []string) (int, error) {
+	b.OutputWriter.Record("joinlan", addrs...)
+	return 0, nil
+}
+
+func (b *ScenarioBackend) LANMembers() []serf.Member {
+	return nil
+}
+
+func (b *ScenarioBackend) WANMembers() []serf.Member {
+	return nil
+}
+
+func (b *ScenarioBackend) Leave() error {
+	b.OutputWriter.Record("leave")
+
+	if _, _, err := b.respond(b.Scenario.Leave); err != nil {
+		return err
+	}
+
+	if !b.Scenario.Exit.IgnoreLeave {
+		b.leaveOnce.Do(func() {
+			close(b.Left)
+		})
+	}
+
+	return nil
+}
+
+func (b *ScenarioBackend) Shutdown() error {
+	return nil
+}
+
+func (b *ScenarioBackend) Stats() map[string]map[string]string {
+	b.OutputWriter.Record("stats")
+
+	response, _ := responseAt(b.Scenario.Stats, time.Since(b.Started))
+	return response.Stats
+}
+
+func (b *ScenarioBackend) ListKeys(token string) (*structs.KeyringResponses, error) {
+	b.OutputWriter.Record("listkeys")
+	return b.keyring(b.Scenario.ListKeys)
+}
+
+func (b *ScenarioBackend) InstallKey(key, token string) (*structs.KeyringResponses, error) {
+	b.OutputWriter.Record("installkey", key)
+	return b.keyring(b.Scenario.InstallKey)
+}
+
+func (b *ScenarioBackend) UseKey(key, token string) (*structs.KeyringResponses, error) {
+	b.OutputWriter.Record("usekey", key)
+	return b.keyring(b.Scenario.UseKey)
+}
+
+func (b *ScenarioBackend) RemoveKey(key, token string) (*structs.KeyringResponses, error) {
+	b.OutputWriter.Record("removekey", key)
+	return b.keyring(b.Scenario.RemoveKey)
+}
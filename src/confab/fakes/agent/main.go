@@ -1,10 +1,8 @@
 package main
 
 import (
-	"encoding/json"
 	"flag"
 	"fmt"
-	"io/ioutil"
 	"log"
 	"os"
 	"os/signal"
@@ -45,48 +43,60 @@ func main() {
 		log.Fatal("missing required config-dir flag")
 	}
 
-	ow := NewOutputWriter(filepath.Join(configDir, "fake-output.json"), os.Getpid(), os.Args[1:])
+	ow := NewOutputWriter(
+		filepath.Join(configDir, "fake-output.json"),
+		filepath.Join(configDir, "fake-calls.json"),
+		os.Getpid(),
+		os.Args[1:],
+	)
 
-	// read input options provided to us by the test
-	var inputOptions struct {
-		Members           []string
-		FailRPCServer     bool
-		FailStatsEndpoint bool
-	}
-
-	if optionsBytes, err := ioutil.ReadFile(filepath.Join(configDir, "options.json")); err == nil {
-		json.Unmarshal(optionsBytes, &inputOptions)
+	// read the scenario provided to us by the test
+	scenario, err := LoadScenario(configDir)
+	if err != nil {
+		log.Fatalf("Failed to load scenario: %s\n", err)
 	}
 
 	tcpAddr := ""
-	if !inputOptions.FailRPCServer {
+	if !scenario.FailRPCServer {
 		tcpAddr = "127.0.0.1:8400"
 	}
 
+	backend := &ScenarioBackend{
+		Scenario:     scenario,
+		Started:      time.Now(),
+		OutputWriter: ow,
+		Left:         make(chan struct{}),
+	}
+
 	server := &Server{
-		HTTPAddr:          "127.0.0.1:8500",
-		TCPAddr:           tcpAddr,
-		Members:           inputOptions.Members,
-		OutputWriter:      ow,
-		FailStatsEndpoint: inputOptions.FailStatsEndpoint,
+		HTTPAddr: "127.0.0.1:8500",
+		TCPAddr:  tcpAddr,
+		Backend:  backend,
+
+		MaintenanceFile: filepath.Join(configDir, "fake-maintenance"),
 	}
 
-	err := server.Serve()
+	err = server.Serve()
 	if err != nil {
 		log.Fatalf("Failed to start server: %s\n", err)
 	}
 
-	for {
-		if server.DidLeave {
-			err := server.Exit()
-			if err != nil {
-				log.Fatalf("Failed to close server: %s\n", err)
-			}
+	var exitAfter <-chan time.Time
+	if scenario.Exit.AfterMS > 0 {
+		exitAfter = time.After(time.Duration(scenario.Exit.AfterMS) * time.Millisecond)
+	}
 
-			ow.Exit()
-			os.Exit(0)
-		}
+	select {
+	case <-backend.Left:
+		// give the leave response time to reach the client
+		time.Sleep(100*time.Millisecond + time.Duration(scenario.Exit.DelayMS)*time.Millisecond)
+	case <-exitAfter:
+	}
 
-		time.Sleep(100 * time.Millisecond)
+	err = server.Exit()
+	if err != nil {
+		log.Fatalf("Failed to close server: %s\n", err)
 	}
+
+	os.Exit(scenario.Exit.Code)
 }
@@ -3,12 +3,15 @@ package main
 import (
 	"encoding/json"
 	"io/ioutil"
+	"sync"
 )
 
 type OutputWriter struct {
-	filepath      string
-	data          OutputData
-	callCountChan chan string
+	outputPath string
+	callsPath  string
+	mutex      sync.Mutex
+	data       OutputData
+	calls      []Call
 }
 
 type OutputData struct {
@@ -20,69 +23,69 @@ type OutputData struct {
 	StatsCallCount      int
 }
 
-func NewOutputWriter(filepath string, pid int, args []string) *OutputWriter {
+type Call struct {
+	Name string
+	Args []string
+}
+
+func NewOutputWriter(outputPath, callsPath string, pid int, args []string) *OutputWriter {
 	ow := &OutputWriter{
-		filepath: filepath,
+		outputPath: outputPath,
+		callsPath:  callsPath,
 		data: OutputData{
 			PID:  pid,
 			Args: args,
 		},
-		callCountChan: make(chan string),
+		calls: []Call{},
 	}
 
-	go ow.run()
+	ow.mutex.Lock()
+	defer ow.mutex.Unlock()
+	ow.writeOutput()
 
 	return ow
 }
 
-func (ow *OutputWriter) run() {
-	ow.writeOutput()
-	for {
-		switch <-ow.callCountChan {
-		case "leave":
-			ow.data.LeaveCallCount++
-		case "installkey":
-			ow.data.InstallKeyCallCount++
-		case "usekey":
-			ow.data.UseKeyCallCount++
-		case "stats":
-			ow.data.StatsCallCount++
-		case "exit":
-			return
-		}
-		ow.writeOutput()
+// Record appends the call to the call log and updates the call counts before
+// returning, so the files on disk always reflect every call that has been
+// answered.
+func (ow *OutputWriter) Record(name string, args ...string) {
+	ow.mutex.Lock()
+	defer ow.mutex.Unlock()
+
+	switch name {
+	case "leave":
+		ow.data.LeaveCallCount++
+	case "installkey":
+		ow.data.InstallKeyCallCount++
+	case "usekey":
+		ow.data.UseKeyCallCount++
+	case "stats":
+		ow.data.StatsCallCount++
 	}
+
+	if args == nil {
+		args = []string{}
+	}
+	ow.calls = append(ow.calls, Call{Name: name, Args: args})
+
+	ow.writeOutput()
+}
+
+func (ow *OutputWriter) writeOutput() {
+	write(ow.outputPath, ow.data)
+	write(ow.callsPath, ow.calls)
 }
 
-func (ow OutputWriter) writeOutput() {
-	outputBytes, err := json.Marshal(ow.data)
+func write(path string, data interface{}) {
+	outputBytes, err := json.Marshal(data)
 	if err != nil {
 		panic(err)
 	}
 
 	// save information JSON to the config dir
-	err = ioutil.WriteFile(ow.filepath, outputBytes, 0600)
+	err = ioutil.WriteFile(path, outputBytes, 0600)
 	if err != nil {
 		panic(err)
 	}
 }
-
-func (ow *OutputWriter) LeaveCalled() {
-	ow.callCountChan <- "leave"
-}
-
-func (ow *OutputWriter) UseKeyCalled() {
-	ow.callCountChan <- "usekey"
-}
-
-func (ow *OutputWriter) InstallKeyCalled() {
-	ow.callCountChan <- "installkey"
-}
-
-func (ow *OutputWriter) StatsCalled() {
-	ow.callCountChan <- "stats"
-}
-
-func (ow *OutputWriter) Exit() {
-	ow.callCountChan <- "exit"
-}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// A Scenario describes how the fake agent answers over time. Each list of
+// responses is ordered by AfterMS; the fake uses the last response whose
+// AfterMS has elapsed since it started, so
+//
+//	"members": [{"members": []}, {"after_ms": 2000, "members": ["a", "b"]}]
+//
+// reports no members for two seconds and then two.
+type Scenario struct {
+	Members       []Response `json:"members"`
+	Stats         []Response `json:"stats"`
+	ListKeys      []Response `json:"list_keys"`
+	InstallKey    []Response `json:"install_key"`
+	UseKey        []Response `json:"use_key"`
+	RemoveKey     []Response `json:"remove_key"`
+	Leave         []Response `json:"leave"`
+	Exit          Exit       `json:"exit"`
+	FailRPCServer bool       `json:"fail_rpc_server"`
+}
+
+type Response struct {
+	AfterMS int                          `json:"after_ms"`
+	Members []string                     `json:"members"`
+	Stats   map[string]map[string]string `json:"stats"`
+	Keys    []string                     `json:"keys"`
+	Error   string                       `json:"error"`
+}
+
+type Exit struct {
+	IgnoreLeave bool `json:"ignore_leave"`
+	DelayMS     int  `json:"delay_ms"`
+	Code        int  `json:"code"`
+	AfterMS     int  `json:"after_ms"`
+}
+
+func LoadScenario(configDir string) (Scenario, error) {
+	var scenario Scenario
+
+	// options.json predates scenario files and is still honoured
+	var options struct {
+		Members           []string
+		FailRPCServer     bool
+		FailStatsEndpoint bool
+	}
+	if optionsBytes, err := ioutil.ReadFile(filepath.Join(configDir, "options.json")); err == nil {
+		json.Unmarshal(optionsBytes, &options)
+	}
+
+	scenario.Members = []Response{{Members: options.Members}}
+	scenario.FailRPCServer = options.FailRPCServer
+	if options.FailStatsEndpoint {
+		scenario.Stats = []Response{{
+			Stats: map[string]map[string]string{
+				"raft": {
+					"commit_index":   "5",
+					"last_log_index": "2",
+				},
+			},
+		}}
+	}
+
+	scenarioBytes, err := ioutil.ReadFile(filepath.Join(configDir, "scenario.json"))
+	if os.IsNotExist(err) {
+		return scenario, nil
+	}
+	if err != nil {
+		return Scenario{}, err
+	}
+
+	if err := json.Unmarshal(scenarioBytes, &scenario); err != nil {
+		return Scenario{}, err
+	}
+
+	return scenario, nil
+}
+
+func responseAt(responses []Response, elapsed time.Duration) (Response, bool) {
+	var (
+		current Response
+		found   bool
+	)
+
+	for _, response := range responses {
+		if time.Duration(response.AfterMS)*time.Millisecond > elapsed {
+			break
+		}
+
+		current = response
+		found = true
+	}
+
+	return current, found
+}
@@ -2,10 +2,10 @@ package main
 
 import (
 	"encoding/json"
+	"io/ioutil"
 	"net"
 	"net/http"
 	"os"
-	"time"
 
 	"github.com/hashicorp/consul/api"
 	"github.com/hashicorp/consul/command/agent"
@@ -18,11 +18,12 @@ type Server struct {
 	TCPAddr     string
 	TCPListener *net.TCPListener
 
-	OutputWriter *OutputWriter
+	Backend  *ScenarioBackend
+	RPCAgent *agent.AgentRPC
 
-	Members           []string
-	DidLeave          bool
-	FailStatsEndpoint bool
+	// MaintenanceFile holds the node maintenance reason, so that maintenance
+	// mode outlives the fake the way consul keeps it in its data dir
+	MaintenanceFile string
 }
 
 func (s *Server) Serve() error {
@@ -42,7 +43,7 @@ func (s *Server) Serve() error {
 		return err
 	}
 
-	go s.ServeTCP()
+	s.RPCAgent = agent.NewAgentRPC(s.Backend, s.TCPListener, os.Stderr, agent.NewLogWriter(42))
 	go s.ServeHTTP()
 
 	return nil
@@ -52,17 +53,47 @@ func (s *Server) ServeHTTP() {
 	mux := http.NewServeMux()
 	mux.HandleFunc("/v1/agent/members", func(w http.ResponseWriter, req *http.Request) {
 		var members []api.AgentMember
-		for _, member := range s.Members {
+		for _, member := range s.Backend.Members() {
 			members = append(members, api.AgentMember{
 				Addr: member,
 				Tags: map[string]string{
 					"role": "consul",
 				},
+				Status: 1,
 			})
 		}
 		json.NewEncoder(w).Encode(members)
 	})
 
+	mux.HandleFunc("/v1/agent/checks", func(w http.ResponseWriter, req *http.Request) {
+		checks := map[string]api.AgentCheck{}
+		if reason, err := ioutil.ReadFile(s.MaintenanceFile); err == nil {
+			checks["_node_maintenance"] = api.AgentCheck{
+				CheckID: "_node_maintenance",
+				Name:    "Node Maintenance Mode",
+				Status:  "critical",
+				Notes:   string(reason),
+			}
+		}
+		json.NewEncoder(w).Encode(checks)
+	})
+
+	mux.HandleFunc("/v1/agent/maintenance", func(w http.ResponseWriter, req *http.Request) {
+		query := req.URL.Query()
+		if query.Get("enable") == "true" {
+			s.Backend.OutputWriter.Record("enablemaintenance", query.Get("reason"))
+			if err := ioutil.WriteFile(s.MaintenanceFile, []byte(query.Get("reason")), 0600); err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+			}
+			return
+		}
+
+		s.Backend.OutputWriter.Record("disablemaintenance")
+		if err := os.Remove(s.MaintenanceFile); err != nil && !os.IsNotExist(err) {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+	})
+
 	server := &http.Server{
 		Addr:    s.HTTPAddr,
 		Handler: mux,
@@ -71,49 +102,9 @@ func (s *Server) ServeHTTP() {
 	server.Serve(s.HTTPListener)
 }
 
-func (s *Server) ServeTCP() {
-	mockAgent := new(FakeAgentBackend)
-	if s.FailStatsEndpoint {
-		mockAgent.StatsReturns(map[string]map[string]string{
-			"raft": {
-				"commit_index":   "5",
-				"last_log_index": "2",
-			},
-		})
-	}
-
-	agentRPCServer := agent.NewAgentRPC(mockAgent, s.TCPListener, os.Stderr, agent.NewLogWriter(42))
-
-	var (
-		useKeyCallCount     int
-		installKeyCallCount int
-		leaveCallCount      int
-		statsCallCount      int
-	)
-
-	for {
-		switch {
-		case mockAgent.UseKeyCallCount() > useKeyCallCount:
-			useKeyCallCount++
-			s.OutputWriter.UseKeyCalled()
-		case mockAgent.InstallKeyCallCount() > installKeyCallCount:
-			installKeyCallCount++
-			s.OutputWriter.InstallKeyCalled()
-		case mockAgent.LeaveCallCount() > leaveCallCount:
-			leaveCallCount++
-			s.OutputWriter.LeaveCalled()
-			agentRPCServer.Shutdown()
-			s.DidLeave = true
-		case mockAgent.StatsCallCount() > statsCallCount:
-			statsCallCount++
-			s.OutputWriter.StatsCalled()
-		}
-
-		time.Sleep(10 * time.Millisecond)
-	}
-}
-
 func (s Server) Exit() error {
+	s.RPCAgent.Shutdown()
+
 	err := s.HTTPListener.Close()
 	if err != nil {
 		return err
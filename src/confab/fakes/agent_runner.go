@@ -1,5 +1,11 @@
 package fakes
 
+import (
+	"confab/agent"
+
+	"github.com/hashicorp/consul/api"
+)
+
 type AgentRunner struct {
 	RunCalls struct {
 		CallCount int
@@ -77,10 +83,37 @@ type AgentClient struct {
 		}
 	}
 
+	VerifyLeaderCalls struct {
+		CallCount int
+		Returns   struct {
+			Errors []error
+		}
+	}
+
+	VerifyServicesCalls struct {
+		CallCount int
+		Receives  struct {
+			ServiceIDs []string
+		}
+		Returns struct {
+			Errors []error
+		}
+	}
+
+	VerifyDNSCalls struct {
+		CallCount int
+		Receives  struct {
+			Name string
+		}
+		Returns struct {
+			Errors []error
+		}
+	}
+
 	IsLastNodeCall struct {
 		Returns struct {
-			IsLastNode bool
-			Error      error
+			Result agent.LastNodeResult
+			Error  error
 		}
 	}
 
@@ -99,6 +132,85 @@ type AgentClient struct {
 			Error error
 		}
 	}
+
+	EnableMaintenanceCall struct {
+		CallCount int
+		Receives  struct {
+			ServiceID string
+			Reason    string
+		}
+		Returns struct {
+			Error error
+		}
+	}
+
+	DisableMaintenanceCall struct {
+		CallCount int
+		Receives  struct {
+			ServiceID string
+		}
+		Returns struct {
+			Error error
+		}
+	}
+
+	NodeMaintenanceReasonCall struct {
+		CallCount int
+		Returns   struct {
+			Reason string
+			Error  error
+		}
+	}
+
+	ReconcilePreparedQueriesCalls struct {
+		CallCount int
+		Receives  struct {
+			Queries []api.PreparedQueryDefinition
+		}
+		Returns struct {
+			Errors []error
+		}
+	}
+
+	SeedKVCalls struct {
+		CallCount int
+		Receives  struct {
+			Pairs   []api.KVPair
+			Enforce bool
+		}
+		Returns struct {
+			Errors []error
+		}
+	}
+
+	RaftPeersCall struct {
+		CallCount int
+		Returns   struct {
+			Peers []string
+			Error error
+		}
+	}
+
+	RemovePeerCalls struct {
+		CallCount int
+		Receives  struct {
+			Peers []string
+		}
+		Returns struct {
+			Errors []error
+		}
+	}
+
+	ProtocolCompatibilityCall struct {
+		CallCount int
+		Receives  struct {
+			Node string
+		}
+		Returns struct {
+			Compatibility agent.Compatibility
+			Error         error
+		}
+	}
 }
 
 func (c *AgentClient) VerifyJoined() error {
@@ -113,8 +225,34 @@ func (c *AgentClient) VerifySynced() error {
 	return err
 }
 
-func (c *AgentClient) IsLastNode() (bool, error) {
-	return c.IsLastNodeCall.Returns.IsLastNode, c.IsLastNodeCall.Returns.Error
+func (c *AgentClient) VerifyLeader() error {
+	err := c.VerifyLeaderCalls.Returns.Errors[c.VerifyLeaderCalls.CallCount]
+	c.VerifyLeaderCalls.CallCount++
+	return err
+}
+
+func (c *AgentClient) VerifyServices(serviceIDs []string) error {
+	c.VerifyServicesCalls.Receives.ServiceIDs = serviceIDs
+	err := c.VerifyServicesCalls.Returns.Errors[c.VerifyServicesCalls.CallCount]
+	c.VerifyServicesCalls.CallCount++
+	return err
+}
+
+func (c *AgentClient) VerifyDNS(name string) error {
+	c.VerifyDNSCalls.Receives.Name = name
+	err := c.VerifyDNSCalls.Returns.Errors[c.VerifyDNSCalls.CallCount]
+	c.VerifyDNSCalls.CallCount++
+	return err
+}
+
+func (c *AgentClient) ProtocolCompatibility(node string) (agent.Compatibility, error) {
+	c.ProtocolCompatibilityCall.CallCount++
+	c.ProtocolCompatibilityCall.Receives.Node = node
+	return c.ProtocolCompatibilityCall.Returns.Compatibility, c.ProtocolCompatibilityCall.Returns.Error
+}
+
+func (c *AgentClient) IsLastNode() (agent.LastNodeResult, error) {
+	return c.IsLastNodeCall.Returns.Result, c.IsLastNodeCall.Returns.Error
 }
 
 func (c *AgentClient) SetKeys(keys []string) error {
@@ -126,3 +264,48 @@ func (c *AgentClient) Leave() error {
 	c.LeaveCall.CallCount++
 	return c.LeaveCall.Returns.Error
 }
+
+func (c *AgentClient) EnableMaintenance(serviceID, reason string) error {
+	c.EnableMaintenanceCall.CallCount++
+	c.EnableMaintenanceCall.Receives.ServiceID = serviceID
+	c.EnableMaintenanceCall.Receives.Reason = reason
+	return c.EnableMaintenanceCall.Returns.Error
+}
+
+func (c *AgentClient) DisableMaintenance(serviceID string) error {
+	c.DisableMaintenanceCall.CallCount++
+	c.DisableMaintenanceCall.Receives.ServiceID = serviceID
+	return c.DisableMaintenanceCall.Returns.Error
+}
+
+func (c *AgentClient) NodeMaintenanceReason() (string, error) {
+	c.NodeMaintenanceReasonCall.CallCount++
+	return c.NodeMaintenanceReasonCall.Returns.Reason, c.NodeMaintenanceReasonCall.Returns.Error
+}
+
+func (c *AgentClient) ReconcilePreparedQueries(queries []api.PreparedQueryDefinition) error {
+	c.ReconcilePreparedQueriesCalls.Receives.Queries = queries
+	err := c.ReconcilePreparedQueriesCalls.Returns.Errors[c.ReconcilePreparedQueriesCalls.CallCount]
+	c.ReconcilePreparedQueriesCalls.CallCount++
+	return err
+}
+
+func (c *AgentClient) SeedKV(pairs []api.KVPair, enforce bool) error {
+	c.SeedKVCalls.Receives.Pairs = pairs
+	c.SeedKVCalls.Receives.Enforce = enforce
+	err := c.SeedKVCalls.Returns.Errors[c.SeedKVCalls.CallCount]
+	c.SeedKVCalls.CallCount++
+	return err
+}
+
+func (c *AgentClient) RaftPeers() ([]string, error) {
+	c.RaftPeersCall.CallCount++
+	return c.RaftPeersCall.Returns.Peers, c.RaftPeersCall.Returns.Error
+}
+
+func (c *AgentClient) RemovePeer(peer string) error {
+	c.RemovePeerCalls.Receives.Peers = append(c.RemovePeerCalls.Receives.Peers, peer)
+	err := c.RemovePeerCalls.Returns.Errors[c.RemovePeerCalls.CallCount]
+	c.RemovePeerCalls.CallCount++
+	return err
+}
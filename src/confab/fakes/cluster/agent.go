@@ -0,0 +1,211 @@
+package cluster
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/hashicorp/consul/api"
+	"github.com/hashicorp/consul/command/agent"
+	"github.com/hashicorp/serf/serf"
+)
+
+type AgentConfig struct {
+	Name     string
+	Addr     string
+	Server   bool
+	HTTPAddr string
+	RPCAddr  string
+}
+
+type Agent struct {
+	Config   AgentConfig
+	HTTPAddr string
+	RPCAddr  string
+
+	cluster      *Cluster
+	status       serf.MemberStatus
+	commitIndex  uint64
+	lastLogIndex uint64
+	faults       map[Fault]error
+	httpListener net.Listener
+	rpcListener  net.Listener
+	rpcServer    *agent.AgentRPC
+}
+
+func newAgent(cluster *Cluster, config AgentConfig) *Agent {
+	if config.HTTPAddr == "" {
+		config.HTTPAddr = "127.0.0.1:0"
+	}
+
+	if config.RPCAddr == "" {
+		config.RPCAddr = "127.0.0.1:0"
+	}
+
+	return &Agent{
+		Config:       config,
+		cluster:      cluster,
+		commitIndex:  1,
+		lastLogIndex: 1,
+		faults:       map[Fault]error{},
+	}
+}
+
+func (a *Agent) listen() error {
+	var err error
+	a.httpListener, err = net.Listen("tcp", a.Config.HTTPAddr)
+	if err != nil {
+		return err
+	}
+	a.HTTPAddr = a.httpListener.Addr().String()
+
+	a.rpcListener, err = net.Listen("tcp", a.Config.RPCAddr)
+	if err != nil {
+		a.httpListener.Close()
+		return err
+	}
+	a.RPCAddr = a.rpcListener.Addr().String()
+
+	a.rpcServer = agent.NewAgentRPC(backend{a}, a.rpcListener, os.Stderr, agent.NewLogWriter(42))
+	go http.Serve(a.httpListener, a.handler())
+
+	return nil
+}
+
+// Restart brings a stopped agent back on the addresses it used before, like
+// a VM coming back from a rolling deploy. The agent has to join again.
+func (a *Agent) Restart() error {
+	a.Config.HTTPAddr = a.HTTPAddr
+	a.Config.RPCAddr = a.RPCAddr
+
+	return a.listen()
+}
+
+// Stop shuts the agent down without leaving, so the rest of the cluster
+// sees it as failed.
+func (a *Agent) Stop() error {
+	a.cluster.mutex.Lock()
+	if a.status == serf.StatusAlive {
+		a.status = serf.StatusFailed
+	}
+	if a.cluster.leader == a.Config.Name {
+		a.cluster.leader = ""
+	}
+	a.cluster.mutex.Unlock()
+
+	if a.rpcServer != nil {
+		a.rpcServer.Shutdown()
+		a.rpcServer = nil
+	}
+
+	if a.httpListener != nil {
+		if err := a.httpListener.Close(); err != nil {
+			return err
+		}
+		a.httpListener = nil
+	}
+
+	if a.rpcListener != nil {
+		a.rpcListener.Close()
+		a.rpcListener = nil
+	}
+
+	return nil
+}
+
+func (a *Agent) handler() http.Handler {
+	mux := http.NewServeMux()
+
+	mux.HandleFunc("/v1/agent/members", func(w http.ResponseWriter, req *http.Request) {
+		if a.fail(w, FaultMembers) {
+			return
+		}
+
+		var members []api.AgentMember
+		for _, member := range a.cluster.Members() {
+			members = append(members, api.AgentMember{
+				Name:   member.Name,
+				Addr:   member.Addr.String(),
+				Port:   member.Port,
+				Tags:   member.Tags,
+				Status: int(member.Status),
+			})
+		}
+		json.NewEncoder(w).Encode(members)
+	})
+
+	mux.HandleFunc("/v1/status/leader", func(w http.ResponseWriter, req *http.Request) {
+		if a.fail(w, FaultLeader) {
+			return
+		}
+
+		a.cluster.mutex.Lock()
+		leader := a.cluster.leaderAddr()
+		a.cluster.mutex.Unlock()
+
+		json.NewEncoder(w).Encode(leader)
+	})
+
+	mux.HandleFunc("/v1/agent/services", func(w http.ResponseWriter, req *http.Request) {
+		json.NewEncoder(w).Encode(map[string]api.AgentService{})
+	})
+
+	mux.HandleFunc("/v1/agent/checks", func(w http.ResponseWriter, req *http.Request) {
+		json.NewEncoder(w).Encode(map[string]api.AgentCheck{})
+	})
+
+	mux.HandleFunc("/v1/kv/", func(w http.ResponseWriter, req *http.Request) {
+		if a.fail(w, FaultKV) {
+			return
+		}
+
+		key := strings.TrimPrefix(req.URL.Path, "/v1/kv/")
+		switch req.Method {
+		case "GET":
+			pair, ok := a.cluster.getKV(key)
+			if !ok {
+				w.WriteHeader(http.StatusNotFound)
+				return
+			}
+			json.NewEncoder(w).Encode([]api.KVPair{pair})
+		case "PUT":
+			value, err := ioutil.ReadAll(req.Body)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+
+			flags, _ := strconv.ParseUint(req.URL.Query().Get("flags"), 10, 64)
+
+			var cas *uint64
+			if raw := req.URL.Query().Get("cas"); raw != "" {
+				index, err := strconv.ParseUint(raw, 10, 64)
+				if err != nil {
+					http.Error(w, err.Error(), http.StatusBadRequest)
+					return
+				}
+				cas = &index
+			}
+
+			json.NewEncoder(w).Encode(a.cluster.putKV(key, value, flags, cas))
+		default:
+			w.WriteHeader(http.StatusMethodNotAllowed)
+		}
+	})
+
+	return mux
+}
+
+func (a *Agent) fail(w http.ResponseWriter, fault Fault) bool {
+	err := a.cluster.fault(a, fault)
+	if err == nil {
+		return false
+	}
+
+	http.Error(w, err.Error(), http.StatusInternalServerError)
+	return true
+}
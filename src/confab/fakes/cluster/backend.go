@@ -0,0 +1,125 @@
+package cluster
+
+import (
+	"strconv"
+
+	"github.com/hashicorp/consul/consul/structs"
+	"github.com/hashicorp/serf/serf"
+)
+
+type backend struct {
+	agent *Agent
+}
+
+func (b backend) ForceLeave(node string) error {
+	b.agent.cluster.Leave(node)
+	return nil
+}
+
+func (b backend) JoinWAN(addrs []string) (int, error) {
+	return 0, nil
+}
+
+func (b backend) JoinLAN(addrs []string) (int, error) {
+	b.agent.cluster.Join(b.agent.Config.Name)
+	return len(addrs), nil
+}
+
+func (b backend) LANMembers() []serf.Member {
+	return b.agent.cluster.Members()
+}
+
+func (b backend) WANMembers() []serf.Member {
+	return []serf.Member{}
+}
+
+func (b backend) Leave() error {
+	b.agent.cluster.Leave(b.agent.Config.Name)
+	return nil
+}
+
+func (b backend) Shutdown() error {
+	return nil
+}
+
+func (b backend) Stats() map[string]map[string]string {
+	c := b.agent.cluster
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	state := "Follower"
+	if c.leader == b.agent.Config.Name {
+		state = "Leader"
+	}
+
+	return map[string]map[string]string{
+		"agent": {
+			"check_monitors": "0",
+		},
+		"consul": {
+			"server": strconv.FormatBool(b.agent.Config.Server),
+			"leader": strconv.FormatBool(c.leader == b.agent.Config.Name),
+		},
+		"raft": {
+			"state":          state,
+			"commit_index":   strconv.FormatUint(b.agent.commitIndex, 10),
+			"last_log_index": strconv.FormatUint(b.agent.lastLogIndex, 10),
+			"num_peers":      strconv.Itoa(c.aliveCount() - 1),
+		},
+	}
+}
+
+func (b backend) ListKeys(token string) (*structs.KeyringResponses, error) {
+	if err := b.agent.cluster.fault(b.agent, FaultKeyring); err != nil {
+		return nil, err
+	}
+
+	return b.keyringResponses(), nil
+}
+
+func (b backend) InstallKey(key, token string) (*structs.KeyringResponses, error) {
+	if err := b.agent.cluster.fault(b.agent, FaultKeyring); err != nil {
+		return nil, err
+	}
+
+	b.agent.cluster.InstallKey(key)
+	return b.keyringResponses(), nil
+}
+
+func (b backend) UseKey(key, token string) (*structs.KeyringResponses, error) {
+	if err := b.agent.cluster.fault(b.agent, FaultKeyring); err != nil {
+		return nil, err
+	}
+
+	if err := b.agent.cluster.UseKey(key); err != nil {
+		return nil, err
+	}
+	return b.keyringResponses(), nil
+}
+
+func (b backend) RemoveKey(key, token string) (*structs.KeyringResponses, error) {
+	if err := b.agent.cluster.fault(b.agent, FaultKeyring); err != nil {
+		return nil, err
+	}
+
+	if err := b.agent.cluster.RemoveKey(key); err != nil {
+		return nil, err
+	}
+	return b.keyringResponses(), nil
+}
+
+func (b backend) keyringResponses() *structs.KeyringResponses {
+	c := b.agent.cluster
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	return &structs.KeyringResponses{
+		Responses: []*structs.KeyringResponse{
+			{
+				Datacenter: "dc1",
+				Keys:       c.keyCounts(),
+				NumNodes:   c.aliveCount(),
+			},
+		},
+	}
+}
@@ -0,0 +1,376 @@
+package cluster
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/hashicorp/consul/api"
+	"github.com/hashicorp/serf/serf"
+)
+
+type Fault string
+
+const (
+	FaultMembers Fault = "members"
+	FaultLeader  Fault = "leader"
+	FaultKV      Fault = "kv"
+	FaultKeyring Fault = "keyring"
+)
+
+type Cluster struct {
+	mutex               sync.Mutex
+	agents              []*Agent
+	leader              string
+	keys                []installedKey
+	keyPropagationDelay time.Duration
+	kv                  map[string]api.KVPair
+	kvIndex             uint64
+	timers              []*time.Timer
+	now                 func() time.Time
+}
+
+type installedKey struct {
+	key         string
+	installedAt time.Time
+}
+
+func New() *Cluster {
+	return &Cluster{
+		kv:  map[string]api.KVPair{},
+		now: time.Now,
+	}
+}
+
+func (c *Cluster) Start(config AgentConfig) (*Agent, error) {
+	c.mutex.Lock()
+	for _, agent := range c.agents {
+		if agent.Config.Name == config.Name {
+			c.mutex.Unlock()
+			return nil, fmt.Errorf("agent %q already exists", config.Name)
+		}
+	}
+	agent := newAgent(c, config)
+	c.agents = append(c.agents, agent)
+	c.mutex.Unlock()
+
+	if err := agent.listen(); err != nil {
+		return nil, err
+	}
+
+	return agent, nil
+}
+
+func (c *Cluster) Agent(name string) *Agent {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	return c.agent(name)
+}
+
+func (c *Cluster) agent(name string) *Agent {
+	for _, agent := range c.agents {
+		if agent.Config.Name == name {
+			return agent
+		}
+	}
+
+	return nil
+}
+
+func (c *Cluster) Join(name string) {
+	c.setStatus(name, serf.StatusAlive)
+}
+
+func (c *Cluster) Leave(name string) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	c.leave(name)
+}
+
+func (c *Cluster) Fail(name string) {
+	c.setStatus(name, serf.StatusFailed)
+}
+
+func (c *Cluster) setStatus(name string, status serf.MemberStatus) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if agent := c.agent(name); agent != nil {
+		agent.status = status
+	}
+}
+
+func (c *Cluster) leave(name string) {
+	agent := c.agent(name)
+	if agent == nil {
+		return
+	}
+
+	agent.status = serf.StatusLeft
+	if c.leader == agent.Config.Name {
+		c.leader = ""
+	}
+}
+
+func (c *Cluster) SetLeader(name string) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	c.leader = name
+}
+
+func (c *Cluster) SetRaftIndexes(name string, commitIndex, lastLogIndex uint64) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if agent := c.agent(name); agent != nil {
+		agent.commitIndex = commitIndex
+		agent.lastLogIndex = lastLogIndex
+	}
+}
+
+func (c *Cluster) InjectFault(name string, fault Fault, err error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if agent := c.agent(name); agent != nil {
+		agent.faults[fault] = err
+	}
+}
+
+func (c *Cluster) ClearFault(name string, fault Fault) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if agent := c.agent(name); agent != nil {
+		delete(agent.faults, fault)
+	}
+}
+
+func (c *Cluster) SetKeyPropagationDelay(delay time.Duration) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	c.keyPropagationDelay = delay
+}
+
+func (c *Cluster) After(delay time.Duration, step func(*Cluster)) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	c.timers = append(c.timers, time.AfterFunc(delay, func() {
+		step(c)
+	}))
+}
+
+func (c *Cluster) Close() error {
+	c.mutex.Lock()
+	for _, timer := range c.timers {
+		timer.Stop()
+	}
+	c.timers = nil
+	agents := c.agents
+	c.mutex.Unlock()
+
+	for _, agent := range agents {
+		if err := agent.Stop(); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (c *Cluster) Members() []serf.Member {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	return c.members()
+}
+
+func (c *Cluster) members() []serf.Member {
+	members := []serf.Member{}
+	for _, agent := range c.agents {
+		if agent.status == serf.StatusNone {
+			continue
+		}
+
+		role := "node"
+		if agent.Config.Server {
+			role = "consul"
+		}
+
+		members = append(members, serf.Member{
+			Name:   agent.Config.Name,
+			Addr:   net.ParseIP(agent.Config.Addr),
+			Port:   8301,
+			Tags:   map[string]string{"role": role},
+			Status: agent.status,
+		})
+	}
+
+	return members
+}
+
+func (c *Cluster) aliveCount() int {
+	var count int
+	for _, agent := range c.agents {
+		if agent.status == serf.StatusAlive {
+			count++
+		}
+	}
+
+	return count
+}
+
+func (c *Cluster) leaderAddr() string {
+	agent := c.agent(c.leader)
+	if agent == nil {
+		return ""
+	}
+
+	return fmt.Sprintf("%s:8300", agent.Config.Addr)
+}
+
+func (c *Cluster) Keys() map[string]int {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	return c.keyCounts()
+}
+
+func (c *Cluster) keyCounts() map[string]int {
+	alive := c.aliveCount()
+	now := c.now()
+
+	counts := map[string]int{}
+	for _, key := range c.keys {
+		if now.Sub(key.installedAt) >= c.keyPropagationDelay {
+			counts[key.key] = alive
+		} else {
+			counts[key.key] = 1
+		}
+	}
+
+	return counts
+}
+
+func (c *Cluster) PrimaryKey() string {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if len(c.keys) == 0 {
+		return ""
+	}
+
+	return c.keys[0].key
+}
+
+func (c *Cluster) InstallKey(key string) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	for _, installed := range c.keys {
+		if installed.key == key {
+			return
+		}
+	}
+
+	c.keys = append(c.keys, installedKey{key: key, installedAt: c.now()})
+}
+
+func (c *Cluster) UseKey(key string) error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	for i, installed := range c.keys {
+		if installed.key == key {
+			c.keys[0], c.keys[i] = c.keys[i], c.keys[0]
+			return nil
+		}
+	}
+
+	return fmt.Errorf("key %q is not installed", key)
+}
+
+func (c *Cluster) RemoveKey(key string) error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	for i, installed := range c.keys {
+		if installed.key != key {
+			continue
+		}
+
+		if i == 0 {
+			return errors.New("removing the primary key is not allowed")
+		}
+
+		c.keys = append(c.keys[:i], c.keys[i+1:]...)
+		return nil
+	}
+
+	return nil
+}
+
+func (c *Cluster) KV() map[string]string {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	values := map[string]string{}
+	for key, pair := range c.kv {
+		values[key] = string(pair.Value)
+	}
+
+	return values
+}
+
+func (c *Cluster) putKV(key string, value []byte, flags uint64, cas *uint64) bool {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	existing, ok := c.kv[key]
+	if cas != nil {
+		if *cas == 0 && ok {
+			return false
+		}
+
+		if *cas != 0 && (!ok || existing.ModifyIndex != *cas) {
+			return false
+		}
+	}
+
+	c.kvIndex++
+	pair := api.KVPair{
+		Key:         key,
+		Value:       value,
+		Flags:       flags,
+		CreateIndex: c.kvIndex,
+		ModifyIndex: c.kvIndex,
+	}
+	if ok {
+		pair.CreateIndex = existing.CreateIndex
+	}
+	c.kv[key] = pair
+
+	return true
+}
+
+func (c *Cluster) getKV(key string) (api.KVPair, bool) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	pair, ok := c.kv[key]
+	return pair, ok
+}
+
+func (c *Cluster) fault(agent *Agent, fault Fault) error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	return agent.faults[fault]
+}
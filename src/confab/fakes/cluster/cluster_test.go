@@ -0,0 +1,216 @@
+package cluster_test
+
+import (
+	"confab/fakes/cluster"
+	"errors"
+	"time"
+
+	"github.com/hashicorp/consul/api"
+	"github.com/hashicorp/serf/serf"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Cluster", func() {
+	var (
+		c       *cluster.Cluster
+		servers []*cluster.Agent
+	)
+
+	apiClient := func(agent *cluster.Agent) *api.Client {
+		config := api.DefaultConfig()
+		config.Address = agent.HTTPAddr
+		client, err := api.NewClient(config)
+		Expect(err).NotTo(HaveOccurred())
+		return client
+	}
+
+	BeforeEach(func() {
+		c = cluster.New()
+		servers = nil
+
+		for _, server := range []cluster.AgentConfig{
+			{Name: "consul-z1-0", Addr: "10.0.0.1", Server: true},
+			{Name: "consul-z1-1", Addr: "10.0.0.2", Server: true},
+			{Name: "consul-z2-0", Addr: "10.0.0.3", Server: true},
+		} {
+			agent, err := c.Start(server)
+			Expect(err).NotTo(HaveOccurred())
+			servers = append(servers, agent)
+		}
+	})
+
+	AfterEach(func() {
+		Expect(c.Close()).To(Succeed())
+	})
+
+	It("refuses to start two agents with the same name", func() {
+		_, err := c.Start(cluster.AgentConfig{Name: "consul-z1-0"})
+		Expect(err).To(MatchError(`agent "consul-z1-0" already exists`))
+	})
+
+	Describe("membership", func() {
+		It("serves the members that have joined", func() {
+			c.Join("consul-z1-0")
+			c.Join("consul-z1-1")
+
+			members, err := apiClient(servers[2]).Agent().Members(false)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(members).To(HaveLen(2))
+			Expect(members[0].Addr).To(Equal("10.0.0.1"))
+			Expect(members[0].Tags["role"]).To(Equal("consul"))
+			Expect(members[0].Status).To(Equal(int(serf.StatusAlive)))
+		})
+
+		It("reports stopped agents as failed and restarted agents as able to rejoin", func() {
+			c.Join("consul-z1-0")
+			c.Join("consul-z1-1")
+
+			Expect(servers[1].Stop()).To(Succeed())
+			Expect(c.Members()[1].Status).To(Equal(serf.StatusFailed))
+
+			Expect(servers[1].Restart()).To(Succeed())
+			c.Join("consul-z1-1")
+
+			members, err := apiClient(servers[1]).Agent().Members(false)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(members[1].Status).To(Equal(int(serf.StatusAlive)))
+		})
+
+		It("applies scripted membership changes over time", func() {
+			c.After(10*time.Millisecond, func(c *cluster.Cluster) {
+				c.Join("consul-z1-0")
+			})
+			c.After(20*time.Millisecond, func(c *cluster.Cluster) {
+				c.Join("consul-z1-1")
+			})
+
+			Expect(c.Members()).To(BeEmpty())
+			Eventually(c.Members).Should(HaveLen(2))
+		})
+
+		It("marks an agent as left when it leaves over rpc", func() {
+			c.Join("consul-z1-0")
+			Expect(cluster.Backend(servers[0]).Leave()).To(Succeed())
+			Expect(c.Members()[0].Status).To(Equal(serf.StatusLeft))
+		})
+	})
+
+	Describe("leadership", func() {
+		It("serves the current leader", func() {
+			leader, err := apiClient(servers[0]).Status().Leader()
+			Expect(err).NotTo(HaveOccurred())
+			Expect(leader).To(Equal(""))
+
+			c.SetLeader("consul-z1-1")
+			leader, err = apiClient(servers[0]).Status().Leader()
+			Expect(err).NotTo(HaveOccurred())
+			Expect(leader).To(Equal("10.0.0.2:8300"))
+		})
+
+		It("loses the leader when the leader stops", func() {
+			c.SetLeader("consul-z1-1")
+			Expect(servers[1].Stop()).To(Succeed())
+
+			leader, err := apiClient(servers[0]).Status().Leader()
+			Expect(err).NotTo(HaveOccurred())
+			Expect(leader).To(Equal(""))
+		})
+	})
+
+	Describe("raft stats", func() {
+		It("reports the scripted indexes", func() {
+			c.SetRaftIndexes("consul-z1-0", 5, 7)
+
+			stats := cluster.Backend(servers[0]).Stats()
+			Expect(stats["raft"]["commit_index"]).To(Equal("5"))
+			Expect(stats["raft"]["last_log_index"]).To(Equal("7"))
+		})
+	})
+
+	Describe("keyring", func() {
+		BeforeEach(func() {
+			c.Join("consul-z1-0")
+			c.Join("consul-z1-1")
+			c.Join("consul-z2-0")
+		})
+
+		It("rotates keys", func() {
+			backend := cluster.Backend(servers[0])
+
+			_, err := backend.InstallKey("old-key", "")
+			Expect(err).NotTo(HaveOccurred())
+			_, err = backend.InstallKey("new-key", "")
+			Expect(err).NotTo(HaveOccurred())
+			_, err = backend.UseKey("new-key", "")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(c.PrimaryKey()).To(Equal("new-key"))
+
+			responses, err := backend.RemoveKey("old-key", "")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(responses.Responses[0].Keys).To(Equal(map[string]int{"new-key": 3}))
+			Expect(responses.Responses[0].NumNodes).To(Equal(3))
+		})
+
+		It("refuses to use a key that is not installed or remove the primary key", func() {
+			c.InstallKey("key")
+
+			_, err := cluster.Backend(servers[0]).UseKey("other-key", "")
+			Expect(err).To(MatchError(`key "other-key" is not installed`))
+
+			_, err = cluster.Backend(servers[0]).RemoveKey("key", "")
+			Expect(err).To(MatchError("removing the primary key is not allowed"))
+		})
+
+		It("only counts a new key on all members once it has propagated", func() {
+			c.SetKeyPropagationDelay(50 * time.Millisecond)
+			c.InstallKey("key")
+
+			Expect(c.Keys()).To(Equal(map[string]int{"key": 1}))
+			Eventually(c.Keys).Should(Equal(map[string]int{"key": 3}))
+		})
+	})
+
+	Describe("kv", func() {
+		It("supports check-and-set writes", func() {
+			kv := apiClient(servers[0]).KV()
+
+			ok, _, err := kv.CAS(&api.KVPair{Key: "features/ui", Value: []byte("on"), ModifyIndex: 0}, nil)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(ok).To(BeTrue())
+
+			ok, _, err = kv.CAS(&api.KVPair{Key: "features/ui", Value: []byte("off"), ModifyIndex: 0}, nil)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(ok).To(BeFalse())
+
+			pair, _, err := apiClient(servers[1]).KV().Get("features/ui", nil)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(string(pair.Value)).To(Equal("on"))
+			Expect(c.KV()).To(Equal(map[string]string{"features/ui": "on"}))
+		})
+	})
+
+	Describe("fault injection", func() {
+		It("fails the faulted endpoint until the fault is cleared", func() {
+			c.InjectFault("consul-z1-0", cluster.FaultMembers, errors.New("members unavailable"))
+
+			_, err := apiClient(servers[0]).Agent().Members(false)
+			Expect(err).To(MatchError(ContainSubstring("members unavailable")))
+
+			_, err = apiClient(servers[1]).Agent().Members(false)
+			Expect(err).NotTo(HaveOccurred())
+
+			c.ClearFault("consul-z1-0", cluster.FaultMembers)
+			_, err = apiClient(servers[0]).Agent().Members(false)
+			Expect(err).NotTo(HaveOccurred())
+		})
+
+		It("fails keyring operations", func() {
+			c.InjectFault("consul-z1-0", cluster.FaultKeyring, errors.New("keyring unavailable"))
+
+			_, err := cluster.Backend(servers[0]).InstallKey("key", "")
+			Expect(err).To(MatchError("keyring unavailable"))
+		})
+	})
+})
@@ -0,0 +1,7 @@
+package cluster
+
+import "github.com/hashicorp/consul/command/agent"
+
+func Backend(a *Agent) agent.AgentBackend {
+	return backend{a}
+}
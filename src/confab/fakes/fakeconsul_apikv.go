@@ -0,0 +1,105 @@
+// This file was generated by counterfeiter
+package fakes
+
+import (
+	"sync"
+
+	"github.com/hashicorp/consul/api"
+)
+
+type FakeconsulAPIKV struct {
+	GetStub        func(key string, q *api.QueryOptions) (*api.KVPair, *api.QueryMeta, error)
+	getMutex       sync.RWMutex
+	getArgsForCall []struct {
+		key string
+		q   *api.QueryOptions
+	}
+	getReturns struct {
+		result1 *api.KVPair
+		result2 *api.QueryMeta
+		result3 error
+	}
+	CASStub        func(p *api.KVPair, q *api.WriteOptions) (bool, *api.WriteMeta, error)
+	cASMutex       sync.RWMutex
+	cASArgsForCall []struct {
+		p *api.KVPair
+		q *api.WriteOptions
+	}
+	cASReturns struct {
+		result1 bool
+		result2 *api.WriteMeta
+		result3 error
+	}
+}
+
+func (fake *FakeconsulAPIKV) Get(key string, q *api.QueryOptions) (*api.KVPair, *api.QueryMeta, error) {
+	fake.getMutex.Lock()
+	fake.getArgsForCall = append(fake.getArgsForCall, struct {
+		key string
+		q   *api.QueryOptions
+	}{key, q})
+	fake.getMutex.Unlock()
+	if fake.GetStub != nil {
+		return fake.GetStub(key, q)
+	} else {
+		return fake.getReturns.result1, fake.getReturns.result2, fake.getReturns.result3
+	}
+}
+
+func (fake *FakeconsulAPIKV) GetCallCount() int {
+	fake.getMutex.RLock()
+	defer fake.getMutex.RUnlock()
+	return len(fake.getArgsForCall)
+}
+
+func (fake *FakeconsulAPIKV) GetArgsForCall(i int) (string, *api.QueryOptions) {
+	fake.getMutex.RLock()
+	defer fake.getMutex.RUnlock()
+	return fake.getArgsForCall[i].key, fake.getArgsForCall[i].q
+}
+
+func (fake *FakeconsulAPIKV) GetReturns(result1 *api.KVPair, result2 *api.QueryMeta, result3 error) {
+	fake.GetStub = nil
+	fake.getReturns = struct {
+		result1 *api.KVPair
+		result2 *api.QueryMeta
+		result3 error
+	}{result1, result2, result3}
+}
+
+func (fake *FakeconsulAPIKV) CAS(p *api.KVPair, q *api.WriteOptions) (bool, *api.WriteMeta, error) {
+	fake.cASMutex.Lock()
+	fake.cASArgsForCall = append(fake.cASArgsForCall, struct {
+		p *api.KVPair
+		q *api.WriteOptions
+	}{p, q})
+	fake.cASMutex.Unlock()
+	if fake.CASStub != nil {
+		return fake.CASStub(p, q)
+	} else {
+		return fake.cASReturns.result1, fake.cASReturns.result2, fake.cASReturns.result3
+	}
+}
+
+func (fake *FakeconsulAPIKV) CASCallCount() int {
+	fake.cASMutex.RLock()
+	defer fake.cASMutex.RUnlock()
+	return len(fake.cASArgsForCall)
+}
+
+func (fake *FakeconsulAPIKV) CASArgsForCall(i int) (*api.KVPair, *api.WriteOptions) {
+	fake.cASMutex.RLock()
+	defer fake.cASMutex.RUnlock()
+	return fake.cASArgsForCall[i].p, fake.cASArgsForCall[i].q
+}
+
+func (fake *FakeconsulAPIKV) CASReturns(result1 bool, result2 *api.WriteMeta, result3 error) {
+	fake.CASStub = nil
+	fake.cASReturns = struct {
+		result1 bool
+		result2 *api.WriteMeta
+		result3 error
+	}{result1, result2, result3}
+}
+
+// var _ agent.consulAPIKV = new(FakeconsulAPIKV)
@@ -0,0 +1,191 @@
+// This file was generated by counterfeiter
+package fakes
+
+import (
+	"sync"
+
+	"github.com/hashicorp/consul/api"
+)
+
+type FakeconsulAPIPreparedQuery struct {
+	ListStub        func(q *api.QueryOptions) ([]*api.PreparedQueryDefinition, *api.QueryMeta, error)
+	listMutex       sync.RWMutex
+	listArgsForCall []struct {
+		q *api.QueryOptions
+	}
+	listReturns struct {
+		result1 []*api.PreparedQueryDefinition
+		result2 *api.QueryMeta
+		result3 error
+	}
+	CreateStub        func(query *api.PreparedQueryDefinition, q *api.WriteOptions) (string, *api.WriteMeta, error)
+	createMutex       sync.RWMutex
+	createArgsForCall []struct {
+		query *api.PreparedQueryDefinition
+		q     *api.WriteOptions
+	}
+	createReturns struct {
+		result1 string
+		result2 *api.WriteMeta
+		result3 error
+	}
+	UpdateStub        func(query *api.PreparedQueryDefinition, q *api.WriteOptions) (*api.WriteMeta, error)
+	updateMutex       sync.RWMutex
+	updateArgsForCall []struct {
+		query *api.PreparedQueryDefinition
+		q     *api.WriteOptions
+	}
+	updateReturns struct {
+		result1 *api.WriteMeta
+		result2 error
+	}
+	DeleteStub        func(queryID string, q *api.QueryOptions) (*api.QueryMeta, error)
+	deleteMutex       sync.RWMutex
+	deleteArgsForCall []struct {
+		queryID string
+		q       *api.QueryOptions
+	}
+	deleteReturns struct {
+		result1 *api.QueryMeta
+		result2 error
+	}
+}
+
+func (fake *FakeconsulAPIPreparedQuery) List(q *api.QueryOptions) ([]*api.PreparedQueryDefinition, *api.QueryMeta, error) {
+	fake.listMutex.Lock()
+	fake.listArgsForCall = append(fake.listArgsForCall, struct {
+		q *api.QueryOptions
+	}{q})
+	fake.listMutex.Unlock()
+	if fake.ListStub != nil {
+		return fake.ListStub(q)
+	} else {
+		return fake.listReturns.result1, fake.listReturns.result2, fake.listReturns.result3
+	}
+}
+
+func (fake *FakeconsulAPIPreparedQuery) ListCallCount() int {
+	fake.listMutex.RLock()
+	defer fake.listMutex.RUnlock()
+	return len(fake.listArgsForCall)
+}
+
+func (fake *FakeconsulAPIPreparedQuery) ListArgsForCall(i int) *api.QueryOptions {
+	fake.listMutex.RLock()
+	defer fake.listMutex.RUnlock()
+	return fake.listArgsForCall[i].q
+}
+
+func (fake *FakeconsulAPIPreparedQuery) ListReturns(result1 []*api.PreparedQueryDefinition, result2 *api.QueryMeta, result3 error) {
+	fake.ListStub = nil
+	fake.listReturns = struct {
+		result1 []*api.PreparedQueryDefinition
+		result2 *api.QueryMeta
+		result3 error
+	}{result1, result2, result3}
+}
+
+func (fake *FakeconsulAPIPreparedQuery) Create(query *api.PreparedQueryDefinition, q *api.WriteOptions) (string, *api.WriteMeta, error) {
+	fake.createMutex.Lock()
+	fake.createArgsForCall = append(fake.createArgsForCall, struct {
+		query *api.PreparedQueryDefinition
+		q     *api.WriteOptions
+	}{query, q})
+	fake.createMutex.Unlock()
+	if fake.CreateStub != nil {
+		return fake.CreateStub(query, q)
+	} else {
+		return fake.createReturns.result1, fake.createReturns.result2, fake.createReturns.result3
+	}
+}
+
+func (fake *FakeconsulAPIPreparedQuery) CreateCallCount() int {
+	fake.createMutex.RLock()
+	defer fake.createMutex.RUnlock()
+	return len(fake.createArgsForCall)
+}
+
+func (fake *FakeconsulAPIPreparedQuery) CreateArgsForCall(i int) (*api.PreparedQueryDefinition, *api.WriteOptions) {
+	fake.createMutex.RLock()
+	defer fake.createMutex.RUnlock()
+	return fake.createArgsForCall[i].query, fake.createArgsForCall[i].q
+}
+
+func (fake *FakeconsulAPIPreparedQuery) CreateReturns(result1 string, result2 *api.WriteMeta, result3 error) {
+	fake.CreateStub = nil
+	fake.createReturns = struct {
+		result1 string
+		result2 *api.WriteMeta
+		result3 error
+	}{result1, result2, result3}
+}
+
+func (fake *FakeconsulAPIPreparedQuery) Update(query *api.PreparedQueryDefinition, q *api.WriteOptions) (*api.WriteMeta, error) {
+	fake.updateMutex.Lock()
+	fake.updateArgsForCall = append(fake.updateArgsForCall, struct {
+		query *api.PreparedQueryDefinition
+		q     *api.WriteOptions
+	}{query, q})
+	fake.updateMutex.Unlock()
+	if fake.UpdateStub != nil {
+		return fake.UpdateStub(query, q)
+	} else {
+		return fake.updateReturns.result1, fake.updateReturns.result2
+	}
+}
+
+func (fake *FakeconsulAPIPreparedQuery) UpdateCallCount() int {
+	fake.updateMutex.RLock()
+	defer fake.updateMutex.RUnlock()
+	return len(fake.updateArgsForCall)
+}
+
+func (fake *FakeconsulAPIPreparedQuery) UpdateArgsForCall(i int) (*api.PreparedQueryDefinition, *api.WriteOptions) {
+	fake.updateMutex.RLock()
+	defer fake.updateMutex.RUnlock()
+	return fake.updateArgsForCall[i].query, fake.updateArgsForCall[i].q
+}
+
+func (fake *FakeconsulAPIPreparedQuery) UpdateReturns(result1 *api.WriteMeta, result2 error) {
+	fake.UpdateStub = nil
+	fake.updateReturns = struct {
+		result1 *api.WriteMeta
+		result2 error
+	}{result1, result2}
+}
+
+func (fake *FakeconsulAPIPreparedQuery) Delete(queryID string, q *api.QueryOptions) (*api.QueryMeta, error) {
+	fake.deleteMutex.Lock()
+	fake.deleteArgsForCall = append(fake.deleteArgsForCall, struct {
+		queryID string
+		q       *api.QueryOptions
+	}{queryID, q})
+	fake.deleteMutex.Unlock()
+	if fake.DeleteStub != nil {
+		return fake.DeleteStub(queryID, q)
+	} else {
+		return fake.deleteReturns.result1, fake.deleteReturns.result2
+	}
+}
+
+func (fake *FakeconsulAPIPreparedQuery) DeleteCallCount() int {
+	fake.deleteMutex.RLock()
+	defer fake.deleteMutex.RUnlock()
+	return len(fake.deleteArgsForCall)
+}
+
+func (fake *FakeconsulAPIPreparedQuery) DeleteArgsForCall(i int) (string, *api.QueryOptions) {
+	fake.deleteMutex.RLock()
+	defer fake.deleteMutex.RUnlock()
+	return fake.deleteArgsForCall[i].queryID, fake.deleteArgsForCall[i].q
+}
+
+func (fake *FakeconsulAPIPreparedQuery) DeleteReturns(result1 *api.QueryMeta, result2 error) {
+	fake.DeleteStub = nil
+	fake.deleteReturns = struct {
+		result1 *api.QueryMeta
+		result2 error
+	}{result1, result2}
+}
+
+// var _ agent.consulAPIPreparedQuery = new(FakeconsulAPIPreparedQuery)
@@ -0,0 +1,135 @@
+// This file was generated by counterfeiter
+package fakes
+
+import "sync"
+
+type FakettlUpdater struct {
+	PassTTLStub        func(checkID, note string) error
+	passTTLMutex       sync.RWMutex
+	passTTLArgsForCall []struct {
+		checkID string
+		note    string
+	}
+	passTTLReturns struct {
+		result1 error
+	}
+	WarnTTLStub        func(checkID, note string) error
+	warnTTLMutex       sync.RWMutex
+	warnTTLArgsForCall []struct {
+		checkID string
+		note    string
+	}
+	warnTTLReturns struct {
+		result1 error
+	}
+	FailTTLStub        func(checkID, note string) error
+	failTTLMutex       sync.RWMutex
+	failTTLArgsForCall []struct {
+		checkID string
+		note    string
+	}
+	failTTLReturns struct {
+		result1 error
+	}
+}
+
+func (fake *FakettlUpdater) PassTTL(checkID string, note string) error {
+	fake.passTTLMutex.Lock()
+	fake.passTTLArgsForCall = append(fake.passTTLArgsForCall, struct {
+		checkID string
+		note    string
+	}{checkID, note})
+	fake.passTTLMutex.Unlock()
+	if fake.PassTTLStub != nil {
+		return fake.PassTTLStub(checkID, note)
+	} else {
+		return fake.passTTLReturns.result1
+	}
+}
+
+func (fake *FakettlUpdater) PassTTLCallCount() int {
+	fake.passTTLMutex.RLock()
+	defer fake.passTTLMutex.RUnlock()
+	return len(fake.passTTLArgsForCall)
+}
+
+func (fake *FakettlUpdater) PassTTLArgsForCall(i int) (string, string) {
+	fake.passTTLMutex.RLock()
+	defer fake.passTTLMutex.RUnlock()
+	return fake.passTTLArgsForCall[i].checkID, fake.passTTLArgsForCall[i].note
+}
+
+func (fake *FakettlUpdater) PassTTLReturns(result1 error) {
+	fake.PassTTLStub = nil
+	fake.passTTLReturns = struct {
+		result1 error
+	}{result1}
+}
+
+func (fake *FakettlUpdater) WarnTTL(checkID string, note string) error {
+	fake.warnTTLMutex.Lock()
+	fake.warnTTLArgsForCall = append(fake.warnTTLArgsForCall, struct {
+		checkID string
+		note    string
+	}{checkID, note})
+	fake.warnTTLMutex.Unlock()
+	if fake.WarnTTLStub != nil {
+		return fake.WarnTTLStub(checkID, note)
+	} else {
+		return fake.warnTTLReturns.result1
+	}
+}
+
+func (fake *FakettlUpdater) WarnTTLCallCount() int {
+	fake.warnTTLMutex.RLock()
+	defer fake.warnTTLMutex.RUnlock()
+	return len(fake.warnTTLArgsForCall)
+}
+
+func (fake *FakettlUpdater) WarnTTLArgsForCall(i int) (string, string) {
+	fake.warnTTLMutex.RLock()
+	defer fake.warnTTLMutex.RUnlock()
+	return fake.warnTTLArgsForCall[i].checkID, fake.warnTTLArgsForCall[i].note
+}
+
+func (fake *FakettlUpdater) WarnTTLReturns(result1 error) {
+	fake.WarnTTLStub = nil
+	fake.warnTTLReturns = struct {
+		result1 error
+	}{result1}
+}
+
+func (fake *FakettlUpdater) FailTTL(checkID string, note string) error {
+	fake.failTTLMutex.Lock()
+	fake.failTTLArgsForCall = append(fake.failTTLArgsForCall, struct {
+		checkID string
+		note    string
+	}{checkID, note})
+	fake.failTTLMutex.Unlock()
+	if fake.FailTTLStub != nil {
+		return fake.FailTTLStub(checkID, note)
+	} else {
+		return fake.failTTLReturns.result1
+	}
+}
+
+func (fake *FakettlUpdater) FailTTLCallCount() int {
+	fake.failTTLMutex.RLock()
+	defer fake.failTTLMutex.RUnlock()
+	return len(fake.failTTLArgsForCall)
+}
+
+func (fake *FakettlUpdater) FailTTLArgsForCall(i int) (string, string) {
+	fake.failTTLMutex.RLock()
+	defer fake.failTTLMutex.RUnlock()
+	return fake.failTTLArgsForCall[i].checkID, fake.failTTLArgsForCall[i].note
+}
+
+func (fake *FakettlUpdater) FailTTLReturns(result1 error) {
+	fake.FailTTLStub = nil
+	fake.failTTLReturns = struct {
+		result1 error
+	}{result1}
+}
+
+// var _ check.ttlUpdater = new(FakettlUpdater)
@@ -9,6 +9,7 @@ import (
 	"os"
 	"os/signal"
 	"path/filepath"
+	"syscall"
 	"time"
 )
 
@@ -25,8 +26,15 @@ func (ss *stringSlice) Set(value string) error {
 }
 
 type outputData struct {
-	Args []string
-	PID  int
+	Args       []string
+	PID        int
+	Dir        string
+	GOMAXPROCS string
+	Env        []string
+	UID        int
+	GID        int
+	Core       uint64
+	NoFile     uint64
 }
 
 func main() {
@@ -36,6 +44,28 @@ func main() {
 	var data outputData
 	data.PID = os.Getpid()
 	data.Args = os.Args[1:]
+	data.GOMAXPROCS = os.Getenv("GOMAXPROCS")
+	data.Env = os.Environ()
+	data.UID = os.Getuid()
+	data.GID = os.Getgid()
+
+	var err error
+	data.Dir, err = os.Getwd()
+	if err != nil {
+		panic(err)
+	}
+
+	var core syscall.Rlimit
+	if err := syscall.Getrlimit(syscall.RLIMIT_CORE, &core); err != nil {
+		panic(err)
+	}
+	data.Core = core.Cur
+
+	var nofile syscall.Rlimit
+	if err := syscall.Getrlimit(syscall.RLIMIT_NOFILE, &nofile); err != nil {
+		panic(err)
+	}
+	data.NoFile = nofile.Cur
 
 	// validate command line arguments
 	// expect them to look like
@@ -9,6 +9,7 @@ type ServiceDefiner struct {
 		}
 		Returns struct {
 			Definitions []confab.ServiceDefinition
+			Error       error
 		}
 	}
 	WriteDefinitionsCall struct {
@@ -28,7 +29,7 @@ func (d *ServiceDefiner) WriteDefinitions(configDir string, definitions []confab
 	return d.WriteDefinitionsCall.Returns.Error
 }
 
-func (d *ServiceDefiner) GenerateDefinitions(config confab.Config) []confab.ServiceDefinition {
+func (d *ServiceDefiner) GenerateDefinitions(config confab.Config) ([]confab.ServiceDefinition, error) {
 	d.GenerateDefinitionsCall.Receives.Config = config
-	return d.GenerateDefinitionsCall.Returns.Definitions
+	return d.GenerateDefinitionsCall.Returns.Definitions, d.GenerateDefinitionsCall.Returns.Error
 }
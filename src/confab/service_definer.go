@@ -1,29 +1,66 @@
 package confab
 
 import (
+	"bytes"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"os"
 	"path/filepath"
+	"sort"
 	"strings"
+	"text/template"
 
 	"github.com/pivotal-golang/lager"
 )
 
-var createFile = os.Create
+var (
+	createFile = os.Create
+	removeFile = os.Remove
+)
 
 type ServiceDefinition struct {
-	ServiceName       string                   `json:"-"`
-	Name              string                   `json:"name"`
-	Check             *ServiceDefinitionCheck  `json:"check,omitempty"`
-	Checks            []ServiceDefinitionCheck `json:"checks,omitempty"`
-	Tags              []string                 `json:"tags,omitempty"`
-	Address           string                   `json:"address,omitempty"`
-	Port              int                      `json:"port,omitempty"`
-	EnableTagOverride bool                     `json:"enableTagOverride,omitempty"`
-	ID                string                   `json:"id,omitempty"`
-	Token             string                   `json:"token,omitempty"`
+	ServiceName       string                    `json:"-"`
+	Name              string                    `json:"name"`
+	Check             *ServiceDefinitionCheck   `json:"check,omitempty"`
+	Checks            []ServiceDefinitionCheck  `json:"checks,omitempty"`
+	Tags              []string                  `json:"tags,omitempty"`
+	Address           string                    `json:"address,omitempty"`
+	Port              int                       `json:"port,omitempty"`
+	EnableTagOverride bool                      `json:"enableTagOverride,omitempty"`
+	ID                string                    `json:"id,omitempty"`
+	Token             string                    `json:"token,omitempty"`
+	Kind              string                    `json:"kind,omitempty"`
+	Meta              map[string]string         `json:"meta,omitempty"`
+	Weights           *ServiceDefinitionWeights `json:"weights,omitempty"`
+	Proxy             *ServiceDefinitionProxy   `json:"proxy,omitempty"`
+	Connect           *ServiceDefinitionConnect `json:"connect,omitempty"`
+}
+
+type ServiceDefinitionWeights struct {
+	Passing int `json:"passing"`
+	Warning int `json:"warning"`
+}
+
+type ServiceDefinitionProxy struct {
+	DestinationServiceName string                      `json:"destination_service_name,omitempty"`
+	DestinationServiceID   string                      `json:"destination_service_id,omitempty"`
+	LocalServiceAddress    string                      `json:"local_service_address,omitempty"`
+	LocalServicePort       int                         `json:"local_service_port,omitempty"`
+	Upstreams              []ServiceDefinitionUpstream `json:"upstreams,omitempty"`
+}
+
+type ServiceDefinitionUpstream struct {
+	DestinationType  string `json:"destination_type,omitempty"`
+	DestinationName  string `json:"destination_name"`
+	Datacenter       string `json:"datacenter,omitempty"`
+	LocalBindAddress string `json:"local_bind_address,omitempty"`
+	LocalBindPort    int    `json:"local_bind_port"`
+}
+
+type ServiceDefinitionConnect struct {
+	Native         bool               `json:"native,omitempty"`
+	SidecarService *ServiceDefinition `json:"sidecar_service,omitempty"`
 }
 
 type ServiceDefinitionCheck struct {
@@ -46,10 +83,17 @@ type ServiceDefiner struct {
 	Logger logger
 }
 
-func (s ServiceDefiner) GenerateDefinitions(config Config) []ServiceDefinition {
+func (s ServiceDefiner) GenerateDefinitions(config Config) ([]ServiceDefinition, error) {
 	definitions := []ServiceDefinition{}
 
-	for name, service := range config.Consul.Agent.Services {
+	var names []string
+	for name := range config.Consul.Agent.Services {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		service := config.Consul.Agent.Services[name]
 		s.Logger.Info("service-definer.generate-definitions.define", lager.Data{
 			"service": name,
 		})
@@ -62,12 +106,17 @@ func (s ServiceDefiner) GenerateDefinitions(config Config) []ServiceDefinition {
 				Interval: "3s",
 			},
 			Checks:            service.Checks,
-			Tags:              []string{fmt.Sprintf("%s-%d", strings.Replace(config.Node.Name, "_", "-", -1), config.Node.Index)},
+			Tags:              defaultTags(config.Node),
 			Address:           service.Address,
 			Port:              service.Port,
 			EnableTagOverride: service.EnableTagOverride,
 			ID:                service.ID,
 			Token:             service.Token,
+			Kind:              service.Kind,
+			Meta:              service.Meta,
+			Weights:           service.Weights,
+			Proxy:             service.Proxy,
+			Connect:           service.Connect,
 		}
 
 		if service.Name != "" {
@@ -82,15 +131,239 @@ func (s ServiceDefiner) GenerateDefinitions(config Config) []ServiceDefinition {
 			definition.Tags = service.Tags
 		}
 
+		if err := expandTemplates(&definition, config.Node); err != nil {
+			s.Logger.Error("service-definer.generate-definitions.expand-templates.failed", err, lager.Data{
+				"service": name,
+			})
+			return nil, err
+		}
+
+		if err := validateDefinition(definition, config.Consul.Agent.Connect.Enabled); err != nil {
+			s.Logger.Error("service-definer.generate-definitions.invalid", err, lager.Data{
+				"service": name,
+			})
+			return nil, err
+		}
+
 		definitions = append(definitions, definition)
 	}
 
-	return definitions
+	if err := checkCollisions(definitions); err != nil {
+		s.Logger.Error("service-definer.generate-definitions.collision", err)
+		return nil, err
+	}
+
+	return definitions, nil
+}
+
+type templateData struct {
+	Node ConfigNode
+}
+
+type templateExpander struct {
+	data templateData
+	err  error
+}
+
+func (e *templateExpander) expand(field, value string) string {
+	if e.err != nil || !strings.Contains(value, "{{") {
+		return value
+	}
+
+	t, err := template.New(field).Option("missingkey=error").Parse(value)
+	if err != nil {
+		e.err = fmt.Errorf("%s: %s", field, err)
+		return value
+	}
+
+	var buffer bytes.Buffer
+	if err := t.Execute(&buffer, e.data); err != nil {
+		e.err = fmt.Errorf("%s: %s", field, err)
+		return value
+	}
+
+	return buffer.String()
+}
+
+func (e *templateExpander) expandCheck(field string, check ServiceDefinitionCheck) ServiceDefinitionCheck {
+	check.Script = e.expand(field+".script", check.Script)
+	check.HTTP = e.expand(field+".http", check.HTTP)
+	check.TCP = e.expand(field+".tcp", check.TCP)
+	return check
+}
+
+func expandTemplates(definition *ServiceDefinition, node ConfigNode) error {
+	e := &templateExpander{data: templateData{Node: node}}
+
+	definition.Address = e.expand("address", definition.Address)
+
+	if definition.Tags != nil {
+		tags := make([]string, len(definition.Tags))
+		for i, tag := range definition.Tags {
+			tags[i] = e.expand(fmt.Sprintf("tags[%d]", i), tag)
+		}
+		definition.Tags = tags
+	}
+
+	if definition.Meta != nil {
+		meta := map[string]string{}
+		for key, value := range definition.Meta {
+			meta[key] = e.expand(fmt.Sprintf("meta.%s", key), value)
+		}
+		definition.Meta = meta
+	}
+
+	if definition.Check != nil {
+		check := e.expandCheck("check", *definition.Check)
+		definition.Check = &check
+	}
+
+	if definition.Checks != nil {
+		checks := make([]ServiceDefinitionCheck, len(definition.Checks))
+		for i, check := range definition.Checks {
+			checks[i] = e.expandCheck(fmt.Sprintf("checks[%d]", i), check)
+		}
+		definition.Checks = checks
+	}
+
+	if e.err != nil {
+		return fmt.Errorf("service %q has an invalid template in %s", definition.ServiceName, e.err)
+	}
+
+	return nil
+}
+
+const (
+	maxServiceMetaKeys        = 64
+	maxServiceMetaKeyLength   = 128
+	maxServiceMetaValueLength = 512
+)
+
+func validateDefinition(definition ServiceDefinition, connectEnabled bool) error {
+	name := definition.ServiceName
+
+	switch definition.Kind {
+	case "":
+		if definition.Proxy != nil {
+			return fmt.Errorf("service %q sets proxy but is not of kind \"connect-proxy\"", name)
+		}
+	case "connect-proxy":
+		if definition.Proxy == nil || definition.Proxy.DestinationServiceName == "" {
+			return fmt.Errorf("service %q is a connect-proxy and is missing proxy.destination_service_name", name)
+		}
+		if definition.Port == 0 {
+			return fmt.Errorf("service %q is a connect-proxy and is missing a port", name)
+		}
+	default:
+		return fmt.Errorf("service %q has unknown kind %q", name, definition.Kind)
+	}
+
+	if definition.Connect != nil {
+		if definition.Connect.Native && definition.Connect.SidecarService != nil {
+			return fmt.Errorf("service %q cannot be connect native and have a sidecar_service", name)
+		}
+
+		if definition.Connect.SidecarService != nil && definition.Kind == "connect-proxy" {
+			return fmt.Errorf("service %q is a connect-proxy and cannot have a sidecar_service", name)
+		}
+	}
+
+	proxy := definition.Proxy
+	if definition.Connect != nil && definition.Connect.SidecarService != nil {
+		proxy = definition.Connect.SidecarService.Proxy
+	}
+	if proxy != nil {
+		for i, upstream := range proxy.Upstreams {
+			if upstream.DestinationName == "" || upstream.LocalBindPort == 0 {
+				return fmt.Errorf("service %q upstream %d needs a destination_name and a local_bind_port", name, i)
+			}
+		}
+	}
+
+	if (definition.Connect != nil || definition.Kind == "connect-proxy") && !connectEnabled {
+		return fmt.Errorf("service %q uses connect but consul.agent.connect.enabled is false", name)
+	}
+
+	if definition.Weights != nil {
+		if definition.Weights.Passing < 1 || definition.Weights.Warning < 0 {
+			return fmt.Errorf("service %q weights must have passing >= 1 and warning >= 0", name)
+		}
+	}
+
+	if len(definition.Meta) > maxServiceMetaKeys {
+		return fmt.Errorf("service %q has more than %d meta keys", name, maxServiceMetaKeys)
+	}
+
+	for key, value := range definition.Meta {
+		switch {
+		case key == "":
+			return fmt.Errorf("service %q has an empty meta key", name)
+		case strings.HasPrefix(key, "consul-"):
+			return fmt.Errorf("service %q meta key %q uses the reserved \"consul-\" prefix", name, key)
+		case len(key) > maxServiceMetaKeyLength:
+			return fmt.Errorf("service %q meta key %q is longer than %d characters", name, key, maxServiceMetaKeyLength)
+		case len(value) > maxServiceMetaValueLength:
+			return fmt.Errorf("service %q meta value for %q is longer than %d characters", name, key, maxServiceMetaValueLength)
+		}
+	}
+
+	return nil
+}
+
+func checkCollisions(definitions []ServiceDefinition) error {
+	ids := map[string]string{}
+	files := map[string]string{}
+	ports := map[string]string{}
+
+	for _, definition := range definitions {
+		id := definition.ID
+		if id == "" {
+			id = definition.Name
+		}
+		if other, ok := ids[id]; ok {
+			return fmt.Errorf("services %q and %q both use the id %q", other, definition.ServiceName, id)
+		}
+		ids[id] = definition.ServiceName
+
+		file := definitionFileName(definition)
+		if other, ok := files[file]; ok {
+			return fmt.Errorf("services %q and %q both write the definition file %q", other, definition.ServiceName, file)
+		}
+		files[file] = definition.ServiceName
+
+		if definition.Port != 0 {
+			namePort := fmt.Sprintf("%s:%d", definition.Name, definition.Port)
+			if other, ok := ports[namePort]; ok {
+				return fmt.Errorf("services %q and %q both register %q on port %d", other, definition.ServiceName, definition.Name, definition.Port)
+			}
+			ports[namePort] = definition.ServiceName
+		}
+	}
+
+	return nil
+}
+
+func definitionFileName(definition ServiceDefinition) string {
+	return filepath.Clean(fmt.Sprintf("service-%s.json", definition.ServiceName))
+}
+
+func defaultTags(node ConfigNode) []string {
+	tags := []string{fmt.Sprintf("%s-%d", strings.Replace(node.Name, "_", "-", -1), node.Index)}
+
+	if node.Deployment != "" {
+		tags = append(tags, fmt.Sprintf("deployment-%s", strings.Replace(node.Deployment, "_", "-", -1)))
+	}
+
+	if node.AZ != "" {
+		tags = append(tags, fmt.Sprintf("az-%s", strings.Replace(node.AZ, "_", "-", -1)))
+	}
+
+	return tags
 }
 
 func (s ServiceDefiner) WriteDefinitions(configDir string, definitions []ServiceDefinition) error {
 	for _, definition := range definitions {
-		path := filepath.Join(configDir, fmt.Sprintf("service-%s.json", definition.ServiceName))
+		path := filepath.Join(configDir, definitionFileName(definition))
 		s.Logger.Info("service-definer.write-definitions.write", lager.Data{
 			"path": path,
 		})
@@ -119,5 +392,38 @@ func (s ServiceDefiner) WriteDefinitions(configDir string, definitions []Service
 			"path": path,
 		})
 	}
+
+	return s.removeOrphans(configDir, definitions)
+}
+
+func (s ServiceDefiner) removeOrphans(configDir string, definitions []ServiceDefinition) error {
+	owned := map[string]bool{}
+	for _, definition := range definitions {
+		owned[filepath.Join(configDir, definitionFileName(definition))] = true
+	}
+
+	paths, err := filepath.Glob(filepath.Join(configDir, "service-*.json"))
+	if err != nil {
+		s.Logger.Error("service-definer.remove-orphans.glob.failed", err)
+		return err
+	}
+
+	for _, path := range paths {
+		if owned[path] {
+			continue
+		}
+
+		s.Logger.Info("service-definer.remove-orphans.remove", lager.Data{
+			"path": path,
+		})
+		if err := removeFile(path); err != nil {
+			err = errors.New(err.Error())
+			s.Logger.Error("service-definer.remove-orphans.remove.failed", err, lager.Data{
+				"path": path,
+			})
+			return err
+		}
+	}
+
 	return nil
 }
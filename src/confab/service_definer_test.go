@@ -7,6 +7,7 @@ import (
 	"fmt"
 	"io/ioutil"
 	"os"
+	"path/filepath"
 
 	"github.com/pivotal-golang/lager"
 
@@ -31,6 +32,7 @@ var _ = Describe("ServiceDefiner", func() {
 
 	AfterEach(func() {
 		confab.ResetCreateFile()
+		confab.ResetRemoveFile()
 	})
 
 	Describe("GenerateDefinitions", func() {
@@ -71,7 +73,7 @@ var _ = Describe("ServiceDefiner", func() {
 		})
 
 		It("generates a definition with the default values", func() {
-			definitions := definer.GenerateDefinitions(confab.Config{
+			definitions, err := definer.GenerateDefinitions(confab.Config{
 				Node: confab.ConfigNode{
 					Name:  "some_node",
 					Index: 0,
@@ -84,6 +86,7 @@ var _ = Describe("ServiceDefiner", func() {
 					},
 				},
 			})
+			Expect(err).NotTo(HaveOccurred())
 			Expect(definitions).To(ConsistOf([]confab.ServiceDefinition{
 				{
 					ServiceName: "router",
@@ -98,8 +101,321 @@ var _ = Describe("ServiceDefiner", func() {
 			}))
 		})
 
+		It("tags the definition with the deployment and az when they are known", func() {
+			definitions, err := definer.GenerateDefinitions(confab.Config{
+				Node: confab.ConfigNode{
+					Name:       "some_node",
+					Index:      0,
+					Deployment: "my_deployment",
+					AZ:         "z1",
+				},
+				Consul: confab.ConfigConsul{
+					Agent: confab.ConfigConsulAgent{
+						Services: map[string]confab.ServiceDefinition{
+							"router": {},
+						},
+					},
+				},
+			})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(definitions).To(HaveLen(1))
+			Expect(definitions[0].Tags).To(Equal([]string{"some-node-0", "deployment-my-deployment", "az-z1"}))
+		})
+
+		It("sorts the definitions by service name", func() {
+			definitions, err := definer.GenerateDefinitions(confab.Config{
+				Consul: confab.ConfigConsul{
+					Agent: confab.ConfigConsulAgent{
+						Services: map[string]confab.ServiceDefinition{
+							"router":           {},
+							"cloud_controller": {},
+							"doppler":          {},
+						},
+					},
+				},
+			})
+			Expect(err).NotTo(HaveOccurred())
+
+			var names []string
+			for _, definition := range definitions {
+				names = append(names, definition.ServiceName)
+			}
+			Expect(names).To(Equal([]string{"cloud_controller", "doppler", "router"}))
+		})
+
+		Context("when service fields contain templates", func() {
+			var config confab.Config
+
+			BeforeEach(func() {
+				config = confab.Config{
+					Node: confab.ConfigNode{
+						Name:       "router_z1",
+						Index:      2,
+						ExternalIP: "10.0.0.5",
+					},
+					Consul: confab.ConfigConsul{
+						Agent: confab.ConfigConsulAgent{
+							Services: map[string]confab.ServiceDefinition{
+								"router": {
+									Address: "{{.Node.ExternalIP}}",
+									Tags:    []string{"{{.Node.Name}}-{{.Node.Index}}", "static"},
+									Meta:    map[string]string{"instance": "{{.Node.Index}}"},
+									Check: &confab.ServiceDefinitionCheck{
+										Name:     "router_health",
+										HTTP:     "http://{{.Node.ExternalIP}}:8080/health",
+										Interval: "10s",
+									},
+									Checks: []confab.ServiceDefinitionCheck{
+										{Name: "router_tcp", TCP: "{{.Node.ExternalIP}}:80", Interval: "10s"},
+									},
+								},
+							},
+						},
+					},
+				}
+			})
+
+			It("expands the node values", func() {
+				definitions, err := definer.GenerateDefinitions(config)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(definitions).To(HaveLen(1))
+
+				definition := definitions[0]
+				Expect(definition.Address).To(Equal("10.0.0.5"))
+				Expect(definition.Tags).To(Equal([]string{"router_z1-2", "static"}))
+				Expect(definition.Meta).To(Equal(map[string]string{"instance": "2"}))
+				Expect(definition.Check.HTTP).To(Equal("http://10.0.0.5:8080/health"))
+				Expect(definition.Checks[0].TCP).To(Equal("10.0.0.5:80"))
+			})
+
+			It("does not modify the configured services", func() {
+				_, err := definer.GenerateDefinitions(config)
+				Expect(err).NotTo(HaveOccurred())
+
+				service := config.Consul.Agent.Services["router"]
+				Expect(service.Tags[0]).To(Equal("{{.Node.Name}}-{{.Node.Index}}"))
+				Expect(service.Meta["instance"]).To(Equal("{{.Node.Index}}"))
+				Expect(service.Check.HTTP).To(Equal("http://{{.Node.ExternalIP}}:8080/health"))
+				Expect(service.Checks[0].TCP).To(Equal("{{.Node.ExternalIP}}:80"))
+			})
+
+			Context("failure cases", func() {
+				It("returns an error when a template cannot be parsed", func() {
+					config.Consul.Agent.Services["router"] = confab.ServiceDefinition{Address: "{{.Node.ExternalIP"}
+
+					_, err := definer.GenerateDefinitions(config)
+					Expect(err).To(HaveOccurred())
+					Expect(err.Error()).To(HavePrefix(`service "router" has an invalid template in address:`))
+					Expect(logger.Messages).To(ContainElement(fakes.LoggerMessage{
+						Action: "service-definer.generate-definitions.expand-templates.failed",
+						Error:  err,
+						Data: []lager.Data{{
+							"service": "router",
+						}},
+					}))
+				})
+
+				It("returns an error when a template references an unknown field", func() {
+					config.Consul.Agent.Services["router"] = confab.ServiceDefinition{Tags: []string{"{{.Node.Zone}}"}}
+
+					_, err := definer.GenerateDefinitions(config)
+					Expect(err).To(HaveOccurred())
+					Expect(err.Error()).To(HavePrefix(`service "router" has an invalid template in tags[0]:`))
+				})
+			})
+		})
+
+		Context("when services use connect", func() {
+			var config confab.Config
+
+			BeforeEach(func() {
+				config = confab.Config{
+					Consul: confab.ConfigConsul{
+						Agent: confab.ConfigConsulAgent{
+							Connect: confab.ConfigConsulAgentConnect{
+								Enabled: true,
+							},
+							Services: map[string]confab.ServiceDefinition{
+								"router": {
+									Port: 80,
+									Meta: map[string]string{"version": "1"},
+									Weights: &confab.ServiceDefinitionWeights{
+										Passing: 10,
+										Warning: 1,
+									},
+									Connect: &confab.ServiceDefinitionConnect{
+										SidecarService: &confab.ServiceDefinition{
+											Proxy: &confab.ServiceDefinitionProxy{
+												Upstreams: []confab.ServiceDefinitionUpstream{
+													{DestinationName: "uaa", LocalBindPort: 9001},
+												},
+											},
+										},
+									},
+								},
+							},
+						},
+					},
+				}
+			})
+
+			It("passes the connect, meta and weights fields through", func() {
+				definitions, err := definer.GenerateDefinitions(config)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(definitions).To(HaveLen(1))
+				Expect(definitions[0].Meta).To(Equal(map[string]string{"version": "1"}))
+				Expect(definitions[0].Weights).To(Equal(&confab.ServiceDefinitionWeights{Passing: 10, Warning: 1}))
+				Expect(definitions[0].Connect).To(Equal(config.Consul.Agent.Services["router"].Connect))
+			})
+
+			It("accepts a connect-proxy with a destination and a port", func() {
+				config.Consul.Agent.Services["router-proxy"] = confab.ServiceDefinition{
+					Kind: "connect-proxy",
+					Port: 21000,
+					Proxy: &confab.ServiceDefinitionProxy{
+						DestinationServiceName: "router",
+						LocalServicePort:       80,
+					},
+				}
+
+				definitions, err := definer.GenerateDefinitions(config)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(definitions[1].Kind).To(Equal("connect-proxy"))
+				Expect(definitions[1].Proxy.DestinationServiceName).To(Equal("router"))
+			})
+
+			Context("failure cases", func() {
+				It("returns an error when connect is not enabled on the agent", func() {
+					config.Consul.Agent.Connect.Enabled = false
+
+					_, err := definer.GenerateDefinitions(config)
+					Expect(err).To(MatchError(`service "router" uses connect but consul.agent.connect.enabled is false`))
+					Expect(logger.Messages).To(ContainElement(fakes.LoggerMessage{
+						Action: "service-definer.generate-definitions.invalid",
+						Error:  err,
+						Data: []lager.Data{{
+							"service": "router",
+						}},
+					}))
+				})
+
+				It("returns an error for an unknown kind", func() {
+					config.Consul.Agent.Services["router"] = confab.ServiceDefinition{Kind: "mesh-thing"}
+
+					_, err := definer.GenerateDefinitions(config)
+					Expect(err).To(MatchError(`service "router" has unknown kind "mesh-thing"`))
+				})
+
+				It("returns an error when a connect-proxy has no destination", func() {
+					config.Consul.Agent.Services["router"] = confab.ServiceDefinition{Kind: "connect-proxy", Port: 21000}
+
+					_, err := definer.GenerateDefinitions(config)
+					Expect(err).To(MatchError(`service "router" is a connect-proxy and is missing proxy.destination_service_name`))
+				})
+
+				It("returns an error when a proxy is set on a regular service", func() {
+					config.Consul.Agent.Services["router"] = confab.ServiceDefinition{
+						Proxy: &confab.ServiceDefinitionProxy{DestinationServiceName: "router"},
+					}
+
+					_, err := definer.GenerateDefinitions(config)
+					Expect(err).To(MatchError(`service "router" sets proxy but is not of kind "connect-proxy"`))
+				})
+
+				It("returns an error when a service is native and has a sidecar", func() {
+					config.Consul.Agent.Services["router"].Connect.Native = true
+
+					_, err := definer.GenerateDefinitions(config)
+					Expect(err).To(MatchError(`service "router" cannot be connect native and have a sidecar_service`))
+				})
+
+				It("returns an error when an upstream is incomplete", func() {
+					config.Consul.Agent.Services["router"].Connect.SidecarService.Proxy.Upstreams[0].LocalBindPort = 0
+
+					_, err := definer.GenerateDefinitions(config)
+					Expect(err).To(MatchError(`service "router" upstream 0 needs a destination_name and a local_bind_port`))
+				})
+
+				It("returns an error when the weights are invalid", func() {
+					config.Consul.Agent.Services["router"].Weights.Passing = 0
+
+					_, err := definer.GenerateDefinitions(config)
+					Expect(err).To(MatchError(`service "router" weights must have passing >= 1 and warning >= 0`))
+				})
+
+				It("returns an error when a meta key uses the reserved prefix", func() {
+					config.Consul.Agent.Services["router"].Meta["consul-version"] = "1"
+
+					_, err := definer.GenerateDefinitions(config)
+					Expect(err).To(MatchError(`service "router" meta key "consul-version" uses the reserved "consul-" prefix`))
+				})
+			})
+		})
+
+		Context("when definitions collide", func() {
+			It("returns an error when two services have the same name after dasherizing", func() {
+				_, err := definer.GenerateDefinitions(confab.Config{
+					Consul: confab.ConfigConsul{
+						Agent: confab.ConfigConsulAgent{
+							Services: map[string]confab.ServiceDefinition{
+								"cloud_controller": {},
+								"cloud-controller": {},
+							},
+						},
+					},
+				})
+				Expect(err).To(MatchError(`services "cloud-controller" and "cloud_controller" both use the id "cloud-controller"`))
+				Expect(logger.Messages).To(ContainElement(fakes.LoggerMessage{
+					Action: "service-definer.generate-definitions.collision",
+					Error:  err,
+				}))
+			})
+
+			It("returns an error when two services have the same explicit id", func() {
+				_, err := definer.GenerateDefinitions(confab.Config{
+					Consul: confab.ConfigConsul{
+						Agent: confab.ConfigConsulAgent{
+							Services: map[string]confab.ServiceDefinition{
+								"router":  {ID: "shared"},
+								"doppler": {ID: "shared"},
+							},
+						},
+					},
+				})
+				Expect(err).To(MatchError(`services "doppler" and "router" both use the id "shared"`))
+			})
+
+			It("returns an error when two services write the same definition file", func() {
+				_, err := definer.GenerateDefinitions(confab.Config{
+					Consul: confab.ConfigConsul{
+						Agent: confab.ConfigConsulAgent{
+							Services: map[string]confab.ServiceDefinition{
+								"router":              {ID: "router-1"},
+								"x/../service-router": {ID: "router-2"},
+							},
+						},
+					},
+				})
+				Expect(err).To(MatchError(`services "router" and "x/../service-router" both write the definition file "service-router.json"`))
+			})
+
+			It("returns an error when two services register the same name on the same port", func() {
+				_, err := definer.GenerateDefinitions(confab.Config{
+					Consul: confab.ConfigConsul{
+						Agent: confab.ConfigConsulAgent{
+							Services: map[string]confab.ServiceDefinition{
+								"router_a": {Name: "router", ID: "router-a", Port: 80},
+								"router_b": {Name: "router", ID: "router-b", Port: 80},
+							},
+						},
+					},
+				})
+				Expect(err).To(MatchError(`services "router_a" and "router_b" both register "router" on port 80`))
+			})
+		})
+
 		It("generates a definition with the service name dasherized", func() {
-			definitions := definer.GenerateDefinitions(confab.Config{
+			definitions, err := definer.GenerateDefinitions(confab.Config{
 				Node: confab.ConfigNode{
 					Name:  "some_node",
 					Index: 0,
@@ -112,6 +428,7 @@ var _ = Describe("ServiceDefiner", func() {
 					},
 				},
 			})
+			Expect(err).NotTo(HaveOccurred())
 			Expect(definitions).To(ConsistOf([]confab.ServiceDefinition{
 				{
 					ServiceName: "cloud_controller",
@@ -127,7 +444,7 @@ var _ = Describe("ServiceDefiner", func() {
 		})
 
 		It("generates a definition with the check field overridden", func() {
-			definitions := definer.GenerateDefinitions(confab.Config{
+			definitions, err := definer.GenerateDefinitions(confab.Config{
 				Node: confab.ConfigNode{
 					Name:  "some_node",
 					Index: 0,
@@ -146,6 +463,7 @@ var _ = Describe("ServiceDefiner", func() {
 					},
 				},
 			})
+			Expect(err).NotTo(HaveOccurred())
 			Expect(definitions).To(ConsistOf([]confab.ServiceDefinition{
 				{
 					ServiceName: "doppler",
@@ -161,7 +479,7 @@ var _ = Describe("ServiceDefiner", func() {
 		})
 
 		It("generates a definition with the checks field specified", func() {
-			definitions := definer.GenerateDefinitions(confab.Config{
+			definitions, err := definer.GenerateDefinitions(confab.Config{
 				Node: confab.ConfigNode{
 					Name:  "some_node",
 					Index: 0,
@@ -180,6 +498,7 @@ var _ = Describe("ServiceDefiner", func() {
 					},
 				},
 			})
+			Expect(err).NotTo(HaveOccurred())
 			Expect(definitions).To(ConsistOf([]confab.ServiceDefinition{
 				{
 					ServiceName: "uaa",
@@ -200,7 +519,7 @@ var _ = Describe("ServiceDefiner", func() {
 		})
 
 		It("generates a definition with the name field overridden", func() {
-			definitions := definer.GenerateDefinitions(confab.Config{
+			definitions, err := definer.GenerateDefinitions(confab.Config{
 				Node: confab.ConfigNode{
 					Name:  "some_node",
 					Index: 0,
@@ -215,6 +534,7 @@ var _ = Describe("ServiceDefiner", func() {
 					},
 				},
 			})
+			Expect(err).NotTo(HaveOccurred())
 			Expect(definitions).To(ConsistOf([]confab.ServiceDefinition{
 				{
 					ServiceName: "cell",
@@ -230,7 +550,7 @@ var _ = Describe("ServiceDefiner", func() {
 		})
 
 		It("generates a definition with the tag field overridden", func() {
-			definitions := definer.GenerateDefinitions(confab.Config{
+			definitions, err := definer.GenerateDefinitions(confab.Config{
 				Node: confab.ConfigNode{
 					Name:  "some_node",
 					Index: 0,
@@ -245,6 +565,7 @@ var _ = Describe("ServiceDefiner", func() {
 					},
 				},
 			})
+			Expect(err).NotTo(HaveOccurred())
 			Expect(definitions).To(ConsistOf([]confab.ServiceDefinition{
 				{
 					ServiceName: "dea",
@@ -260,7 +581,7 @@ var _ = Describe("ServiceDefiner", func() {
 		})
 
 		It("generates definitions with the address field specified", func() {
-			definitions := definer.GenerateDefinitions(confab.Config{
+			definitions, err := definer.GenerateDefinitions(confab.Config{
 				Node: confab.ConfigNode{
 					Name:  "some_node",
 					Index: 0,
@@ -275,6 +596,7 @@ var _ = Describe("ServiceDefiner", func() {
 					},
 				},
 			})
+			Expect(err).NotTo(HaveOccurred())
 			Expect(definitions).To(ConsistOf([]confab.ServiceDefinition{
 				{
 					ServiceName: "dea",
@@ -291,7 +613,7 @@ var _ = Describe("ServiceDefiner", func() {
 		})
 
 		It("generates definitions with the port field specified", func() {
-			definitions := definer.GenerateDefinitions(confab.Config{
+			definitions, err := definer.GenerateDefinitions(confab.Config{
 				Node: confab.ConfigNode{
 					Name:  "some_node",
 					Index: 0,
@@ -306,6 +628,7 @@ var _ = Describe("ServiceDefiner", func() {
 					},
 				},
 			})
+			Expect(err).NotTo(HaveOccurred())
 			Expect(definitions).To(ConsistOf([]confab.ServiceDefinition{
 				{
 					ServiceName: "router",
@@ -322,7 +645,7 @@ var _ = Describe("ServiceDefiner", func() {
 		})
 
 		It("generates definitions with the EnableTagOverride field specified", func() {
-			definitions := definer.GenerateDefinitions(confab.Config{
+			definitions, err := definer.GenerateDefinitions(confab.Config{
 				Node: confab.ConfigNode{
 					Name:  "some_node",
 					Index: 0,
@@ -337,6 +660,7 @@ var _ = Describe("ServiceDefiner", func() {
 					},
 				},
 			})
+			Expect(err).NotTo(HaveOccurred())
 			Expect(definitions).To(ConsistOf([]confab.ServiceDefinition{
 				{
 					ServiceName:       "router",
@@ -353,7 +677,7 @@ var _ = Describe("ServiceDefiner", func() {
 		})
 
 		It("generates definitions with the Id field specified", func() {
-			definitions := definer.GenerateDefinitions(confab.Config{
+			definitions, err := definer.GenerateDefinitions(confab.Config{
 				Node: confab.ConfigNode{
 					Name:  "some_node",
 					Index: 0,
@@ -368,6 +692,7 @@ var _ = Describe("ServiceDefiner", func() {
 					},
 				},
 			})
+			Expect(err).NotTo(HaveOccurred())
 			Expect(definitions).To(ConsistOf([]confab.ServiceDefinition{
 				{
 					ServiceName: "router",
@@ -384,7 +709,7 @@ var _ = Describe("ServiceDefiner", func() {
 		})
 
 		It("generates definitions with the Token field specified", func() {
-			definitions := definer.GenerateDefinitions(confab.Config{
+			definitions, err := definer.GenerateDefinitions(confab.Config{
 				Node: confab.ConfigNode{
 					Name:  "some_node",
 					Index: 0,
@@ -399,6 +724,7 @@ var _ = Describe("ServiceDefiner", func() {
 					},
 				},
 			})
+			Expect(err).NotTo(HaveOccurred())
 			Expect(definitions).To(ConsistOf([]confab.ServiceDefinition{
 				{
 					ServiceName: "router",
@@ -415,7 +741,7 @@ var _ = Describe("ServiceDefiner", func() {
 		})
 
 		It("generates definitions with a check type given the overrides", func() {
-			definitions := definer.GenerateDefinitions(confab.Config{
+			definitions, err := definer.GenerateDefinitions(confab.Config{
 				Node: confab.ConfigNode{
 					Name:  "some_node",
 					Index: 0,
@@ -444,6 +770,7 @@ var _ = Describe("ServiceDefiner", func() {
 					},
 				},
 			})
+			Expect(err).NotTo(HaveOccurred())
 			Expect(definitions).To(ConsistOf([]confab.ServiceDefinition{
 				{
 					ServiceName: "router",
@@ -655,6 +982,59 @@ var _ = Describe("ServiceDefiner", func() {
 			}`))
 		})
 
+		Context("when the config dir contains definitions for services that were removed", func() {
+			BeforeEach(func() {
+				for _, name := range []string{"service-router.json", "service-doppler.json", "config.json"} {
+					Expect(ioutil.WriteFile(filepath.Join(tempDir, name), []byte("{}"), 0644)).To(Succeed())
+				}
+			})
+
+			It("removes the orphaned definition files and leaves other files alone", func() {
+				err := definer.WriteDefinitions(tempDir, []confab.ServiceDefinition{
+					{
+						ServiceName: "router",
+						Name:        "router",
+					},
+				})
+				Expect(err).NotTo(HaveOccurred())
+
+				_, err = os.Stat(filepath.Join(tempDir, "service-router.json"))
+				Expect(err).NotTo(HaveOccurred())
+				_, err = os.Stat(filepath.Join(tempDir, "config.json"))
+				Expect(err).NotTo(HaveOccurred())
+				_, err = os.Stat(filepath.Join(tempDir, "service-doppler.json"))
+				Expect(os.IsNotExist(err)).To(BeTrue())
+
+				Expect(logger.Messages).To(ContainElement(fakes.LoggerMessage{
+					Action: "service-definer.remove-orphans.remove",
+					Data: []lager.Data{{
+						"path": filepath.Join(tempDir, "service-doppler.json"),
+					}},
+				}))
+			})
+
+			It("returns an error when an orphan cannot be removed", func() {
+				confab.SetRemoveFile(func(path string) error {
+					return errors.New("remove failed")
+				})
+
+				err := definer.WriteDefinitions(tempDir, []confab.ServiceDefinition{
+					{
+						ServiceName: "router",
+						Name:        "router",
+					},
+				})
+				Expect(err).To(MatchError("remove failed"))
+				Expect(logger.Messages).To(ContainElement(fakes.LoggerMessage{
+					Action: "service-definer.remove-orphans.remove.failed",
+					Error:  errors.New("remove failed"),
+					Data: []lager.Data{{
+						"path": filepath.Join(tempDir, "service-doppler.json"),
+					}},
+				}))
+			})
+		})
+
 		Context("failure cases", func() {
 			It("errors when the file cannot be created", func() {
 				err := definer.WriteDefinitions("/some/random/path", []confab.ServiceDefinition{